@@ -0,0 +1,387 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// maxAudienceListName is the longest name an audience list can have
+const maxAudienceListName = 50
+
+// audienceListRequest is the body of the audience list endpoints
+type audienceListRequest struct {
+	Name    string `json:"name"`
+	UserIDs []int  `json:"userIds"`
+}
+
+// validAudienceListName trims a list name and checks its length
+func validAudienceListName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != "" && utf8.RuneCountInString(name) <= maxAudienceListName
+}
+
+// ownedAudienceList reads the list ID from the path and checks the user of
+// the session owns it. It responds with an error and returns 0 otherwise.
+func ownedAudienceList(w http.ResponseWriter, r *http.Request) (listID, userID int) {
+	listID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid list ID", http.StatusBadRequest)
+		return 0, 0
+	}
+
+	userID = sessionUserID(w, r)
+	if userID == 0 {
+		return 0, 0
+	}
+
+	var owned bool
+	err = sqlite.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM audience_lists WHERE id = ? AND owner_id = ?)`,
+		listID, userID).Scan(&owned)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return 0, 0
+	}
+	// Other users' lists are reported as missing, their names are private
+	if !owned {
+		sendJSONError(w, "Audience list not found", http.StatusNotFound)
+		return 0, 0
+	}
+	return listID, userID
+}
+
+// audienceListNameTaken reports whether the owner already has another list
+// with that name
+func audienceListNameTaken(ownerID, listID int, name string) (bool, error) {
+	var taken bool
+	err := sqlite.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM audience_lists
+			WHERE owner_id = ? AND name = ? AND id != ?
+		)`, ownerID, name, listID).Scan(&taken)
+	return taken, err
+}
+
+// addAudienceListMembers adds users to a list, skipping the ones already in
+// it. The owner, unknown users and users blocked either way are rejected.
+func addAudienceListMembers(tx *sql.Tx, listID, ownerID int, userIDs []int) error {
+	for _, memberID := range userIDs {
+		if memberID == ownerID {
+			return &statusError{http.StatusBadRequest, "You can't add yourself to a list"}
+		}
+
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", memberID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return &statusError{http.StatusBadRequest, "User " + strconv.Itoa(memberID) + " not found"}
+		}
+
+		rel, err := policy.LoadRelation(tx, ownerID, memberID)
+		if err != nil {
+			return err
+		}
+		if rel.Blocked {
+			return &statusError{http.StatusBadRequest, "User " + strconv.Itoa(memberID) + " not found"}
+		}
+
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO audience_list_members (list_id, user_id)
+			VALUES (?, ?)`, listID, memberID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAudienceList loads a list with its members
+func getAudienceList(listID int) (*m.AudienceList, error) {
+	var list m.AudienceList
+	err := sqlite.DB.QueryRow(`
+		SELECT id, owner_id, name, created_at
+		FROM audience_lists
+		WHERE id = ?`, listID).Scan(&list.ID, &list.OwnerID, &list.Name, &list.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT u.id, u.username, u.first_name, u.last_name, COALESCE(u.avatar, ''), am.added_at
+		FROM audience_list_members am
+		JOIN users u ON u.id = am.user_id
+		WHERE am.list_id = ?
+		ORDER BY u.first_name, u.last_name, u.id`, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list.Members = []m.AudienceListMember{}
+	for rows.Next() {
+		var member m.AudienceListMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.FirstName, &member.LastName, &member.Avatar, &member.AddedAt); err != nil {
+			return nil, err
+		}
+		list.Members = append(list.Members, member)
+	}
+	list.MemberCount = len(list.Members)
+	return &list, rows.Err()
+}
+
+// respondAudienceList sends a list with its members
+func respondAudienceList(w http.ResponseWriter, status, listID int) {
+	list, err := getAudienceList(listID)
+	if err != nil {
+		log.Printf("Error loading audience list %d: %v", listID, err)
+		sendJSONError(w, "Failed to load audience list", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, status, list)
+}
+
+// GetAudienceLists lists the audience lists of the user of the session
+func GetAudienceLists(w http.ResponseWriter, r *http.Request) {
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT l.id, l.owner_id, l.name, l.created_at,
+			(SELECT COUNT(*) FROM audience_list_members am WHERE am.list_id = l.id)
+		FROM audience_lists l
+		WHERE l.owner_id = ?
+		ORDER BY l.name`, userID)
+	if err != nil {
+		log.Printf("Error getting audience lists: %v", err)
+		sendJSONError(w, "Failed to get audience lists", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	lists := []m.AudienceList{}
+	for rows.Next() {
+		var list m.AudienceList
+		if err := rows.Scan(&list.ID, &list.OwnerID, &list.Name, &list.CreatedAt, &list.MemberCount); err != nil {
+			log.Printf("Error scanning audience list: %v", err)
+			sendJSONError(w, "Failed to get audience lists", http.StatusInternalServerError)
+			return
+		}
+		lists = append(lists, list)
+	}
+	if err := rows.Err(); err != nil {
+		sendJSONError(w, "Failed to get audience lists", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, lists)
+}
+
+// CreateAudienceList creates a named list, optionally with its first members
+func CreateAudienceList(w http.ResponseWriter, r *http.Request) {
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	var req audienceListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, ok := validAudienceListName(req.Name)
+	if !ok {
+		sendJSONError(w, "List name must be 1 to 50 characters", http.StatusBadRequest)
+		return
+	}
+
+	taken, err := audienceListNameTaken(userID, 0, name)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		sendJSONError(w, "You already have a list with this name", http.StatusConflict)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO audience_lists (owner_id, name) VALUES (?, ?)", userID, name)
+	if err != nil {
+		log.Printf("Error creating audience list: %v", err)
+		sendJSONError(w, "Failed to create audience list", http.StatusInternalServerError)
+		return
+	}
+	listID, err := result.LastInsertId()
+	if err != nil {
+		sendJSONError(w, "Failed to create audience list", http.StatusInternalServerError)
+		return
+	}
+
+	if err := addAudienceListMembers(tx, int(listID), userID, req.UserIDs); err != nil {
+		writeStatusError(w, err, "Failed to create audience list")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to create audience list", http.StatusInternalServerError)
+		return
+	}
+
+	respondAudienceList(w, http.StatusCreated, int(listID))
+}
+
+// GetAudienceList returns one of the lists of the user of the session with
+// its members
+func GetAudienceList(w http.ResponseWriter, r *http.Request) {
+	listID, _ := ownedAudienceList(w, r)
+	if listID == 0 {
+		return
+	}
+	respondAudienceList(w, http.StatusOK, listID)
+}
+
+// RenameAudienceList changes the name of a list
+func RenameAudienceList(w http.ResponseWriter, r *http.Request) {
+	listID, userID := ownedAudienceList(w, r)
+	if listID == 0 {
+		return
+	}
+
+	var req audienceListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, ok := validAudienceListName(req.Name)
+	if !ok {
+		sendJSONError(w, "List name must be 1 to 50 characters", http.StatusBadRequest)
+		return
+	}
+
+	taken, err := audienceListNameTaken(userID, listID, name)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		sendJSONError(w, "You already have a list with this name", http.StatusConflict)
+		return
+	}
+
+	if _, err := sqlite.DB.Exec("UPDATE audience_lists SET name = ? WHERE id = ?", name, listID); err != nil {
+		log.Printf("Error renaming audience list: %v", err)
+		sendJSONError(w, "Failed to rename audience list", http.StatusInternalServerError)
+		return
+	}
+
+	respondAudienceList(w, http.StatusOK, listID)
+}
+
+// DeleteAudienceList deletes a list. Posts shared with it stay visible to
+// their author and the users selected one by one only.
+func DeleteAudienceList(w http.ResponseWriter, r *http.Request) {
+	listID, _ := ownedAudienceList(w, r)
+	if listID == 0 {
+		return
+	}
+
+	if _, err := sqlite.DB.Exec("DELETE FROM audience_lists WHERE id = ?", listID); err != nil {
+		log.Printf("Error deleting audience list: %v", err)
+		sendJSONError(w, "Failed to delete audience list", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Audience list deleted",
+		"id":      listID,
+	})
+}
+
+// AddAudienceListMembers adds users to a list. They can see the posts shared
+// with the list right away, including earlier ones.
+func AddAudienceListMembers(w http.ResponseWriter, r *http.Request) {
+	listID, userID := ownedAudienceList(w, r)
+	if listID == 0 {
+		return
+	}
+
+	var req audienceListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.UserIDs) == 0 {
+		sendJSONError(w, "No users to add", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := addAudienceListMembers(tx, listID, userID, req.UserIDs); err != nil {
+		writeStatusError(w, err, "Failed to add members")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to add members", http.StatusInternalServerError)
+		return
+	}
+
+	respondAudienceList(w, http.StatusOK, listID)
+}
+
+// RemoveAudienceListMember removes a user from a list, which takes away their
+// access to the posts shared with it
+func RemoveAudienceListMember(w http.ResponseWriter, r *http.Request) {
+	listID, _ := ownedAudienceList(w, r)
+	if listID == 0 {
+		return
+	}
+
+	memberID, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := sqlite.DB.Exec(`
+		DELETE FROM audience_list_members
+		WHERE list_id = ? AND user_id = ?`, listID, memberID)
+	if err != nil {
+		log.Printf("Error removing audience list member: %v", err)
+		sendJSONError(w, "Failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		sendJSONError(w, "User is not in this list", http.StatusNotFound)
+		return
+	}
+
+	respondAudienceList(w, http.StatusOK, listID)
+}
@@ -241,17 +241,18 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Create response without sensitive data
 	response := map[string]interface{}{
-		"id":          user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"firstName":   user.FirstName,
-		"lastName":    user.LastName,
-		"avatar":      user.Avatar,
-		"aboutMe":     user.AboutMe,
-		"isPrivate":   user.IsPrivate,
-		"dateOfBirth": user.DateOfBirth.Format("2006-01-02"),
-		"status":      "success",
-		"message":     "Login successful",
+		"id":               user.ID,
+		"username":         user.Username,
+		"email":            user.Email,
+		"firstName":        user.FirstName,
+		"lastName":         user.LastName,
+		"avatar":           user.Avatar,
+		"avatarThumbnails": avatarThumbnails(user.Avatar),
+		"aboutMe":          user.AboutMe,
+		"isPrivate":        user.IsPrivate,
+		"dateOfBirth":      user.DateOfBirth.Format("2006-01-02"),
+		"status":           "success",
+		"message":          "Login successful",
 	}
 
 	// Set status code before writing response
@@ -333,15 +334,16 @@ func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 
 	// Create response without sensitive data
 	response := map[string]interface{}{
-		"id":          user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"firstName":   user.FirstName,
-		"lastName":    user.LastName,
-		"avatar":      user.Avatar,
-		"aboutMe":     user.AboutMe,
-		"isPrivate":   user.IsPrivate,
-		"dateOfBirth": user.DateOfBirth.Format("2006-01-02"),
+		"id":               user.ID,
+		"username":         user.Username,
+		"email":            user.Email,
+		"firstName":        user.FirstName,
+		"lastName":         user.LastName,
+		"avatar":           user.Avatar,
+		"avatarThumbnails": avatarThumbnails(user.Avatar),
+		"aboutMe":          user.AboutMe,
+		"isPrivate":        user.IsPrivate,
+		"dateOfBirth":      user.DateOfBirth.Format("2006-01-02"),
 	}
 
 	w.WriteHeader(http.StatusOK)
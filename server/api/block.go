@@ -0,0 +1,197 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/util"
+)
+
+// BlockedUser is an entry of the blocked users list in the settings
+type BlockedUser struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Avatar    string    `json:"avatar"`
+	BlockedAt time.Time `json:"blockedAt"`
+}
+
+// sessionUserID returns the ID of the user of the session, or 0 after
+// responding with an error
+func sessionUserID(w http.ResponseWriter, r *http.Request) int {
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return 0
+	}
+
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return 0
+	}
+	return userID
+}
+
+// BlockUser blocks the user in the path for the user of the session. Follows
+// between them are removed and pending group invitations between them are
+// dropped.
+func BlockUser(w http.ResponseWriter, r *http.Request) {
+	targetID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if userID == targetID {
+		sendJSONError(w, "Cannot block yourself", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", targetID).Scan(&exists)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		sendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id)
+		VALUES (?, ?)`, userID, targetID)
+	if err != nil {
+		log.Printf("Error blocking user: %v", err)
+		sendJSONError(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	// Follows and follow requests go both ways
+	_, err = tx.Exec(`
+		DELETE FROM followers
+		WHERE (follower_id = ? AND followed_id = ?)
+			OR (follower_id = ? AND followed_id = ?)`,
+		userID, targetID, targetID, userID)
+	if err != nil {
+		log.Printf("Error removing follows: %v", err)
+		sendJSONError(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM group_invitations
+		WHERE type = 'invitation' AND status = 'pending'
+			AND ((inviter_id = ? AND invitee_id = ?) OR (inviter_id = ? AND invitee_id = ?))`,
+		userID, targetID, targetID, userID)
+	if err != nil {
+		log.Printf("Error removing group invitations: %v", err)
+		sendJSONError(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "User blocked",
+		"userId":  targetID,
+	})
+}
+
+// UnblockUser removes a block the user of the session created. Follows
+// removed by the block are not restored.
+func UnblockUser(w http.ResponseWriter, r *http.Request) {
+	targetID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	result, err := sqlite.DB.Exec(`
+		DELETE FROM user_blocks
+		WHERE blocker_id = ? AND blocked_id = ?`, userID, targetID)
+	if err != nil {
+		log.Printf("Error unblocking user: %v", err)
+		sendJSONError(w, "Failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		sendJSONError(w, "User is not blocked", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "User unblocked",
+		"userId":  targetID,
+	})
+}
+
+// GetBlockedUsers lists the users the user of the session blocked, most
+// recent first
+func GetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT u.id, u.username, u.first_name, u.last_name, u.avatar, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = ?
+		ORDER BY b.created_at DESC, b.id DESC`, userID)
+	if err != nil {
+		log.Printf("Error getting blocked users: %v", err)
+		sendJSONError(w, "Failed to get blocked users", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	blocked := []BlockedUser{}
+	for rows.Next() {
+		var user BlockedUser
+		var avatar sql.NullString
+		if err := rows.Scan(&user.ID, &user.Username, &user.FirstName, &user.LastName, &avatar, &user.BlockedAt); err != nil {
+			log.Printf("Error scanning blocked user: %v", err)
+			sendJSONError(w, "Failed to get blocked users", http.StatusInternalServerError)
+			return
+		}
+		user.Avatar = avatar.String
+		blocked = append(blocked, user)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating blocked users: %v", err)
+		sendJSONError(w, "Failed to get blocked users", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, blocked)
+}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/entities"
+	"social-network/pkg/policy"
 	"social-network/util"
 	"strconv"
 	"time"
@@ -61,18 +63,8 @@ func CreateOrGetDirectChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if there's at least one follow relationship between users (either user following the other)
-	var followExists bool
-	err = sqlite.DB.QueryRow(`
-        SELECT EXISTS (
-            SELECT 1 FROM followers 
-            WHERE ((follower_id = ? AND followed_id = ?) 
-            OR (follower_id = ? AND followed_id = ?))
-            AND status = 'accepted'
-        )`,
-		currentUser.ID, req.UserId, req.UserId, currentUser.ID,
-	).Scan(&followExists)
-
+	// Check the messaging policy, at least one user has to follow the other
+	followExists, err := policy.CanUsersMessage(sqlite.DB, currentUser.ID, req.UserId)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -151,7 +143,12 @@ func GetUserChats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get all chats for the user - direct chats where users follow each other, and group chats where user is a member
+	// Get all chats for the user - direct chats with users the messaging policy
+	// allows, and group chats where user is a member
+	messageable, messageableArgs := policy.MessageableCondition("ucs2.user_id", userId)
+	args := []interface{}{userId, userId}
+	args = append(args, messageableArgs...)
+	args = append(args, userId)
 	rows, err := sqlite.DB.Query(`
         SELECT
             c.id,
@@ -172,24 +169,11 @@ func GetUserChats(w http.ResponseWriter, r *http.Request) {
         FROM chats c
         JOIN user_chat_status ucs ON c.id = ucs.chat_id AND ucs.user_id = ?
         WHERE 
-            -- For direct chats: Only show where users follow each other
+            -- For direct chats: Only show where the users can message each other
             (c.type = 'direct' AND EXISTS (
                 SELECT 1 
                 FROM user_chat_status ucs2
-                WHERE ucs2.chat_id = c.id AND ucs2.user_id != ?
-                AND (
-                    -- Either current user follows the other user
-                    EXISTS (
-                        SELECT 1 FROM followers 
-                        WHERE follower_id = ? AND followed_id = ucs2.user_id AND status = 'accepted'
-                    )
-                    OR 
-                    -- Or the other user follows the current user
-                    EXISTS (
-                        SELECT 1 FROM followers 
-                        WHERE follower_id = ucs2.user_id AND followed_id = ? AND status = 'accepted'
-                    )
-                )
+                WHERE ucs2.chat_id = c.id AND `+messageable+`
             ))
             OR 
             -- For group chats: Only show if user is a member of the group
@@ -200,7 +184,7 @@ func GetUserChats(w http.ResponseWriter, r *http.Request) {
                 WHERE g.chat_id = c.id AND gm.user_id = ?
             ))
         ORDER BY last_message_time DESC NULLS LAST
-    `, userId, userId, userId, userId, userId, userId)
+    `, args...)
 
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -323,7 +307,8 @@ func GetUserChats(w http.ResponseWriter, r *http.Request) {
 		chats = append(chats, chatItem)
 	}
 
-	// Now get all users who don't have a chat yet but either the current user follows them or they follow the current user
+	// Now get all users who don't have a chat yet but the current user can message
+	messageable, messageableArgs = policy.MessageableCondition("u.id", userId)
 	rows, err = sqlite.DB.Query(`
         SELECT
             u.id,
@@ -332,18 +317,7 @@ func GetUserChats(w http.ResponseWriter, r *http.Request) {
             u.username,
             u.avatar
         FROM users u
-        WHERE u.id != ? AND u.id IN (
-            -- Users who either follow or are followed by the current user
-            SELECT f.followed_id 
-            FROM followers f
-            WHERE f.follower_id = ? 
-            AND f.status = 'accepted'
-            UNION
-            SELECT f.follower_id 
-            FROM followers f
-            WHERE f.followed_id = ? 
-            AND f.status = 'accepted'
-        )
+        WHERE `+messageable+`
         -- Exclude users who already have a chat with current user
         AND u.id NOT IN (
             SELECT ucs2.user_id 
@@ -354,7 +328,7 @@ func GetUserChats(w http.ResponseWriter, r *http.Request) {
                 WHERE c.id = ucs1.chat_id AND c.type = 'direct'
             )
         )
-    `, userId, userId, userId, userId)
+    `, append(messageableArgs, userId)...)
 
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -479,9 +453,12 @@ func GetGroupChatMessages(w http.ResponseWriter, r *http.Request) {
             u.username,
             COALESCE(u.avatar, '') as avatar,
             m.content,
+            COALESCE(md.category, ''),
+            COALESCE(md.filename, ''),
             m.created_at
         FROM chat_messages m
         JOIN users u ON m.sender_id = u.id
+        LEFT JOIN media md ON md.id = m.media_id
         WHERE m.chat_id = ?
         ORDER BY m.created_at ASC
     `, chatId)
@@ -508,6 +485,7 @@ func GetGroupChatMessages(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var createdAtStr string
+		var mediaCategory, mediaFilename string
 		err := rows.Scan(
 			&msg.ID,
 			&msg.SenderID,
@@ -516,6 +494,8 @@ func GetGroupChatMessages(w http.ResponseWriter, r *http.Request) {
 			&msg.Username,
 			&msg.Avatar,
 			&msg.Content,
+			&mediaCategory,
+			&mediaFilename,
 			&createdAtStr,
 		)
 
@@ -537,10 +517,25 @@ func GetGroupChatMessages(w http.ResponseWriter, r *http.Request) {
 			"userName":   fmt.Sprintf("%s %s", msg.FirstName, msg.LastName),
 			"userAvatar": msg.Avatar,
 		}
-
+		if url := mediaURL(mediaCategory, mediaFilename); url != "" {
+			messageItem["media"] = url
+		}
 		messages = append(messages, messageItem)
 	}
 
+	// Mentions are resolved for the whole page at once
+	found := make([][]entities.Entity, len(messages))
+	var batch entityBatch
+	for i := range messages {
+		batch.add(messages[i]["content"].(string), &found[i])
+	}
+	batch.resolve()
+	for i := range messages {
+		if len(found[i]) > 0 {
+			messages[i]["entities"] = found[i]
+		}
+	}
+
 	// Update user's last read message timestamp
 	_, err = sqlite.DB.Exec(`
         UPDATE user_chat_status
@@ -631,17 +626,8 @@ func GetChatParticipants(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Check if there's at least one follow relationship (either user follows the other)
-		var followExists bool
-		err = sqlite.DB.QueryRow(`
-            SELECT EXISTS (
-                SELECT 1 FROM followers 
-                WHERE ((follower_id = ? AND followed_id = ?) 
-                OR (follower_id = ? AND followed_id = ?))
-                AND status = 'accepted'
-            )
-        `, userId, otherUserId, otherUserId, userId).Scan(&followExists)
-
+		// Check the messaging policy, at least one user has to follow the other
+		followExists, err := policy.CanUsersMessage(sqlite.DB, userId, otherUserId)
 		if err != nil {
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
@@ -1,161 +1,76 @@
 package api
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
-	"io"
 	"log"
 	"net/http"
 	"strconv"
 
 	m "social-network/models"
 	"social-network/pkg/db/sqlite"
+	"social-network/util"
 )
 
+// CreateComment adds a comment to a post. The body is JSON with post_id,
+// content and optionally parent_comment_id and a media data URL, or a
+// multipart form with the same fields and an image file as media.
 func CreateComment(w http.ResponseWriter, r *http.Request) {
-	log.Println("=== CREATE COMMENT HANDLER CALLED ===")
 	w.Header().Set("Content-Type", "application/json")
 
-	// Get and log path parameters
-	groupID := r.PathValue("id")
-	postID := r.PathValue("postId")
-	log.Printf("Creating comment for group %s, post %s", groupID, postID)
-
-	// Log request headers and body
-	log.Printf("Request headers: %+v", r.Header)
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Error reading body: %v", err)
-		http.Error(w, "Failed to read request", http.StatusBadRequest)
-		return
-	}
-	log.Printf("Request body: %s", string(body))
-	r.Body = io.NopCloser(bytes.NewBuffer(body)) // Reset the body for later use
-
-	var comment m.Comment
-	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&comment); err != nil {
-		log.Printf("Error decoding JSON: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
-	}
-
-	log.Printf("Parsed comment: %+v", comment)
-
-	// Validate required fields
-	if comment.Content == "" {
-		http.Error(w, "Content is required", http.StatusBadRequest)
-		return
-	}
-	if comment.PostID == 0 {
-		http.Error(w, "Post ID is required", http.StatusBadRequest)
-		return
-	}
-	if comment.Author == 0 {
-		http.Error(w, "Author ID is required", http.StatusBadRequest)
-		return
-	}
-
-	// Begin transaction
-	tx, err := sqlite.DB.Begin()
+	username, err := util.GetUsernameFromSession(r)
 	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	defer tx.Rollback()
 
-	// Verify the post exists
-	var postExists bool
-	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", comment.PostID).Scan(&postExists)
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
 	if err != nil {
-		log.Printf("Error checking post existence: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	if !postExists {
-		http.Error(w, "Post not found", http.StatusNotFound)
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
 		return
 	}
 
-	// Insert the comment
-	result, err := tx.Exec(`
-		INSERT INTO comments (content, media, author, post_id, created_at) 
-		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`,
-		comment.Content, comment.Media, comment.Author, comment.PostID)
-	if err != nil {
-		log.Printf("Error inserting comment: %v", err)
-		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
-		return
-	}
-
-	// Get the inserted ID
-	commentID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Error getting last insert ID: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("Comment inserted with ID: %d", commentID)
-
-	// Verify the comment was saved
-	var savedComment m.Comment
-	err = tx.QueryRow(`
-		SELECT id, content, post_id, author 
-		FROM comments 
-		WHERE id = ?`, commentID).Scan(
-		&savedComment.ID,
-		&savedComment.Content,
-		&savedComment.PostID,
-		&savedComment.Author,
-	)
-	if err != nil {
-		log.Printf("Error verifying saved comment: %v", err)
+	var input newComment
+	var postID int
+	if isMultipart(r) {
+		input, err = readCommentForm(w, r)
+		if err != nil {
+			writeCommentError(w, err)
+			return
+		}
+		postID, _ = strconv.Atoi(r.FormValue("post_id"))
 	} else {
-		log.Printf("Verified saved comment: %+v", savedComment)
+		var body struct {
+			PostID          int    `json:"post_id"`
+			Content         string `json:"content"`
+			Media           string `json:"media"`
+			ParentCommentID *int   `json:"parent_comment_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			sendJSONError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		postID = body.PostID
+		input = newComment{
+			Content:         body.Content,
+			ParentCommentID: body.ParentCommentID,
+			MediaDataURL:    body.Media,
+		}
 	}
 
-	// Get the complete comment data
-	var createdComment m.Comment
-	err = tx.QueryRow(`
-		SELECT 
-			c.id,
-			c.content,
-			c.media,
-			c.post_id,
-			c.author,
-			c.created_at,
-			u.username as author_name,
-			u.avatar as author_avatar
-		FROM comments c
-		JOIN users u ON c.author = u.id
-		WHERE c.id = ?`,
-		commentID).Scan(
-		&createdComment.ID,
-		&createdComment.Content,
-		&createdComment.Media,
-		&createdComment.PostID,
-		&createdComment.Author,
-		&createdComment.CreatedAt,
-		&createdComment.AuthorName,
-		&createdComment.AuthorAvatar,
-	)
-	if err != nil {
-		log.Printf("Error retrieving created comment: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if postID < 1 {
+		sendJSONError(w, "Post ID is required", http.StatusBadRequest)
 		return
 	}
 
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	comment, err := addPostComment(userID, postID, input)
+	if err != nil {
+		writeCommentError(w, err)
 		return
 	}
 
-	// Return the created comment
-	json.NewEncoder(w).Encode(createdComment)
+	json.NewEncoder(w).Encode(comment)
 }
 
 func GetComments(w http.ResponseWriter, r *http.Request) {
@@ -171,18 +86,29 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := sqlite.DB.Query(`
-		SELECT 
-			c.id,
-			c.content,
-			c.media,
-			c.post_id,
-			c.author,
-			c.created_at,
-			u.username as author_name,
-			u.avatar as author_avatar
-		FROM comments c
-		JOIN users u ON c.author = u.id
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	// Comments, and the media attached to them, are only visible to those
+	// who can see the post
+	canView, err := canUserViewPost(postID, userID)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking access to post %d: %v", postID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !canView {
+		sendJSONError(w, "You don't have permission to view this post", http.StatusForbidden)
+		return
+	}
+
+	rows, err := sqlite.DB.Query(postCommentQuery+`
 		WHERE c.post_id = ?
 		ORDER BY c.created_at DESC`,
 		postID)
@@ -196,23 +122,17 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		var comment m.Comment
-		err := rows.Scan(
-			&comment.ID,
-			&comment.Content,
-			&comment.Media,
-			&comment.PostID,
-			&comment.Author,
-			&comment.CreatedAt,
-			&comment.AuthorName,
-			&comment.AuthorAvatar,
-		)
-		if err != nil {
-			http.Error(w, "Error reading comment data", http.StatusInternalServerError)
-			return
-		}
-		comments = append(comments, comment)
+	var batch entityBatch
+	comments, err = scanPostComments(rows, &batch)
+	if err != nil {
+		http.Error(w, "Error reading comment data", http.StatusInternalServerError)
+		return
+	}
+	batch.resolve()
+
+	// ?view=nested returns top level comments with their replies below them
+	if nestedView(r) {
+		comments = nestComments(comments)
 	}
 
 	json.NewEncoder(w).Encode(comments)
@@ -0,0 +1,449 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+	"social-network/util"
+)
+
+// commentMedia returns the media record attached to a comment, if any
+func commentMedia(tx *sql.Tx, table commentTable, commentID int) (*m.Media, error) {
+	var record m.Media
+	err := tx.QueryRow(fmt.Sprintf(`
+		SELECT md.id, md.category, md.filename
+		FROM media md
+		JOIN %s c ON c.media_id = md.id
+		WHERE c.id = ?`, table.name), commentID).Scan(&record.ID, &record.Category, &record.Filename)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// removeComment deletes a comment. A comment that still has replies is
+// turned into a tombstone instead so the thread below it stays intact, and
+// tombstones left without replies are removed along the way. It returns
+// whether a tombstone was left and the media to discard once the transaction
+// is committed.
+func removeComment(tx *sql.Tx, table commentTable, commentID int) (bool, *m.Media, error) {
+	attachment, err := commentMedia(tx, table, commentID)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to fetch comment media: %w", err)
+	}
+
+	var replies int
+	var parentID sql.NullInt64
+	err = tx.QueryRow(fmt.Sprintf(`
+		SELECT
+			(SELECT COUNT(*) FROM %[1]s r WHERE r.parent_comment_id = c.id),
+			c.parent_comment_id
+		FROM %[1]s c
+		WHERE c.id = ?`, table.name), commentID).Scan(&replies, &parentID)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to fetch comment: %w", err)
+	}
+
+	if replies > 0 {
+		_, err = tx.Exec(fmt.Sprintf(`
+			UPDATE %s
+			SET content = '', media_id = NULL, deleted_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, table.name), commentID)
+		if err != nil {
+			return false, nil, fmt.Errorf("failed to mark comment deleted: %w", err)
+		}
+		if err := removeEntities(tx, table.content, commentID); err != nil {
+			return false, nil, err
+		}
+		return true, attachment, nil
+	}
+
+	if err := deleteCommentRow(tx, table, commentID); err != nil {
+		return false, nil, err
+	}
+
+	// Walk up the thread and drop tombstones that no longer have replies
+	for parentID.Valid {
+		var deleted bool
+		var remaining int
+		var grandparentID sql.NullInt64
+		err = tx.QueryRow(fmt.Sprintf(`
+			SELECT
+				c.deleted_at IS NOT NULL,
+				(SELECT COUNT(*) FROM %[1]s r WHERE r.parent_comment_id = c.id),
+				c.parent_comment_id
+			FROM %[1]s c
+			WHERE c.id = ?`, table.name), parentID.Int64).Scan(&deleted, &remaining, &grandparentID)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return false, nil, fmt.Errorf("failed to fetch parent comment: %w", err)
+		}
+		if !deleted || remaining > 0 {
+			break
+		}
+		if err := deleteCommentRow(tx, table, int(parentID.Int64)); err != nil {
+			return false, nil, err
+		}
+		parentID = grandparentID
+	}
+
+	return false, attachment, nil
+}
+
+// deleteCommentRow removes a comment, the likes pointing at it and its
+// hashtags and mentions
+func deleteCommentRow(tx *sql.Tx, table commentTable, commentID int) error {
+	if table == postCommentTable {
+		if _, err := tx.Exec("DELETE FROM likes WHERE comment_id = ?", commentID); err != nil {
+			return fmt.Errorf("failed to delete comment likes: %w", err)
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", table.name), commentID); err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+	return removeEntities(tx, table.content, commentID)
+}
+
+// decodeCommentEdit reads the new content of an edited comment
+func decodeCommentEdit(r *http.Request) (string, error) {
+	var edit struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		return "", fmt.Errorf("invalid request body")
+	}
+	if strings.TrimSpace(edit.Content) == "" {
+		return "", fmt.Errorf("comment content is required")
+	}
+	return edit.Content, nil
+}
+
+// UpdateComment changes the content of a comment. Only its author can edit it.
+func UpdateComment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	commentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	content, err := decodeCommentEdit(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var authorID, postID int
+	err = sqlite.DB.QueryRow(`
+		SELECT author, post_id FROM comments
+		WHERE id = ? AND deleted_at IS NULL`, commentID).Scan(&authorID, &postID)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching comment %d: %v", commentID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if authorID != userID {
+		sendJSONError(w, "Only the author can edit this comment", http.StatusForbidden)
+		return
+	}
+
+	_, err = sqlite.DB.Exec(`
+		UPDATE comments
+		SET content = ?, edited_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, content, commentID)
+	if err != nil {
+		log.Printf("Error updating comment %d: %v", commentID, err)
+		sendJSONError(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	// Only users the edit mentions for the first time are notified
+	mentioned, err := indexEntities(sqlite.DB, contentComment, commentID, userID, content)
+	if err != nil {
+		log.Printf("Error indexing comment %d: %v", commentID, err)
+	}
+	notifyMentions(mentioned, contentComment, commentID, userID, 0, content, postAudience(postID))
+
+	comment, err := scanPostComment(sqlite.DB.QueryRow(postCommentQuery+" WHERE c.id = ?", commentID))
+	if err != nil {
+		log.Printf("Error fetching updated comment %d: %v", commentID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, comment)
+}
+
+// DeleteComment removes a comment. The comment's author and the author of the
+// post it is on can delete it.
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	commentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var authorID, postAuthorID int
+	err = tx.QueryRow(`
+		SELECT c.author, p.author
+		FROM comments c
+		JOIN posts p ON p.id = c.post_id
+		WHERE c.id = ? AND c.deleted_at IS NULL`, commentID).Scan(&authorID, &postAuthorID)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching comment %d: %v", commentID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if userID != authorID && userID != postAuthorID {
+		sendJSONError(w, "You don't have permission to delete this comment", http.StatusForbidden)
+		return
+	}
+
+	tombstone, attachment, err := removeComment(tx, postCommentTable, commentID)
+	if err != nil {
+		log.Printf("Error deleting comment %d: %v", commentID, err)
+		sendJSONError(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	discardMedia(attachment)
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":   "Comment deleted successfully",
+		"tombstone": tombstone,
+	})
+}
+
+// groupCommentRequest reads the path values and session shared by the group
+// comment edit and delete handlers. It writes the error response itself and
+// returns false when the request can't go on.
+func groupCommentRequest(w http.ResponseWriter, r *http.Request) (groupID, postID, commentID, userID int, ok bool) {
+	var err error
+	if groupID, err = strconv.Atoi(r.PathValue("id")); err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	if postID, err = strconv.Atoi(r.PathValue("postId")); err != nil {
+		sendJSONError(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+	if commentID, err = strconv.Atoi(r.PathValue("commentId")); err != nil {
+		sendJSONError(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := requireGroupMember(sqlite.DB, groupID, userID); err != nil {
+		writeStatusError(w, err, "Database error")
+		return
+	}
+
+	return groupID, postID, commentID, userID, true
+}
+
+// UpdateGroupPostComment changes the content of a comment on a group post.
+// Only its author can edit it.
+func UpdateGroupPostComment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	groupID, postID, commentID, userID, ok := groupCommentRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := requireNotMuted(sqlite.DB, groupID, userID); err != nil {
+		writeStatusError(w, err, "Failed to update comment")
+		return
+	}
+
+	content, err := decodeCommentEdit(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var authorID, postAuthorID int
+	var postStatus policy.PostStatus
+	err = sqlite.DB.QueryRow(`
+		SELECT c.author_id, gp.author_id, gp.status
+		FROM group_post_comments c
+		JOIN group_posts gp ON gp.id = c.post_id
+		WHERE c.id = ? AND c.post_id = ? AND gp.group_id = ? AND c.deleted_at IS NULL`,
+		commentID, postID, groupID).Scan(&authorID, &postAuthorID, &postStatus)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching group comment %d: %v", commentID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if authorID != userID {
+		sendJSONError(w, "Only the author can edit this comment", http.StatusForbidden)
+		return
+	}
+
+	_, err = sqlite.DB.Exec(`
+		UPDATE group_post_comments
+		SET content = ?, edited_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, content, commentID)
+	if err != nil {
+		log.Printf("Error updating group comment %d: %v", commentID, err)
+		sendJSONError(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	mentioned, err := indexEntities(sqlite.DB, contentGroupComment, commentID, userID, content)
+	if err != nil {
+		log.Printf("Error indexing group comment %d: %v", commentID, err)
+	}
+	notifyMentions(mentioned, contentGroupComment, commentID, userID, groupID, content, groupAudience(groupID))
+
+	comment, err := scanGroupComment(sqlite.DB.QueryRow(groupCommentQuery+" WHERE c.id = ?", commentID))
+	if err != nil {
+		log.Printf("Error fetching updated group comment %d: %v", commentID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	broadcastGroupPostChange("group_comment_updated", groupID, postID, commentID, postAuthorID, postStatus)
+
+	sendJSONResponse(w, http.StatusOK, comment)
+}
+
+// DeleteGroupPostComment removes a comment on a group post. Besides the
+// comment's and the post's authors, members with the delete_content
+// permission can delete it.
+func DeleteGroupPostComment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	groupID, postID, commentID, userID, ok := groupCommentRequest(w, r)
+	if !ok {
+		return
+	}
+
+	canDelete, _, err := policy.UserHasCapability(sqlite.DB, groupID, userID, policy.CapDeleteContent)
+	if err != nil {
+		log.Printf("Error checking group permissions: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var authorID, postAuthorID int
+	var postStatus policy.PostStatus
+	err = tx.QueryRow(`
+		SELECT c.author_id, gp.author_id, gp.status
+		FROM group_post_comments c
+		JOIN group_posts gp ON gp.id = c.post_id
+		WHERE c.id = ? AND c.post_id = ? AND gp.group_id = ? AND c.deleted_at IS NULL`,
+		commentID, postID, groupID).Scan(&authorID, &postAuthorID, &postStatus)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching group comment %d: %v", commentID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if userID != authorID && userID != postAuthorID && !canDelete {
+		sendJSONError(w, "You don't have permission to delete this comment", http.StatusForbidden)
+		return
+	}
+
+	tombstone, attachment, err := removeComment(tx, groupCommentTable, commentID)
+	if err != nil {
+		log.Printf("Error deleting group comment %d: %v", commentID, err)
+		sendJSONError(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	discardMedia(attachment)
+	broadcastGroupPostChange("group_comment_deleted", groupID, postID, commentID, postAuthorID, postStatus)
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":   "Comment deleted successfully",
+		"tombstone": tombstone,
+	})
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/media"
+	"social-network/pkg/policy"
+)
+
+// newComment is a comment as sent by a client. The author is never part of
+// it, comments are always written by the user of the session.
+type newComment struct {
+	Content         string
+	ParentCommentID *int
+	// MediaDataURL is an attachment sent inline in a JSON body
+	MediaDataURL string
+	// form is the request of a multipart form with the attachment in its
+	// media field
+	form *http.Request
+}
+
+// readCommentForm reads a comment sent as a multipart form, which is how
+// comments with an attachment arrive. Other form values stay available
+// through r.FormValue.
+func readCommentForm(w http.ResponseWriter, r *http.Request) (newComment, error) {
+	var input newComment
+	if err := parseUploadForm(w, r); err != nil {
+		return input, err
+	}
+
+	parentID, err := parseParentCommentID(r.FormValue("parent_comment_id"))
+	if err != nil {
+		return input, &statusError{http.StatusBadRequest, "Invalid parent comment ID"}
+	}
+	input.Content = r.FormValue("content")
+	input.ParentCommentID = parentID
+	input.form = r
+	return input, nil
+}
+
+// saveMedia stores the attachment of a comment, if it has one
+func (c newComment) saveMedia(category media.Category, ownerID int) (*m.Media, error) {
+	if c.form != nil {
+		return saveUploadedMedia(c.form, "media", category, ownerID)
+	}
+	if c.MediaDataURL != "" {
+		return saveDataURLMedia(c.MediaDataURL, category, ownerID)
+	}
+	return nil, nil
+}
+
+// writeCommentError responds with the status matching an error from the
+// comment service
+func writeCommentError(w http.ResponseWriter, err error) {
+	var statusErr *statusError
+	switch {
+	case errors.As(err, &statusErr):
+		sendJSONError(w, statusErr.message, statusErr.status)
+	case errors.Is(err, errParentNotFound), errors.Is(err, errMaxDepth):
+		writeParentCommentError(w, err)
+	case errors.Is(err, media.ErrTooLarge), errors.Is(err, media.ErrUnsupportedType),
+		errors.Is(err, media.ErrInvalidImage), errors.Is(err, media.ErrDimensions):
+		writeMediaError(w, err)
+	default:
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeMediaError(w, err)
+			return
+		}
+		log.Printf("Error creating comment: %v", err)
+		sendJSONError(w, "Failed to create comment", http.StatusInternalServerError)
+	}
+}
+
+// addPostComment creates a comment on a post as userID. The user has to be
+// able to see the post. It returns the stored comment with its author's name
+// and avatar.
+func addPostComment(userID, postID int, input newComment) (*m.Comment, error) {
+	if strings.TrimSpace(input.Content) == "" {
+		return nil, &statusError{http.StatusBadRequest, "Comment content is required"}
+	}
+
+	canView, err := canUserViewPost(postID, userID)
+	if err == sql.ErrNoRows {
+		return nil, &statusError{http.StatusNotFound, "Post not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !canView {
+		return nil, &statusError{http.StatusForbidden, "You don't have permission to comment on this post"}
+	}
+
+	// Store the attachment before the transaction, the media row is written
+	// on its own connection
+	upload, err := input.saveMedia(media.CategoryComment, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The media row is written outside the transaction, drop it again unless
+	// the comment is committed. Deferred before the rollback so it runs after it.
+	committed := false
+	defer func() {
+		if !committed {
+			discardMedia(upload)
+		}
+	}()
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Replies must stay on the same post and within the depth limit
+	var parentID interface{}
+	parentAuthor := 0
+	if input.ParentCommentID != nil {
+		parentAuthor, err = checkParentComment(tx, postCommentTable, *input.ParentCommentID, postID)
+		if err != nil {
+			return nil, err
+		}
+		parentID = *input.ParentCommentID
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO comments (content, author, post_id, media_id, parent_comment_id, created_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		input.Content, userID, postID, mediaID(upload), parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	commentID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	mentioned, err := indexEntities(tx, contentComment, int(commentID), userID, input.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	comment, err := scanPostComment(tx.QueryRow(postCommentQuery+" WHERE c.id = ?", commentID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	committed = true
+
+	notifyMentions(mentioned, contentComment, int(commentID), userID, 0, input.Content, postAudience(postID))
+
+	if parentAuthor != 0 {
+		if err := CreateCommentReplyNotification(parentAuthor, userID, postID, 0, input.Content); err != nil {
+			log.Printf("Error notifying about comment reply: %v", err)
+		}
+	}
+
+	return &comment, nil
+}
+
+// addGroupPostComment creates a comment on a group post as userID, who needs
+// the comment permission in the group. It returns the stored comment with
+// its author.
+func addGroupPostComment(userID, groupID, postID int, input newComment) (*m.GroupPostComment, error) {
+	if strings.TrimSpace(input.Content) == "" {
+		return nil, &statusError{http.StatusBadRequest, "Comment content is required"}
+	}
+
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapComment); err != nil {
+		return nil, err
+	}
+
+	var postExists bool
+	err := sqlite.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM group_posts
+			WHERE id = ? AND group_id = ? AND status = ?
+		)`, postID, groupID, policy.PostPublished).Scan(&postExists)
+	if err != nil {
+		return nil, err
+	}
+	if !postExists {
+		return nil, &statusError{http.StatusNotFound, "Post not found or doesn't belong to this group"}
+	}
+
+	upload, err := input.saveMedia(media.CategoryGroupPost, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			discardMedia(upload)
+		}
+	}()
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var parentID interface{}
+	parentAuthor := 0
+	if input.ParentCommentID != nil {
+		parentAuthor, err = checkParentComment(tx, groupCommentTable, *input.ParentCommentID, postID)
+		if err != nil {
+			return nil, err
+		}
+		parentID = *input.ParentCommentID
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO group_post_comments (post_id, author_id, content, media_id, parent_comment_id, created_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		postID, userID, input.Content, mediaID(upload), parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	commentID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	mentioned, err := indexEntities(tx, contentGroupComment, int(commentID), userID, input.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	comment, err := scanGroupComment(tx.QueryRow(groupCommentQuery+" WHERE c.id = ?", commentID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	committed = true
+
+	notifyMentions(mentioned, contentGroupComment, int(commentID), userID, groupID, input.Content, groupAudience(groupID))
+
+	if parentAuthor != 0 {
+		if err := CreateCommentReplyNotification(parentAuthor, userID, postID, groupID, input.Content); err != nil {
+			log.Printf("Error notifying about comment reply: %v", err)
+		}
+	}
+
+	return &comment, nil
+}
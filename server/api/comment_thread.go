@@ -0,0 +1,609 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+	"social-network/util"
+)
+
+// MaxCommentDepth is how deep replies can nest. Top level comments are at
+// depth 0, so with the default of 3 a reply to a reply to a reply is the
+// deepest allowed. COMMENT_MAX_DEPTH overrides it.
+var MaxCommentDepth = 3
+
+func init() {
+	if value := os.Getenv("COMMENT_MAX_DEPTH"); value != "" {
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 0 {
+			log.Printf("Ignoring invalid COMMENT_MAX_DEPTH %q", value)
+			return
+		}
+		MaxCommentDepth = depth
+	}
+}
+
+const (
+	defaultRepliesLimit = 20
+	maxRepliesLimit     = 100
+)
+
+var (
+	errParentNotFound = errors.New("parent comment not found")
+	errMaxDepth       = errors.New("maximum reply depth reached")
+)
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// commentTable holds the names that differ between post and group comments
+type commentTable struct {
+	name   string
+	author string
+	// content is the kind their hashtags and mentions are indexed as
+	content string
+}
+
+var (
+	postCommentTable  = commentTable{name: "comments", author: "author", content: contentComment}
+	groupCommentTable = commentTable{name: "group_post_comments", author: "author_id", content: contentGroupComment}
+)
+
+// checkParentComment makes sure the parent of a reply is on the same post and
+// that the reply doesn't nest deeper than MaxCommentDepth. It returns the
+// author of the parent comment.
+func checkParentComment(q queryRower, table commentTable, parentID, postID int) (int, error) {
+	var authorID, parentPostID, depth int
+	var deleted bool
+	err := q.QueryRow(fmt.Sprintf(`
+		WITH RECURSIVE ancestors(id, parent_id, depth) AS (
+			SELECT id, parent_comment_id, 0 FROM %[1]s WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_comment_id, a.depth + 1
+			FROM %[1]s c
+			JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT p.%[2]s, p.post_id, p.deleted_at IS NOT NULL, (SELECT MAX(depth) FROM ancestors)
+		FROM %[1]s p
+		WHERE p.id = ?`, table.name, table.author),
+		parentID, parentID).Scan(&authorID, &parentPostID, &deleted, &depth)
+	if err == sql.ErrNoRows {
+		return 0, errParentNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Deleted comments can't be replied to
+	if parentPostID != postID || deleted {
+		return 0, errParentNotFound
+	}
+	if depth+1 > MaxCommentDepth {
+		return 0, errMaxDepth
+	}
+	return authorID, nil
+}
+
+// writeParentCommentError maps checkParentComment errors to a response
+func writeParentCommentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errParentNotFound):
+		sendJSONError(w, "Parent comment not found on this post", http.StatusBadRequest)
+	case errors.Is(err, errMaxDepth):
+		sendJSONError(w, fmt.Sprintf("Replies can't be nested more than %d levels deep", MaxCommentDepth), http.StatusBadRequest)
+	default:
+		log.Printf("Error checking parent comment: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// parseParentCommentID reads an optional parent comment ID from a form value,
+// an empty value means a top level comment
+func parseParentCommentID(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		return nil, fmt.Errorf("invalid parent comment ID")
+	}
+	return &id, nil
+}
+
+// nestedView reports whether the client asked for comments as a tree with
+// ?view=nested instead of the default flat list
+func nestedView(r *http.Request) bool {
+	return r.URL.Query().Get("view") == "nested"
+}
+
+// parsePagination reads the limit and offset query values
+func parsePagination(r *http.Request, defaultLimit, maxLimit int) (limit, offset int, err error) {
+	limit = defaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("invalid limit")
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset")
+		}
+	}
+	return limit, offset, nil
+}
+
+// nestComments arranges a flat list of comments into trees. Comments whose
+// parent isn't in the list become roots and keep their order, replies are
+// listed oldest first under their parent.
+func nestComments(comments []m.Comment) []m.Comment {
+	present := make(map[uint]bool, len(comments))
+	for _, comment := range comments {
+		present[comment.ID] = true
+	}
+
+	children := make(map[uint][]m.Comment)
+	var roots []m.Comment
+	for _, comment := range comments {
+		if comment.ParentCommentID != nil && present[*comment.ParentCommentID] {
+			children[*comment.ParentCommentID] = append(children[*comment.ParentCommentID], comment)
+		} else {
+			roots = append(roots, comment)
+		}
+	}
+
+	var attach func(list []m.Comment) []m.Comment
+	attach = func(list []m.Comment) []m.Comment {
+		for i := range list {
+			replies := children[list[i].ID]
+			sort.Slice(replies, func(a, b int) bool { return replies[a].ID < replies[b].ID })
+			list[i].Replies = attach(replies)
+		}
+		return list
+	}
+	return attach(roots)
+}
+
+// nestGroupComments is nestComments for group post comments
+func nestGroupComments(comments []m.GroupPostComment) []m.GroupPostComment {
+	present := make(map[int]bool, len(comments))
+	for _, comment := range comments {
+		present[comment.ID] = true
+	}
+
+	children := make(map[int][]m.GroupPostComment)
+	var roots []m.GroupPostComment
+	for _, comment := range comments {
+		if comment.ParentCommentID != nil && present[*comment.ParentCommentID] {
+			children[*comment.ParentCommentID] = append(children[*comment.ParentCommentID], comment)
+		} else {
+			roots = append(roots, comment)
+		}
+	}
+
+	var attach func(list []m.GroupPostComment) []m.GroupPostComment
+	attach = func(list []m.GroupPostComment) []m.GroupPostComment {
+		for i := range list {
+			replies := children[list[i].ID]
+			sort.Slice(replies, func(a, b int) bool { return replies[a].ID < replies[b].ID })
+			list[i].Replies = attach(replies)
+		}
+		return list
+	}
+	return attach(roots)
+}
+
+// postCommentQuery selects comments with their author, media, parent and
+// number of direct replies, scanned by scanPostComment
+const postCommentQuery = `
+	SELECT
+		c.id,
+		c.content,
+		COALESCE(md.category, ''),
+		COALESCE(md.filename, ''),
+		c.post_id,
+		c.author,
+		c.created_at,
+		u.username as author_name,
+		u.avatar as author_avatar,
+		c.parent_comment_id,
+		(SELECT COUNT(*) FROM comments r WHERE r.parent_comment_id = c.id) as reply_count,
+		c.edited_at,
+		c.deleted_at IS NOT NULL as deleted
+	FROM comments c
+	JOIN users u ON c.author = u.id
+	LEFT JOIN media md ON md.id = c.media_id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPostComment reads a row of postCommentQuery
+func scanPostComment(row rowScanner) (m.Comment, error) {
+	comment, err := readPostComment(row)
+	if err != nil {
+		return comment, err
+	}
+	comment.Entities = resolveEntities(comment.Content)
+	return comment, nil
+}
+
+// scanPostComments reads every row of postCommentQuery and queues their
+// entities in batch
+func scanPostComments(rows *sql.Rows, batch *entityBatch) ([]m.Comment, error) {
+	var comments []m.Comment
+	for rows.Next() {
+		comment, err := readPostComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range comments {
+		batch.add(comments[i].Content, &comments[i].Entities)
+	}
+	return comments, nil
+}
+
+// readPostComment is scanPostComment without the entities
+func readPostComment(row rowScanner) (m.Comment, error) {
+	var comment m.Comment
+	var mediaCategory, mediaFilename string
+	var parentID sql.NullInt64
+	var editedAt sql.NullTime
+	err := row.Scan(
+		&comment.ID,
+		&comment.Content,
+		&mediaCategory,
+		&mediaFilename,
+		&comment.PostID,
+		&comment.Author,
+		&comment.CreatedAt,
+		&comment.AuthorName,
+		&comment.AuthorAvatar,
+		&parentID,
+		&comment.ReplyCount,
+		&editedAt,
+		&comment.Deleted,
+	)
+	if err != nil {
+		return comment, err
+	}
+	if parentID.Valid {
+		id := uint(parentID.Int64)
+		comment.ParentCommentID = &id
+	}
+	// Tombstones only hold the thread together, who wrote them isn't shown
+	if comment.Deleted {
+		comment.Author = 0
+		comment.AuthorName = ""
+		comment.AuthorAvatar = ""
+		return comment, nil
+	}
+	if editedAt.Valid {
+		comment.EditedAt = &editedAt.Time
+	}
+	comment.Media = mediaURL(mediaCategory, mediaFilename)
+	return comment, nil
+}
+
+// groupCommentQuery is postCommentQuery for group post comments, scanned by
+// scanGroupComment
+const groupCommentQuery = `
+	SELECT
+		c.id,
+		c.post_id,
+		c.author_id,
+		u.username as author,
+		COALESCE(u.avatar, '') as author_avatar,
+		c.content,
+		COALESCE(md.category, ''),
+		COALESCE(md.filename, ''),
+		c.created_at,
+		c.created_at as updated_at,
+		c.parent_comment_id,
+		(SELECT COUNT(*) FROM group_post_comments r WHERE r.parent_comment_id = c.id) as reply_count,
+		c.edited_at,
+		c.deleted_at IS NOT NULL as deleted
+	FROM group_post_comments c
+	JOIN users u ON c.author_id = u.id
+	LEFT JOIN media md ON md.id = c.media_id`
+
+// scanGroupComment reads a row of groupCommentQuery
+func scanGroupComment(row rowScanner) (m.GroupPostComment, error) {
+	comment, err := readGroupComment(row)
+	if err != nil {
+		return comment, err
+	}
+	comment.Entities = resolveEntities(comment.Content)
+	return comment, nil
+}
+
+// scanGroupComments reads every row of groupCommentQuery and queues their
+// entities in batch
+func scanGroupComments(rows *sql.Rows, batch *entityBatch) ([]m.GroupPostComment, error) {
+	var comments []m.GroupPostComment
+	for rows.Next() {
+		comment, err := readGroupComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range comments {
+		batch.add(comments[i].Content, &comments[i].Entities)
+	}
+	return comments, nil
+}
+
+// readGroupComment is scanGroupComment without the entities
+func readGroupComment(row rowScanner) (m.GroupPostComment, error) {
+	var comment m.GroupPostComment
+	var mediaCategory, mediaFilename string
+	var parentID sql.NullInt64
+	var editedAt sql.NullString
+	err := row.Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.AuthorID,
+		&comment.Author,
+		&comment.AuthorAvatar,
+		&comment.Content,
+		&mediaCategory,
+		&mediaFilename,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&parentID,
+		&comment.ReplyCount,
+		&editedAt,
+		&comment.Deleted,
+	)
+	if err != nil {
+		return comment, err
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		comment.ParentCommentID = &id
+	}
+	if comment.Deleted {
+		comment.AuthorID = 0
+		comment.Author = ""
+		comment.AuthorAvatar = ""
+		return comment, nil
+	}
+	if editedAt.Valid {
+		comment.EditedAt = &editedAt.String
+		comment.UpdatedAt = editedAt.String
+	}
+	comment.Media = mediaURL(mediaCategory, mediaFilename)
+	return comment, nil
+}
+
+// threadQuery selects a page of direct replies to a comment together with
+// everything below them. The page is ordered oldest first.
+func threadQuery(table commentTable, selectQuery string) string {
+	return fmt.Sprintf(`
+		WITH RECURSIVE
+		page(id) AS (
+			SELECT id FROM %[1]s
+			WHERE parent_comment_id = ?
+			ORDER BY created_at, id
+			LIMIT ? OFFSET ?
+		),
+		thread(id) AS (
+			SELECT id FROM page
+			UNION ALL
+			SELECT c.id FROM %[1]s c JOIN thread t ON c.parent_comment_id = t.id
+		)
+		%[2]s
+		WHERE c.id IN (SELECT id FROM thread)
+		ORDER BY c.id`, table.name, selectQuery)
+}
+
+// GetCommentReplies returns a comment with a page of its direct replies, each
+// with its own replies nested below it
+func GetCommentReplies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	commentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePagination(r, defaultRepliesLimit, maxRepliesLimit)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	comment, err := scanPostComment(sqlite.DB.QueryRow(postCommentQuery+" WHERE c.id = ?", commentID))
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching comment %d: %v", commentID, err)
+		sendJSONError(w, "Failed to fetch comment", http.StatusInternalServerError)
+		return
+	}
+
+	// Comments are only visible to those who can see the post
+	canView, err := canUserViewPost(int(comment.PostID), userID)
+	if err != nil || !canView {
+		sendJSONError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := sqlite.DB.Query(threadQuery(postCommentTable, postCommentQuery), commentID, limit, offset)
+	if err != nil {
+		log.Printf("Error fetching replies of comment %d: %v", commentID, err)
+		sendJSONError(w, "Failed to fetch replies", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var batch entityBatch
+	replies, err := scanPostComments(rows, &batch)
+	if err != nil {
+		log.Printf("Error scanning reply: %v", err)
+		sendJSONError(w, "Error reading replies", http.StatusInternalServerError)
+		return
+	}
+	batch.resolve()
+
+	replies = nestComments(replies)
+	if replies == nil {
+		replies = []m.Comment{}
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"comment": comment,
+		"replies": replies,
+		"total":   comment.ReplyCount,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetGroupCommentReplies is GetCommentReplies for comments on group posts
+func GetGroupCommentReplies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.PathValue("postId"))
+	if err != nil {
+		sendJSONError(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	commentID, err := strconv.Atoi(r.PathValue("commentId"))
+	if err != nil {
+		sendJSONError(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePagination(r, defaultRepliesLimit, maxRepliesLimit)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	if err := requireGroupReader(groupID, userID); err != nil {
+		writeStatusError(w, err, "Failed to fetch comment")
+		return
+	}
+
+	// Like the comments of a post, the replies of posts waiting for approval
+	// are only for their author
+	published, publishedArgs := policy.PublishedGroupPostCondition("gp", userID)
+	var visible bool
+	err = sqlite.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM group_posts gp WHERE gp.id = ? AND gp.group_id = ? AND `+published+`)`,
+		append([]interface{}{postID, groupID}, publishedArgs...)...).Scan(&visible)
+	if err != nil {
+		log.Printf("Error checking group post %d: %v", postID, err)
+		sendJSONError(w, "Failed to fetch comment", http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		sendJSONError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	comment, err := scanGroupComment(sqlite.DB.QueryRow(groupCommentQuery+`
+		JOIN group_posts gp ON gp.id = c.post_id
+		WHERE c.id = ? AND c.post_id = ? AND gp.group_id = ?`,
+		commentID, postID, groupID))
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching group comment %d: %v", commentID, err)
+		sendJSONError(w, "Failed to fetch comment", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := sqlite.DB.Query(threadQuery(groupCommentTable, groupCommentQuery), commentID, limit, offset)
+	if err != nil {
+		log.Printf("Error fetching replies of group comment %d: %v", commentID, err)
+		sendJSONError(w, "Failed to fetch replies", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var batch entityBatch
+	replies, err := scanGroupComments(rows, &batch)
+	if err != nil {
+		log.Printf("Error scanning reply: %v", err)
+		sendJSONError(w, "Error reading replies", http.StatusInternalServerError)
+		return
+	}
+	batch.resolve()
+
+	replies = nestGroupComments(replies)
+	if replies == nil {
+		replies = []m.GroupPostComment{}
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"comment": comment,
+		"replies": replies,
+		"total":   comment.ReplyCount,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
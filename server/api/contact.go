@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"social-network/models"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+	"social-network/util"
 	"strconv"
 )
 
@@ -18,19 +20,33 @@ func GetContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Contacts carry personal details, users can only list their own
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var currentUserID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&currentUserID)
+	if err != nil {
+		http.Error(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+	if currentUserID != userId {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	var users []models.User
 
-	// Get users that either follow you or you follow them
+	// Get the users the messaging policy lets you chat with
+	messageable, args := policy.MessageableCondition("u.id", userId)
 	rows, err := sqlite.DB.Query(`
-    SELECT DISTINCT
+    SELECT
         u.id, u.Email, u.Username, u.first_name, u.last_name, 
         u.date_of_birth, u.Avatar, u.about_me, u.is_private, u.created_at
     FROM users u
-    INNER JOIN followers f ON 
-        (f.follower_id = u.id AND f.followed_id = ?) OR 
-        (f.follower_id = ? AND f.followed_id = u.id)
-    WHERE f.status = 'accepted'
-    `, userId, userId)
+    WHERE `+messageable, args...)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -50,6 +66,11 @@ func GetContact(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error scanning contact: %v", err)
 			return
 		}
+		if err := hideProfileFields(&u, userId); err != nil {
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			log.Printf("Error applying field visibility: %v", err)
+			return
+		}
 
 		users = append(users, u)
 	}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// statusError is a failure that maps to a response status
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// writeStatusError responds with the status of a statusError, and with a 500
+// and the fallback message for any other error
+func writeStatusError(w http.ResponseWriter, err error, fallback string) {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		sendJSONError(w, statusErr.message, statusErr.status)
+		return
+	}
+	log.Printf("%s: %v", fallback, err)
+	sendJSONError(w, fallback, http.StatusInternalServerError)
+}
+
+// isUniqueViolation reports whether a write failed on a unique index
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	m "social-network/models"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
 	"social-network/util"
 )
 
@@ -39,13 +40,16 @@ func GetExplore(w http.ResponseWriter, r *http.Request) {
 	}
 	searchQuery := requestBody.Search
 
-	// Get all users except the current user, optionally filtering by search query
-	var rows *sql.Rows
+	// Get all users except the current user and blocked ones, optionally
+	// filtering by search query
+	notBlocked, args := policy.NotBlockedCondition("users.id", userID)
+	query := "SELECT id, username, avatar, is_private FROM users WHERE id != ? AND " + notBlocked
+	args = append([]interface{}{userID}, args...)
 	if searchQuery != "" {
-		rows, err = sqlite.DB.Query("SELECT id, username, avatar, is_private FROM users WHERE id != ? AND username LIKE ?", userID, "%"+searchQuery+"%")
-	} else {
-		rows, err = sqlite.DB.Query("SELECT id, username, avatar, is_private FROM users WHERE id != ?", userID)
+		query += " AND username LIKE ?"
+		args = append(args, "%"+searchQuery+"%")
 	}
+	rows, err := sqlite.DB.Query(query, args...)
 	if err != nil {
 		http.Error(w, "Failed to get users", http.StatusInternalServerError)
 		return
@@ -6,11 +6,76 @@ import (
 	"log"
 	"net/http"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
 	"social-network/util"
 	"strconv"
 	"time"
 )
 
+// FollowerInfo is a user in a followers, following or follow requests list
+type FollowerInfo struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"userId"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	Username  string    `json:"username"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Avatar    string    `json:"avatar"`
+}
+
+// Page sizes of the paginated follow lists
+const (
+	defaultFollowPageSize = 20
+	maxFollowPageSize     = 100
+)
+
+// followPage loads a page of follow rows, newest first, with the user in
+// userColumn of each row. where filters the followers table, aliased as f.
+// Users blocked either way by viewerID are left out.
+func followPage(userColumn, where string, args []interface{}, viewerID, limit, offset int) ([]FollowerInfo, int, error) {
+	notBlocked, blockArgs := policy.NotBlockedCondition("u.id", viewerID)
+	from := `
+		FROM followers f
+		JOIN users u ON u.id = f.` + userColumn + `
+		WHERE ` + where + ` AND ` + notBlocked
+	args = append(args, blockArgs...)
+
+	var total int
+	if err := sqlite.DB.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT f.id, u.id, f.status, f.created_at, u.username, u.first_name, u.last_name, COALESCE(u.avatar, '')`+from+`
+		ORDER BY f.created_at DESC, f.id DESC
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []FollowerInfo{}
+	for rows.Next() {
+		var f FollowerInfo
+		if err := rows.Scan(&f.ID, &f.UserID, &f.Status, &f.CreatedAt, &f.Username, &f.FirstName, &f.LastName, &f.Avatar); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, f)
+	}
+	return users, total, rows.Err()
+}
+
+// sendFollowPage responds with a page of a follow list
+func sendFollowPage(w http.ResponseWriter, users []FollowerInfo, total, limit, offset int) {
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"users":  users,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
 // FollowUser handles follow requests
 func FollowUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -68,6 +133,18 @@ func FollowUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Blocked users can't follow each other. Answer like an unknown user so a
+	// block isn't revealed.
+	rel, err := policy.LoadRelation(tx, followerID, req.UserToFollowID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !policy.CanFollow(rel) {
+		http.Error(w, "User to follow not found", http.StatusNotFound)
+		return
+	}
+
 	// Check if already following
 	var existingStatus string
 	err = tx.QueryRow(`
@@ -365,80 +442,217 @@ func HandleFollowRequest(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GetFollowers returns the list of followers or following users for a given user
+// GetFollowers returns a page of the accepted followers of a given user.
+// Private users only show it to their followers.
 func GetFollowers(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
 
-	// Get userID from URL path
-	userIDStr := r.PathValue("userID")
-	userID, err := strconv.Atoi(userIDStr)
+	limit, offset, err := parsePagination(r, defaultFollowPageSize, maxFollowPageSize)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		sendJSONError(w, "Invalid pagination", http.StatusBadRequest)
 		return
 	}
 
-	// Query to get followers with their status
-	rows, err := sqlite.DB.Query(`
-		SELECT 
-			f.id,
-			f.follower_id,
-			f.followed_id,
-			f.status,
-			f.created_at,
-			u.username,
-			u.first_name,
-			u.last_name,
-			u.avatar
-		FROM followers f
-		JOIN users u ON f.follower_id = u.id
-		WHERE f.followed_id = ? AND f.status IN ('accepted')
-	`, userID)
+	currentUserID := sessionUserID(w, r)
+	if currentUserID == 0 {
+		return
+	}
+
+	canSee, err := policy.CanUserSeeFollowers(sqlite.DB, userID, currentUserID)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Printf("Database error: %v", err)
-		http.Error(w, "Failed to get followers", http.StatusInternalServerError)
+		log.Printf("Error checking follower visibility: %v", err)
+		sendJSONError(w, "Failed to get followers", http.StatusInternalServerError)
+		return
+	}
+	if !canSee {
+		sendJSONError(w, "This account is private", http.StatusForbidden)
 		return
 	}
-	defer rows.Close()
 
-	type FollowerInfo struct {
-		ID        int       `json:"id"`
-		UserID    int       `json:"userId"`
-		Status    string    `json:"status"`
-		CreatedAt time.Time `json:"createdAt"`
-		Username  string    `json:"username"`
-		FirstName string    `json:"firstName"`
-		LastName  string    `json:"lastName"`
-		Avatar    string    `json:"avatar"`
+	users, total, err := followPage("follower_id", "f.followed_id = ? AND f.status = 'accepted'",
+		[]interface{}{userID}, currentUserID, limit, offset)
+	if err != nil {
+		log.Printf("Error getting followers: %v", err)
+		sendJSONError(w, "Failed to get followers", http.StatusInternalServerError)
+		return
 	}
 
-	var followers []FollowerInfo
-	for rows.Next() {
-		var f FollowerInfo
-		var avatar sql.NullString
-		err := rows.Scan(
-			&f.ID,
-			&f.UserID,
-			&userID, // followed_id
-			&f.Status,
-			&f.CreatedAt,
-			&f.Username,
-			&f.FirstName,
-			&f.LastName,
-			&avatar,
-		)
-		if err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue
-		}
-		f.Avatar = avatar.String // Will be empty string if NULL
-		followers = append(followers, f)
+	sendFollowPage(w, users, total, limit, offset)
+}
+
+// GetFollowing returns a page of the users a given user follows. Private
+// users only show it to their followers, like their followers list.
+func GetFollowing(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
 	}
 
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating rows: %v", err)
-		http.Error(w, "Error retrieving followers", http.StatusInternalServerError)
+	limit, offset, err := parsePagination(r, defaultFollowPageSize, maxFollowPageSize)
+	if err != nil {
+		sendJSONError(w, "Invalid pagination", http.StatusBadRequest)
 		return
 	}
 
-	json.NewEncoder(w).Encode(followers)
+	currentUserID := sessionUserID(w, r)
+	if currentUserID == 0 {
+		return
+	}
+
+	canSee, err := policy.CanUserSeeFollowers(sqlite.DB, userID, currentUserID)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking following visibility: %v", err)
+		sendJSONError(w, "Failed to get following", http.StatusInternalServerError)
+		return
+	}
+	if !canSee {
+		sendJSONError(w, "This account is private", http.StatusForbidden)
+		return
+	}
+
+	users, total, err := followPage("followed_id", "f.follower_id = ? AND f.status = 'accepted'",
+		[]interface{}{userID}, currentUserID, limit, offset)
+	if err != nil {
+		log.Printf("Error getting following: %v", err)
+		sendJSONError(w, "Failed to get following", http.StatusInternalServerError)
+		return
+	}
+
+	sendFollowPage(w, users, total, limit, offset)
+}
+
+// GetFollowRequests returns a page of the pending follow requests of the user
+// of the session. direction=incoming, the default, lists the requests they
+// received, direction=outgoing the ones they sent.
+func GetFollowRequests(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r, defaultFollowPageSize, maxFollowPageSize)
+	if err != nil {
+		sendJSONError(w, "Invalid pagination", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	var users []FollowerInfo
+	var total int
+	switch r.URL.Query().Get("direction") {
+	case "", "incoming":
+		users, total, err = followPage("follower_id", "f.followed_id = ? AND f.status = 'pending'",
+			[]interface{}{userID}, userID, limit, offset)
+	case "outgoing":
+		users, total, err = followPage("followed_id", "f.follower_id = ? AND f.status = 'pending'",
+			[]interface{}{userID}, userID, limit, offset)
+	default:
+		sendJSONError(w, "direction must be incoming or outgoing", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting follow requests: %v", err)
+		sendJSONError(w, "Failed to get follow requests", http.StatusInternalServerError)
+		return
+	}
+
+	sendFollowPage(w, users, total, limit, offset)
+}
+
+// CancelFollowRequest withdraws a pending follow request the user of the
+// session sent, along with the notification it created
+func CancelFollowRequest(w http.ResponseWriter, r *http.Request) {
+	targetID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		DELETE FROM followers
+		WHERE follower_id = ? AND followed_id = ? AND status = 'pending'`,
+		userID, targetID)
+	if err != nil {
+		sendJSONError(w, "Failed to cancel follow request", http.StatusInternalServerError)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		sendJSONError(w, "No pending follow request", http.StatusNotFound)
+		return
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM notifications
+		WHERE user_id = ? AND from_user_id = ? AND type = 'follow_request'`,
+		targetID, userID)
+	if err != nil {
+		sendJSONError(w, "Failed to cancel follow request", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to cancel follow request", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]string{
+		"message": "Follow request cancelled",
+	})
+}
+
+// RemoveFollower makes a user stop following the user of the session
+func RemoveFollower(w http.ResponseWriter, r *http.Request) {
+	followerID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	result, err := sqlite.DB.Exec(`
+		DELETE FROM followers
+		WHERE follower_id = ? AND followed_id = ? AND status = 'accepted'`,
+		followerID, userID)
+	if err != nil {
+		sendJSONError(w, "Failed to remove follower", http.StatusInternalServerError)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		sendJSONError(w, "This user doesn't follow you", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]string{
+		"message": "Follower removed",
+	})
 }
@@ -4,73 +4,20 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"social-network/models"
 	m "social-network/models"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/entities"
+	"social-network/pkg/media"
+	"social-network/pkg/policy"
 	"social-network/util"
 )
 
-// Helper function to check if user has required role
-func checkUserRole(groupID, userID int, requiredRole string) (bool, error) {
-	var role string
-	err := sqlite.DB.QueryRow(`
-		SELECT role 
-		FROM group_members 
-		WHERE group_id = ? AND user_id = ?`,
-		groupID, userID).Scan(&role)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
-		}
-		return false, err
-	}
-
-	// For creator role, only exact match
-	if requiredRole == "creator" {
-		return role == "creator", nil
-	}
-
-	// Role hierarchy: creator > admin > moderator > member
-	roleHierarchy := map[string]int{
-		"creator":   4,
-		"admin":     3,
-		"moderator": 2,
-		"member":    1,
-	}
-
-	return roleHierarchy[role] >= roleHierarchy[requiredRole], nil
-}
-
-// Helper function to check if user has admin privileges
-func hasAdminPrivileges(groupID int, userID int) (bool, error) {
-	var isCreatorOrAdmin bool
-	err := sqlite.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 
-			FROM group_members gm
-			WHERE gm.group_id = ? 
-			AND gm.user_id = ? 
-			AND (gm.role = 'creator' OR gm.role = 'admin')
-		)`, groupID, userID).Scan(&isCreatorOrAdmin)
-
-	if err != nil {
-		log.Printf("Error checking admin privileges: %v", err)
-		return false, err
-	}
-
-	return isCreatorOrAdmin, nil
-}
-
 func CreateGroup(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -93,11 +40,19 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 	var group struct {
 		Title       string `json:"title"`
 		Description string `json:"description"`
+		Visibility  string `json:"visibility"` // private unless set
 	}
 	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if group.Visibility == "" {
+		group.Visibility = string(policy.GroupPrivate)
+	}
+	if _, err := policy.ParseGroupVisibility(group.Visibility); err != nil {
+		http.Error(w, "Invalid visibility", http.StatusBadRequest)
+		return
+	}
 
 	// Start transaction
 	tx, err := sqlite.DB.Begin()
@@ -125,9 +80,9 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 
 	// Step 2: Create the group with a reference to the chat
 	result, err = tx.Exec(`
-       INSERT INTO groups (title, description, creator_id, chat_id)
-       VALUES (?, ?, ?, ?)`,
-		group.Title, group.Description, creatorID, chatID)
+       INSERT INTO groups (title, description, creator_id, chat_id, visibility)
+       VALUES (?, ?, ?, ?, ?)`,
+		group.Title, group.Description, creatorID, chatID, group.Visibility)
 	if err != nil {
 		http.Error(w, "Failed to create group", http.StatusInternalServerError)
 		return
@@ -168,18 +123,19 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 
 	// Return success response
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Group created successfully",
-		"groupId": groupID,
-		"chatId":  chatID,
+		"message":    "Group created successfully",
+		"groupId":    groupID,
+		"chatId":     chatID,
+		"visibility": group.Visibility,
 	})
 }
 
 func CreateGroupPost(w http.ResponseWriter, r *http.Request) {
-	// Parse multipart form with 10MB limit
-	err := r.ParseMultipartForm(10 << 20)
+	// Parse multipart form, the body is capped at the upload limit
+	err := parseUploadForm(w, r)
 	if err != nil {
 		log.Printf("Error parsing multipart form: %v", err)
-		http.Error(w, fmt.Sprintf("Error parsing form: %v", err), http.StatusBadRequest)
+		writeMediaError(w, err)
 		return
 	}
 
@@ -211,21 +167,16 @@ func CreateGroupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is a member of the group
-	var isMember bool
-	err = sqlite.DB.QueryRow(`
-        SELECT EXISTS(
-            SELECT 1 FROM group_members 
-            WHERE group_id = ? AND user_id = ?
-        )`, groupID, authorID).Scan(&isMember)
-	if err != nil {
-		log.Printf("Error checking membership: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	if _, err := requireGroupCapability(sqlite.DB, groupID, authorID, policy.CapPost); err != nil {
+		writeStatusError(w, err, "Database error")
 		return
 	}
-	if !isMember {
-		log.Printf("User %d is not a member of group %d", authorID, groupID)
-		http.Error(w, "Not a group member", http.StatusForbidden)
+
+	// Depending on the group's setting the post waits for a moderator
+	status, err := groupPostStatus(sqlite.DB, groupID, authorID)
+	if err != nil {
+		log.Printf("Error checking post approval: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
@@ -234,69 +185,25 @@ func CreateGroupPost(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Creating post - Title: %s, Content: %s", title, content)
 
-	var mediaPath string
-	// Handle file upload if present
-	file, header, err := r.FormFile("media")
+	// Run the attachment through the shared upload pipeline, the stored
+	// filename is what clients use to build the media URL
+	upload, err := saveUploadedMedia(r, "media", media.CategoryGroupPost, authorID)
 	if err != nil {
-		if err != http.ErrMissingFile {
-			log.Printf("Error getting file from form: %v", err)
-			http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusBadRequest)
-			return
-		}
-	} else {
-		defer file.Close()
-		log.Printf("Received file: %s, size: %d, type: %s",
-			header.Filename,
-			header.Size,
-			header.Header.Get("Content-Type"))
-
-		// Validate file type
-		fileType := header.Header.Get("Content-Type")
-		allowedTypes := map[string]bool{
-			"image/jpeg":      true,
-			"image/png":       true,
-			"image/gif":       true,
-			"application/pdf": true,
-		}
-
-		if !allowedTypes[fileType] {
-			log.Printf("Invalid file type: %s", fileType)
-			http.Error(w, "Invalid file type", http.StatusBadRequest)
-			return
-		}
-
-		// Create uploads directory if it doesn't exist
-		uploadDir := "./uploads/group_posts"
-		if err := os.MkdirAll(uploadDir, 0755); err != nil {
-			log.Printf("Error creating upload directory: %v", err)
-			http.Error(w, "Failed to create upload directory", http.StatusInternalServerError)
-			return
-		}
-
-		// Generate unique filename
-		ext := filepath.Ext(header.Filename)
-		filename := fmt.Sprintf("%d_%d_%s%s", groupID, time.Now().Unix(), util.GenerateRandomString(8), ext)
-		fullPath := filepath.Join(uploadDir, filename)
-		log.Printf("Saving file to: %s", fullPath)
-
-		dst, err := os.Create(fullPath)
-		if err != nil {
-			log.Printf("Error creating file: %v", err)
-			http.Error(w, "Failed to create file", http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
+		log.Printf("Error storing group post media: %v", err)
+		writeMediaError(w, err)
+		return
+	}
+	var mediaPath string
+	if upload != nil {
+		mediaPath = upload.Filename
+	}
 
-		written, err := io.Copy(dst, file)
-		if err != nil {
-			log.Printf("Error copying file: %v", err)
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
-			return
+	committed := false
+	defer func() {
+		if !committed {
+			discardMedia(upload)
 		}
-		log.Printf("Successfully wrote %d bytes to %s", written, fullPath)
-
-		mediaPath = filename
-	}
+	}()
 
 	// Start transaction
 	tx, err := sqlite.DB.Begin()
@@ -309,9 +216,9 @@ func CreateGroupPost(w http.ResponseWriter, r *http.Request) {
 
 	// Create post
 	result, err := tx.Exec(`
-        INSERT INTO group_posts (group_id, author_id, title, content, media, created_at)
-        VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
-		groupID, authorID, title, content, mediaPath)
+        INSERT INTO group_posts (group_id, author_id, title, content, media, media_id, status, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		groupID, authorID, title, content, mediaPath, mediaID(upload), status)
 	if err != nil {
 		log.Printf("Error creating post: %v", err)
 		http.Error(w, "Failed to create post", http.StatusInternalServerError)
@@ -321,35 +228,61 @@ func CreateGroupPost(w http.ResponseWriter, r *http.Request) {
 	postID, _ := result.LastInsertId()
 	log.Printf("Post created with ID: %d", postID)
 
+	mentioned, err := indexEntities(tx, contentGroupPost, int(postID), authorID, content)
+	if err != nil {
+		log.Printf("Error indexing post: %v", err)
+		http.Error(w, "Failed to create post", http.StatusInternalServerError)
+		return
+	}
+
+	if status == policy.PostPending {
+		err = notifyGroupModerators(tx, groupID, authorID, "group_post_pending",
+			fmt.Sprintf("%s posted %q and it is waiting for approval", username, title))
+		if err != nil {
+			log.Printf("Error notifying moderators: %v", err)
+			http.Error(w, "Failed to create post", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		log.Printf("Error committing transaction: %v", err)
 		http.Error(w, "Failed to complete post creation", http.StatusInternalServerError)
 		return
 	}
+	committed = true
+
+	// Mentions of pending posts go out when they are approved
+	if status == policy.PostPublished {
+		notifyMentions(mentioned, contentGroupPost, int(postID), authorID, groupID, content, groupAudience(groupID))
+	}
 
 	// Return the created post
 	var post struct {
-		ID        int64  `json:"id"`
-		GroupID   int    `json:"group_id"`
-		AuthorID  int    `json:"author_id"`
-		Title     string `json:"title"`
-		Content   string `json:"content"`
-		Media     string `json:"media,omitempty"`
-		CreatedAt string `json:"created_at"`
+		ID        int64             `json:"id"`
+		GroupID   int               `json:"group_id"`
+		AuthorID  int               `json:"author_id"`
+		Title     string            `json:"title"`
+		Content   string            `json:"content"`
+		Media     string            `json:"media,omitempty"`
+		Status    string            `json:"status"`
+		CreatedAt string            `json:"created_at"`
+		Entities  []entities.Entity `json:"entities,omitempty"`
 	}
 
 	err = sqlite.DB.QueryRow(`
-        SELECT id, group_id, author_id, title, content, media, created_at
+        SELECT id, group_id, author_id, title, content, COALESCE(media, ''), status, created_at
         FROM group_posts
         WHERE id = ?
-    `, postID).Scan(&post.ID, &post.GroupID, &post.AuthorID, &post.Title, &post.Content, &post.Media, &post.CreatedAt)
+    `, postID).Scan(&post.ID, &post.GroupID, &post.AuthorID, &post.Title, &post.Content, &post.Media, &post.Status, &post.CreatedAt)
 
 	if err != nil {
 		log.Printf("Error fetching created post: %v", err)
 		http.Error(w, "Failed to fetch created post", http.StatusInternalServerError)
 		return
 	}
+	post.Entities = resolveEntities(post.Content)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(post)
@@ -378,25 +311,37 @@ func GetGroupPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is a member
-	var isMember bool
-	err = sqlite.DB.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM group_members 
-			WHERE group_id = ? AND user_id = ?
-		)`, groupID, userID).Scan(&isMember)
-	if err != nil || !isMember {
-		http.Error(w, "Not a group member", http.StatusForbidden)
+	// Members read every group, everyone reads public ones
+	if err := requireGroupReader(groupID, userID); err != nil {
+		writeStatusError(w, err, "Failed to fetch posts")
+		return
+	}
+
+	// Posts waiting for approval are shown to their author and to moderators,
+	// rejected ones only to their author
+	visible, visibleArgs := policy.PublishedGroupPostCondition("p", userID)
+	moderator, _, err := policy.UserHasCapability(sqlite.DB, groupID, userID, policy.CapDeleteContent)
+	if err != nil {
+		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
 	}
+	if moderator {
+		visible = "(" + visible + " OR p.status = '" + string(policy.PostPending) + "')"
+	}
 
-	// Get posts with authors and comments
+	// Get posts with authors and comments, without the authors the user
+	// muted. Pinned posts come first, the latest pin on top.
+	notMuted, mutedArgs := policy.NotMutedUserCondition("p.author_id", userID)
+	args := append([]interface{}{groupID}, visibleArgs...)
 	rows, err := sqlite.DB.Query(`
-		SELECT p.id, p.group_id, p.author_id, u.username, p.title, p.content, p.media, p.created_at, p.updated_at
+		SELECT p.id, p.group_id, p.author_id, u.username, p.title, p.content, COALESCE(p.media, ''),
+			p.status, p.review_reason, `+activePin+`, p.pinned_until,
+			p.is_announcement, p.created_at, p.updated_at
 		FROM group_posts p
 		JOIN users u ON p.author_id = u.id
-		WHERE p.group_id = ?
-			ORDER BY p.created_at DESC`, groupID)
+		WHERE p.group_id = ? AND `+visible+` AND `+notMuted+`
+			ORDER BY `+activePin+` DESC, CASE WHEN `+activePin+` THEN p.pinned_at END DESC, p.created_at DESC`,
+		append(args, mutedArgs...)...)
 	if err != nil {
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
@@ -404,21 +349,37 @@ func GetGroupPosts(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	var posts []m.GroupPost
+	var batch entityBatch
 	for rows.Next() {
 		var post m.GroupPost
+		var pinnedUntil sql.NullTime
 		err := rows.Scan(
 			&post.ID, &post.GroupID, &post.AuthorID, &post.Author,
-			&post.Title, &post.Content, &post.Media, &post.CreatedAt, &post.UpdatedAt)
+			&post.Title, &post.Content, &post.Media, &post.Status, &post.Reason,
+			&post.Pinned, &pinnedUntil, &post.Announcement, &post.CreatedAt, &post.UpdatedAt)
 		if err != nil {
 			continue
 		}
+		if post.Pinned && pinnedUntil.Valid {
+			post.PinnedUntil = &pinnedUntil.Time
+		}
+		if post.Media != "" {
+			post.MediaURL = media.SignedURL(media.CategoryGroupPost, post.Media)
+			post.Thumbnails = mediaThumbnails(string(media.CategoryGroupPost), post.Media)
+		}
 
 		// Get comments for each post
-		comments, _ := getPostComments(post.ID)
+		comments, _ := getPostComments(post.ID, &batch)
 		post.Comments = comments
 		posts = append(posts, post)
 	}
 
+	// The mentions of the posts and all their comments are resolved at once
+	for i := range posts {
+		batch.add(posts[i].Content, &posts[i].Entities)
+	}
+	batch.resolve()
+
 	json.NewEncoder(w).Encode(posts)
 }
 
@@ -426,140 +387,64 @@ func CreateGroupPostComment(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Get path parameters
-	groupID := r.PathValue("id")
-	postID := r.PathValue("postId")
-	log.Printf("Creating comment for group %s, post %s", groupID, postID)
-
-	// Get current user from session
-	username, err := util.GetUsernameFromSession(r)
-	if err != nil {
-		log.Printf("Auth error: %v", err)
-		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Get user ID
-	var userID int
-	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
-	if err != nil {
-		log.Printf("Error getting user ID: %v", err)
-		http.Error(w, `{"error": "Failed to get user information"}`, http.StatusInternalServerError)
-		return
-	}
-
-	// Parse request body
-	var commentData struct {
-		Content string `json:"content"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&commentData); err != nil {
-		log.Printf("Error decoding request: %v", err)
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
-		return
-	}
-	log.Printf("Comment data: %+v", commentData)
-
-	// Validate content
-	if strings.TrimSpace(commentData.Content) == "" {
-		http.Error(w, `{"error": "Comment content is required"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Convert string IDs to integers
-	groupIDInt, err := strconv.Atoi(groupID)
+	groupID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		log.Printf("Invalid group ID: %v", err)
 		http.Error(w, `{"error": "Invalid group ID"}`, http.StatusBadRequest)
 		return
 	}
 
-	postIDInt, err := strconv.Atoi(postID)
+	postID, err := strconv.Atoi(r.PathValue("postId"))
 	if err != nil {
-		log.Printf("Invalid post ID: %v", err)
 		http.Error(w, `{"error": "Invalid post ID"}`, http.StatusBadRequest)
 		return
 	}
 
-	// Begin transaction
-	tx, err := sqlite.DB.Begin()
-	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
-		return
-	}
-	defer tx.Rollback()
-
-	// First verify the post exists
-	log.Printf("Checking post existence for group %d, post %d", groupIDInt, postIDInt)
-	var postExists bool
-	err = tx.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM group_posts 
-			WHERE id = ? AND group_id = ?
-		)`, postIDInt, groupIDInt).Scan(&postExists)
+	// Get current user from session
+	username, err := util.GetUsernameFromSession(r)
 	if err != nil {
-		log.Printf("Error checking post: %v", err)
-		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
-		return
-	}
-	if !postExists {
-		log.Printf("Post not found: group %d, post %d", groupIDInt, postIDInt)
-		http.Error(w, `{"error": "Post not found or doesn't belong to this group"}`, http.StatusNotFound)
+		log.Printf("Auth error: %v", err)
+		http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	// Insert comment
-	log.Printf("Inserting comment: post_id=%d, author_id=%d, content=%s",
-		postIDInt, userID, commentData.Content)
-	result, err := tx.Exec(`
-		INSERT INTO group_post_comments (post_id, author_id, content, created_at)
-		VALUES (?, ?, ?, CURRENT_TIMESTAMP)`,
-		postIDInt, userID, commentData.Content)
+	// Get user ID
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
 	if err != nil {
-		log.Printf("Error inserting comment: %v", err)
-		http.Error(w, `{"error": "Failed to create comment"}`, http.StatusInternalServerError)
+		log.Printf("Error getting user ID: %v", err)
+		http.Error(w, `{"error": "Failed to get user information"}`, http.StatusInternalServerError)
 		return
 	}
 
-	commentID, err := result.LastInsertId()
-	if err != nil {
-		log.Printf("Error getting comment ID: %v", err)
-		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
-		return
+	// Parse request body, comments with an image attachment are sent as a
+	// multipart form
+	var input newComment
+	if isMultipart(r) {
+		input, err = readCommentForm(w, r)
+		if err != nil {
+			writeCommentError(w, err)
+			return
+		}
+	} else {
+		var commentData struct {
+			Content         string `json:"content"`
+			ParentCommentID *int   `json:"parent_comment_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&commentData); err != nil {
+			log.Printf("Error decoding request: %v", err)
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+		input = newComment{
+			Content:         commentData.Content,
+			ParentCommentID: commentData.ParentCommentID,
+		}
 	}
-	log.Printf("Created comment with ID: %d", commentID)
 
-	// Get complete comment data
-	var createdComment m.GroupPostComment
-	err = tx.QueryRow(`
-		SELECT 
-			c.id,
-			c.post_id,
-			c.author_id,
-			u.username as author,
-			c.content,
-			c.created_at,
-			c.created_at as updated_at
-		FROM group_post_comments c
-		JOIN users u ON c.author_id = u.id
-		WHERE c.id = ?`,
-		commentID).Scan(
-		&createdComment.ID,
-		&createdComment.PostID,
-		&createdComment.AuthorID,
-		&createdComment.Author,
-		&createdComment.Content,
-		&createdComment.CreatedAt,
-		&createdComment.UpdatedAt,
-	)
+	// The comment service checks membership and that the post is in the group
+	createdComment, err := addGroupPostComment(userID, groupID, postID, input)
 	if err != nil {
-		log.Printf("Error retrieving comment: %v", err)
-		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
-		return
-	}
-
-	if err = tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		http.Error(w, `{"error": "Database error"}`, http.StatusInternalServerError)
+		writeCommentError(w, err)
 		return
 	}
 
@@ -568,18 +453,10 @@ func CreateGroupPostComment(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(createdComment)
 }
 
-func getPostComments(postID int) ([]m.GroupPostComment, error) {
-	rows, err := sqlite.DB.Query(`
-		SELECT 
-			c.id,
-			c.post_id,
-			c.author_id,
-			u.username as author,
-			c.content,
-			c.created_at,
-			c.created_at as updated_at
-		FROM group_post_comments c
-		JOIN users u ON c.author_id = u.id
+// getPostComments loads the comments of a group post, their entities are
+// queued in batch
+func getPostComments(postID int, batch *entityBatch) ([]m.GroupPostComment, error) {
+	rows, err := sqlite.DB.Query(groupCommentQuery+`
 		WHERE c.post_id = ?
 		ORDER BY c.created_at DESC`,
 		postID)
@@ -588,25 +465,11 @@ func getPostComments(postID int) ([]m.GroupPostComment, error) {
 	}
 	defer rows.Close()
 
-	var comments []m.GroupPostComment
-	for rows.Next() {
-		var comment m.GroupPostComment
-		err := rows.Scan(
-			&comment.ID,
-			&comment.PostID,
-			&comment.AuthorID,
-			&comment.Author,
-			&comment.Content,
-			&comment.CreatedAt,
-			&comment.UpdatedAt,
-		)
-		if err != nil {
-			log.Printf("Error scanning comment: %v", err)
-			continue
-		}
-		comments = append(comments, comment)
+	comments, err := scanGroupComments(rows, batch)
+	if err != nil {
+		log.Printf("Error scanning comment: %v", err)
+		return nil, err
 	}
-
 	return comments, nil
 }
 
@@ -629,7 +492,9 @@ func ViewGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch groups with membership status
+	// Fetch groups with membership status, secret groups only show up for
+	// their members and the users they invited
+	findable, findableArgs := policy.FindableGroupsCondition("g", userID)
 	rows, err := sqlite.DB.Query(`
 		SELECT 
 			g.id, 
@@ -638,12 +503,14 @@ func ViewGroups(w http.ResponseWriter, r *http.Request) {
 			g.creator_id, 
 			u.username as creator_username,
 			g.created_at,
+			g.visibility,
 			CASE WHEN gm.user_id IS NOT NULL THEN 1 ELSE 0 END as is_member
 		FROM groups g
 		JOIN users u ON g.creator_id = u.id
 		LEFT JOIN group_members gm ON g.id = gm.group_id AND gm.user_id = ?
+		WHERE `+findable+`
 		ORDER BY g.created_at DESC
-	`, userID)
+	`, append([]interface{}{userID}, findableArgs...)...)
 
 	if err != nil {
 		log.Printf("Database error: %v", err)
@@ -661,6 +528,7 @@ func ViewGroups(w http.ResponseWriter, r *http.Request) {
 			CreatorID       int       `json:"creator_id"`
 			CreatorUsername string    `json:"creator_username"`
 			CreatedAt       time.Time `json:"created_at"`
+			Visibility      string    `json:"visibility"`
 			IsMember        bool      `json:"is_member"`
 		}
 
@@ -671,6 +539,7 @@ func ViewGroups(w http.ResponseWriter, r *http.Request) {
 			&group.CreatorID,
 			&group.CreatorUsername,
 			&group.CreatedAt,
+			&group.Visibility,
 			&group.IsMember,
 		)
 		if err != nil {
@@ -685,6 +554,7 @@ func ViewGroups(w http.ResponseWriter, r *http.Request) {
 			"creator_id":       group.CreatorID,
 			"creator_username": group.CreatorUsername,
 			"created_at":       group.CreatedAt,
+			"visibility":       group.Visibility,
 			"is_member":        group.IsMember,
 		})
 	}
@@ -728,9 +598,19 @@ func GetGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Secret groups don't exist for users who weren't invited
+	access, err := loadGroupAccess(groupID, userID)
+	if err != nil {
+		writeStatusError(w, err, "Database error")
+		return
+	}
+
 	var group m.Group
+	var postApproval string
+	var postApprovalDays, maxPinnedPosts int
 	err = sqlite.DB.QueryRow(`
-		SELECT g.id, g.title, g.description, g.creator_id, u.username as creator_username, g.created_at
+		SELECT g.id, g.title, g.description, g.creator_id, u.username as creator_username, g.created_at,
+			g.post_approval, g.post_approval_days, g.max_pinned_posts
 		FROM groups g
 		JOIN users u ON g.creator_id = u.id
 		WHERE g.id = ?`, groupID).Scan(
@@ -739,7 +619,10 @@ func GetGroup(w http.ResponseWriter, r *http.Request) {
 		&group.Description,
 		&group.CreatorID,
 		&group.CreatorUsername,
-		&group.CreatedAt)
+		&group.CreatedAt,
+		&postApproval,
+		&postApprovalDays,
+		&maxPinnedPosts)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -751,28 +634,19 @@ func GetGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is a member or creator
-	var isMember bool
-	err = sqlite.DB.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM group_members 
-			WHERE group_id = ? AND user_id = ?
-		)`, groupID, userID).Scan(&isMember)
-
-	if err != nil {
-		log.Printf("Error checking membership: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-
 	response := map[string]interface{}{
-		"id":               group.ID,
-		"title":            group.Title,
-		"description":      group.Description,
-		"creator_id":       group.CreatorID,
-		"creator_username": group.CreatorUsername,
-		"created_at":       group.CreatedAt,
-		"is_member":        isMember,
+		"id":                 group.ID,
+		"title":              group.Title,
+		"description":        group.Description,
+		"creator_id":         group.CreatorID,
+		"creator_username":   group.CreatorUsername,
+		"created_at":         group.CreatedAt,
+		"visibility":         access.Visibility,
+		"is_member":          access.Member,
+		"can_read":           policy.CanReadGroup(access),
+		"post_approval":      postApproval,
+		"post_approval_days": postApprovalDays,
+		"max_pinned_posts":   maxPinnedPosts,
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -789,10 +663,21 @@ func GetGroupMembers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if err := requireGroupReader(groupID, userID); err != nil {
+		writeStatusError(w, err, "Failed to fetch members")
+		return
+	}
+
 	rows, err := sqlite.DB.Query(`
-		SELECT u.id, u.username, gm.role
+		SELECT u.id, u.username, gm.role, gms.user_id IS NOT NULL, gms.expires_at
 		FROM group_members gm
 		JOIN users u ON gm.user_id = u.id
+		LEFT JOIN group_member_status gms ON gms.group_id = gm.group_id AND gms.user_id = gm.user_id
+			AND gms.status = 'muted' AND (gms.expires_at IS NULL OR gms.expires_at > CURRENT_TIMESTAMP)
 		WHERE gm.group_id = ?`, groupID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -808,14 +693,21 @@ func GetGroupMembers(w http.ResponseWriter, r *http.Request) {
 			Username string `json:"username"`
 			Role     string `json:"role"`
 		}
-		if err := rows.Scan(&member.ID, &member.Username, &member.Role); err != nil {
+		var muted bool
+		var mutedUntil sql.NullTime
+		if err := rows.Scan(&member.ID, &member.Username, &member.Role, &muted, &mutedUntil); err != nil {
 			continue
 		}
-		members = append(members, map[string]interface{}{
+		entry := map[string]interface{}{
 			"id":       member.ID,
 			"username": member.Username,
 			"role":     member.Role,
-		})
+			"muted":    muted,
+		}
+		if mutedUntil.Valid {
+			entry["mutedUntil"] = mutedUntil.Time
+		}
+		members = append(members, entry)
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -853,6 +745,11 @@ func InviteToGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := requireGroupCapability(sqlite.DB, groupID, inviterID, policy.CapInvite); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
 	// Parse request body
 	var req struct {
 		Username string `json:"username"`
@@ -874,6 +771,21 @@ func InviteToGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Blocked users can't invite each other
+	rel, err := policy.LoadRelation(sqlite.DB, inviterID, inviteeID)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !policy.CanInvite(rel) {
+		sendJSONError(w, "You can't invite this user", http.StatusForbidden)
+		return
+	}
+	if err := requireNotBanned(sqlite.DB, groupID, inviteeID, "This user is banned from the group"); err != nil {
+		writeStatusError(w, err, "Database error")
+		return
+	}
+
 	// Start transaction
 	tx, err := sqlite.DB.Begin()
 	if err != nil {
@@ -1047,22 +959,29 @@ func GroupLeave(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	// Check if user is the creator - creators cannot leave their own group
-	var isCreator bool
-	err = tx.QueryRow(`
-        SELECT EXISTS(
-            SELECT 1 FROM group_members
-            WHERE group_id = ? AND user_id = ? AND role = 'creator'
-        )
-    `, groupID, userID).Scan(&isCreator)
-
+	// A leaving creator hands the group over to the member next in line
+	role, err := policy.LoadGroupRole(tx, groupID, userID)
 	if err != nil {
 		sendJSONError(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	if isCreator {
-		sendJSONError(w, "Group creators cannot leave their own group. Please delete the group or transfer ownership first.", http.StatusBadRequest)
+	var successorID int
+	if role == policy.RoleCreator {
+		successorID, err = handOverGroup(tx, groupID, userID)
+		if err != nil {
+			writeStatusError(w, err, "Failed to leave group")
+			return
+		}
+	}
+
+	// Offers to own the group die with the membership
+	_, err = tx.Exec(`
+        UPDATE group_ownership_transfers SET status = 'cancelled', responded_at = CURRENT_TIMESTAMP
+        WHERE group_id = ? AND to_user_id = ? AND status = 'pending'`,
+		groupID, userID)
+	if err != nil {
+		sendJSONError(w, "Failed to leave group", http.StatusInternalServerError)
 		return
 	}
 
@@ -1103,36 +1022,27 @@ func GroupLeave(w http.ResponseWriter, r *http.Request) {
 
 	// Get group info for notification to group admins
 	var groupName string
-	var creatorID int
-	err = tx.QueryRow(`SELECT title, creator_id FROM groups WHERE id = ?`, groupID).Scan(&groupName, &creatorID)
+	err = tx.QueryRow(`SELECT title FROM groups WHERE id = ?`, groupID).Scan(&groupName)
 	if err != nil {
 		log.Printf("Failed to get group info: %v", err)
 		groupName = "the group"
 	}
 
-	// Notify the group creator/admin about the user leaving
-	_, err = tx.Exec(`
-        INSERT INTO notifications (
-            user_id, 
-            type, 
-            content, 
-            group_id, 
-            from_user_id,
-            created_at
-        ) VALUES (
-            ?, 
-            'group_member_left', 
-            ?, 
-            ?, 
-            ?,
-            CURRENT_TIMESTAMP
-        )`,
-		creatorID,
-		fmt.Sprintf("%s has left %s", username, groupName),
-		groupID,
-		userID)
+	// Notify the group creator and admins about the user leaving
+	err = notifyGroupAdmins(tx, groupID, userID, "group_member_left", fmt.Sprintf("%s has left %s", username, groupName))
 	if err != nil {
-		log.Printf("Failed to create notification for group admin: %v", err)
+		log.Printf("Failed to create notification for group admins: %v", err)
+	}
+
+	if successorID != 0 {
+		_, err = tx.Exec(`
+            INSERT INTO notifications (user_id, type, content, group_id, from_user_id, created_at)
+            VALUES (?, 'group_ownership_received', ?, ?, ?, CURRENT_TIMESTAMP)`,
+			successorID, fmt.Sprintf("%s has left %s, you are now its owner", username, groupName),
+			groupID, userID)
+		if err != nil {
+			log.Printf("Failed to notify the new group creator: %v", err)
+		}
 	}
 
 	// Commit the transaction
@@ -1170,6 +1080,11 @@ func GetGroupEvents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := requireGroupReader(groupID, userID); err != nil {
+		writeStatusError(w, err, "Failed to get events")
+		return
+	}
+
 	rows, err := sqlite.DB.Query(`
 		SELECT 
 			e.id,
@@ -1283,6 +1198,17 @@ func CreateGroupEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The event is created by the user of the session, whatever creatorId says
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapCreateEvent); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+	event.CreatorID = userID
+
 	// Insert the event
 	result, err := sqlite.DB.Exec(`
 		INSERT INTO group_events (
@@ -1550,39 +1476,99 @@ func UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify user is creator
-	var isCreator bool
-	err = sqlite.DB.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM groups 
-			WHERE id = ? AND creator_id = (SELECT id FROM users WHERE username = ?)
-		)`, groupID, username).Scan(&isCreator)
-	if err != nil || !isCreator {
-		http.Error(w, "Only group creator can update group", http.StatusForbidden)
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapEditGroup); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
 		return
 	}
 
+	// Fields left out keep their value
 	var updateData struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
+		Title            *string `json:"title"`
+		Description      *string `json:"description"`
+		Visibility       *string `json:"visibility"`
+		PostApproval     *string `json:"post_approval"`
+		PostApprovalDays *int    `json:"post_approval_days"`
+		MaxPinnedPosts   *int    `json:"max_pinned_posts"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if updateData.Visibility != nil {
+		if _, err := policy.ParseGroupVisibility(*updateData.Visibility); err != nil {
+			http.Error(w, "Invalid visibility", http.StatusBadRequest)
+			return
+		}
+	}
+	if updateData.PostApproval != nil {
+		if _, err := policy.ParsePostApproval(*updateData.PostApproval); err != nil {
+			http.Error(w, "Invalid post approval", http.StatusBadRequest)
+			return
+		}
+	}
+	if updateData.PostApprovalDays != nil && *updateData.PostApprovalDays <= 0 {
+		http.Error(w, "post_approval_days must be positive", http.StatusBadRequest)
+		return
+	}
+	if updateData.MaxPinnedPosts != nil && *updateData.MaxPinnedPosts <= 0 {
+		http.Error(w, "max_pinned_posts must be positive", http.StatusBadRequest)
+		return
+	}
 
-	_, err = sqlite.DB.Exec(`
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var previous string
+	if err := tx.QueryRow(`SELECT visibility FROM groups WHERE id = ?`, groupID).Scan(&previous); err != nil {
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
 		UPDATE groups 
-		SET title = ?, description = ? 
+		SET title = COALESCE(?, title), description = COALESCE(?, description),
+			visibility = COALESCE(?, visibility), post_approval = COALESCE(?, post_approval),
+			post_approval_days = COALESCE(?, post_approval_days),
+			max_pinned_posts = COALESCE(?, max_pinned_posts)
 		WHERE id = ?`,
-		updateData.Title, updateData.Description, groupID)
+		updateData.Title, updateData.Description, updateData.Visibility,
+		updateData.PostApproval, updateData.PostApprovalDays, updateData.MaxPinnedPosts, groupID)
 	if err != nil {
 		http.Error(w, "Failed to update group", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Group updated successfully",
+	// Nobody has to wait for approval to join a public group
+	var joined []int
+	if updateData.Visibility != nil && *updateData.Visibility == string(policy.GroupPublic) &&
+		previous != string(policy.GroupPublic) {
+		joined, err = acceptJoinRequests(tx, groupID)
+		if err != nil {
+			log.Printf("Error opening group %d: %v", groupID, err)
+			http.Error(w, "Failed to update group", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":          "Group updated successfully",
+		"accepted_members": len(joined),
 	})
 }
 
@@ -1602,14 +1588,16 @@ func DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify user is creator
-	var isCreator bool
-	err = sqlite.DB.QueryRow(`
-       SELECT EXISTS(
-          SELECT 1 FROM groups 
-          WHERE id = ? AND creator_id = (SELECT id FROM users WHERE username = ?)
-       )`, groupID, username).Scan(&isCreator)
-	if err != nil || !isCreator {
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Deleting the group isn't a permission, it's the creator's alone
+	role, err := policy.LoadGroupRole(sqlite.DB, groupID, userID)
+	if err != nil || role != policy.RoleCreator {
 		http.Error(w, "Only group creator can delete group", http.StatusForbidden)
 		return
 	}
@@ -1751,18 +1739,6 @@ func UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user has admin privileges
-	hasPrivileges, err := hasAdminPrivileges(groupID, userID)
-	if err != nil {
-		http.Error(w, "Failed to verify permissions", http.StatusInternalServerError)
-		return
-	}
-
-	if !hasPrivileges {
-		http.Error(w, "Only group creator and admins can update member roles", http.StatusForbidden)
-		return
-	}
-
 	// Start transaction
 	tx, err := sqlite.DB.Begin()
 	if err != nil {
@@ -1771,42 +1747,28 @@ func UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	// Get current role of the user making the change
-	var currentUserRole string
-	err = tx.QueryRow(`
-		SELECT role FROM group_members 
-		WHERE group_id = ? AND user_id = ?`,
-		groupID, userID).Scan(&currentUserRole)
+	currentUserRole, err := requireGroupCapability(tx, groupID, userID, policy.CapManageRoles)
 	if err != nil {
-		http.Error(w, "Failed to get user role", http.StatusInternalServerError)
+		writeStatusError(w, err, "Failed to verify permissions")
 		return
 	}
 
 	// Get target member's current role
-	var targetRole string
-	err = tx.QueryRow(`
-		SELECT role FROM group_members 
-		WHERE group_id = ? AND user_id = ?`,
-		groupID, memberID).Scan(&targetRole)
+	targetRole, err := policy.LoadGroupRole(tx, groupID, memberID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Member not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Database error", http.StatusInternalServerError)
-		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-
-	// Role hierarchy
-	roleHierarchy := map[string]int{
-		"creator":   4,
-		"admin":     3,
-		"moderator": 2,
-		"member":    1,
+	if !targetRole.IsMember() {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
 	}
 
-	// Only creator can modify admin roles
-	if targetRole == "creator" || (currentUserRole != "creator" && roleHierarchy[targetRole] >= roleHierarchy[currentUserRole]) {
+	// Members only manage the roles below their own, only the creator
+	// appoints admins
+	newRole := policy.GroupRole(updateData.Role)
+	if currentUserRole != policy.RoleCreator &&
+		(!currentUserRole.Outranks(targetRole) || !currentUserRole.Outranks(newRole)) {
 		http.Error(w, "Insufficient permissions to modify this role", http.StatusForbidden)
 		return
 	}
@@ -1853,6 +1815,11 @@ func RemoveMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
 	// Start transaction
 	tx, err := sqlite.DB.Begin()
 	if err != nil {
@@ -1861,6 +1828,27 @@ func RemoveMember(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
+	role, err := requireGroupCapability(tx, groupID, userID, policy.CapRemoveMember)
+	if err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	// Members only remove the members below their own role
+	memberRole, err := policy.LoadGroupRole(tx, groupID, memberID)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !memberRole.IsMember() {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if !role.Outranks(memberRole) {
+		sendJSONError(w, "You can't remove this member", http.StatusForbidden)
+		return
+	}
+
 	// Get the chat_id for this group
 	var chatID int
 	err = tx.QueryRow(`SELECT chat_id FROM groups WHERE id = ?`, groupID).Scan(&chatID)
@@ -1954,13 +1942,8 @@ func GetGroupRequests(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if user has permission to view requests
-	hasPermission, err := checkUserRole(groupID, userID, "admin")
-	if err != nil {
-		sendJSONError(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	if !hasPermission {
-		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapApproveRequests); err != nil {
+		writeStatusError(w, err, "Database error")
 		return
 	}
 
@@ -2115,6 +2098,21 @@ func RequestJoinGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Public groups are joined right away, secret ones only by invitation
+	access, err := loadGroupAccess(groupID, userID)
+	if err != nil {
+		writeStatusError(w, err, "Failed to get group information")
+		return
+	}
+	if access.Visibility == policy.GroupSecret && !access.Member {
+		sendJSONError(w, "This group can only be joined by invitation", http.StatusForbidden)
+		return
+	}
+	if err := requireNotBanned(sqlite.DB, groupID, userID, "You are banned from this group"); err != nil {
+		writeStatusError(w, err, "Failed to get group information")
+		return
+	}
+
 	// Start transaction
 	tx, err := sqlite.DB.Begin()
 	if err != nil {
@@ -2139,6 +2137,23 @@ func RequestJoinGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if policy.CanJoinGroup(access) {
+		if err := addGroupMember(tx, groupID, userID, "member"); err != nil {
+			log.Printf("Error joining group %d: %v", groupID, err)
+			sendJSONError(w, "Failed to join group", http.StatusInternalServerError)
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			sendJSONError(w, "Failed to join group", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"message": "Joined group successfully",
+			"joined":  true,
+		})
+		return
+	}
+
 	// Check for existing request
 	var hasRequest bool
 	err = tx.QueryRow(`
@@ -2216,6 +2231,7 @@ func RequestJoinGroup(w http.ResponseWriter, r *http.Request) {
 	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
 		"message":   "Join request sent successfully",
 		"requestId": requestID,
+		"joined":    false,
 	})
 }
 
@@ -2323,6 +2339,11 @@ func HandleInvitation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if action == "accept" {
+		if err := requireNotBanned(tx, groupID, userID, "You are banned from this group"); err != nil {
+			writeStatusError(w, err, "Database error")
+			return
+		}
+
 		// Get the chat_id for this group
 		var chatID int
 		err = tx.QueryRow(`SELECT chat_id FROM groups WHERE id = ?`, groupID).Scan(&chatID)
@@ -2423,7 +2444,7 @@ func HandleInvitation(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func CreatePostComment(w http.ResponseWriter, r *http.Request) {
+func GetGroupPostComments(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	groupID, err := strconv.Atoi(r.PathValue("id"))
@@ -2452,137 +2473,34 @@ func CreatePostComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify user is a member
-	var isMember bool
-	err = sqlite.DB.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM group_members 
-			WHERE group_id = ? AND user_id = ?
-		)`, groupID, userID).Scan(&isMember)
-	if err != nil || !isMember {
-		http.Error(w, "Not a group member", http.StatusForbidden)
-		return
-	}
-
-	// Parse request body
-	var comment struct {
-		Content string `json:"content"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Create comment
-	result, err := sqlite.DB.Exec(`
-		INSERT INTO group_post_comments (post_id, author_id, content)
-		VALUES (?, ?, ?)`,
-		postID, userID, comment.Content)
-	if err != nil {
-		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
-		return
-	}
-
-	commentID, _ := result.LastInsertId()
-
-	// Get the created comment with author info
-	var createdComment struct {
-		ID        int64  `json:"id"`
-		Content   string `json:"content"`
-		AuthorID  int64  `json:"author_id"`
-		Author    string `json:"author"`
-		CreatedAt string `json:"created_at"`
-	}
-
-	err = sqlite.DB.QueryRow(`
-		SELECT c.id, c.content, c.author_id, u.username, c.created_at
-		FROM group_post_comments c
-		JOIN users u ON c.author_id = u.id
-		WHERE c.id = ?`,
-		commentID).Scan(&createdComment.ID, &createdComment.Content, &createdComment.AuthorID, &createdComment.Author, &createdComment.CreatedAt)
-	if err != nil {
-		http.Error(w, "Failed to fetch created comment", http.StatusInternalServerError)
-		return
-	}
-
-	json.NewEncoder(w).Encode(createdComment)
-}
-
-func GetGroupPostComments(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	groupID, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil {
-		http.Error(w, "Invalid group ID", http.StatusBadRequest)
-		return
-	}
-
-	postID, err := strconv.Atoi(r.PathValue("postId"))
-	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
-		return
-	}
-
-	// Get current user
-	username, err := util.GetUsernameFromSession(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if err := requireGroupReader(groupID, userID); err != nil {
+		writeStatusError(w, err, "Failed to fetch comments")
 		return
 	}
 
-	var userID int
-	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	// Verify user is a member
-	var isMember bool
-	err = sqlite.DB.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM group_members 
-			WHERE group_id = ? AND user_id = ?
-		)`, groupID, userID).Scan(&isMember)
-	if err != nil || !isMember {
-		http.Error(w, "Not a group member", http.StatusForbidden)
+	// Reading a group doesn't open the posts of other groups, nor the
+	// unpublished posts of others
+	published, publishedArgs := policy.PublishedGroupPostCondition("gp", userID)
+	var inGroup bool
+	err = sqlite.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM group_posts gp WHERE gp.id = ? AND gp.group_id = ? AND `+published+`)`,
+		append([]interface{}{postID, groupID}, publishedArgs...)...).Scan(&inGroup)
+	if err != nil || !inGroup {
+		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
 
 	// Get comments
-	rows, err := sqlite.DB.Query(`
-		SELECT c.id, c.content, c.author_id, u.username, c.created_at
-		FROM group_post_comments c
-		JOIN users u ON c.author_id = u.id
-		WHERE c.post_id = ?
-		ORDER BY c.created_at DESC`,
-		postID)
+	var batch entityBatch
+	comments, err := getPostComments(postID, &batch)
 	if err != nil {
 		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	batch.resolve()
 
-	var comments []map[string]interface{}
-	for rows.Next() {
-		var comment struct {
-			ID        int64  `json:"id"`
-			Content   string `json:"content"`
-			AuthorID  int64  `json:"author_id"`
-			Author    string `json:"author"`
-			CreatedAt string `json:"created_at"`
-		}
-		err := rows.Scan(&comment.ID, &comment.Content, &comment.AuthorID, &comment.Author, &comment.CreatedAt)
-		if err != nil {
-			continue
-		}
-		comments = append(comments, map[string]interface{}{
-			"id":         comment.ID,
-			"content":    comment.Content,
-			"author_id":  comment.AuthorID,
-			"author":     comment.Author,
-			"created_at": comment.CreatedAt,
-		})
+	// ?view=nested returns top level comments with their replies below them
+	if nestedView(r) {
+		comments = nestGroupComments(comments)
 	}
 
 	json.NewEncoder(w).Encode(comments)
@@ -2614,6 +2532,15 @@ func HandleJoinRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapApproveRequests); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
 	// Start transaction
 	tx, err := sqlite.DB.Begin()
 	if err != nil {
@@ -2792,22 +2719,5 @@ func GetMemberRole(w http.ResponseWriter, r *http.Request) {
 }
 
 func ServeGroupPostMedia(w http.ResponseWriter, r *http.Request) {
-	filename := r.PathValue("filename")
-	if filename == "" {
-		http.Error(w, "No filename provided", http.StatusBadRequest)
-		return
-	}
-
-	// Sanitize filename to prevent directory traversal
-	filename = filepath.Base(filename)
-
-	filePath := filepath.Join("./uploads/group_posts", filename)
-
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-
-	http.ServeFile(w, r, filePath)
+	serveMedia(w, r, media.CategoryGroupPost, r.PathValue("filename"))
 }
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// loadGroupAccess looks up how userID relates to groupID. Groups the user
+// can't find are reported as not found, like groups that don't exist.
+func loadGroupAccess(groupID, userID int) (policy.GroupAccess, error) {
+	access, err := policy.LoadGroupAccess(sqlite.DB, groupID, userID)
+	if err == sql.ErrNoRows || (err == nil && !policy.CanFindGroup(access)) {
+		return access, &statusError{http.StatusNotFound, "Group not found"}
+	}
+	if err != nil {
+		return access, fmt.Errorf("failed to load group access: %w", err)
+	}
+	return access, nil
+}
+
+// requireGroupReader fails unless userID can read the content of groupID
+func requireGroupReader(groupID, userID int) error {
+	access, err := loadGroupAccess(groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !policy.CanReadGroup(access) {
+		return &statusError{http.StatusForbidden, "Not a group member"}
+	}
+	return nil
+}
+
+// requireGroupCapability fails unless userID can do capability in groupID,
+// given their role and the group's overrides. Muted members can't do what
+// adds content. Group handlers authorize through it. It returns the user's
+// role, empty for non-members.
+func requireGroupCapability(q policy.GroupQuerier, groupID, userID int, capability policy.Capability) (policy.GroupRole, error) {
+	allowed, role, err := policy.UserHasCapability(q, groupID, userID, capability)
+	if err != nil {
+		return role, err
+	}
+	if !role.IsMember() {
+		return role, &statusError{http.StatusForbidden, "Not a group member"}
+	}
+	if !allowed {
+		return role, &statusError{http.StatusForbidden,
+			fmt.Sprintf("Your role doesn't have the %s permission in this group", capability)}
+	}
+	if capability.Speaks() {
+		if err := requireNotMuted(q, groupID, userID); err != nil {
+			return role, err
+		}
+	}
+	return role, nil
+}
+
+// requireNotMuted fails when a moderator muted userID in groupID
+func requireNotMuted(q policy.Querier, groupID, userID int) error {
+	restriction, err := policy.LoadMemberRestriction(q, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if restriction.Status == policy.MemberMuted {
+		return &statusError{http.StatusForbidden, restrictionMessage("You are muted in this group", restriction)}
+	}
+	return nil
+}
+
+// requireNotBanned fails when userID is banned from groupID, with message
+// as the error
+func requireNotBanned(q policy.Querier, groupID, userID int, message string) error {
+	restriction, err := policy.LoadMemberRestriction(q, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if restriction.Status == policy.MemberBanned {
+		return &statusError{http.StatusForbidden, restrictionMessage(message, restriction)}
+	}
+	return nil
+}
+
+// restrictionMessage adds when a ban or mute ends to message
+func restrictionMessage(message string, restriction policy.MemberRestriction) string {
+	if restriction.ExpiresAt != nil {
+		message += " until " + restriction.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return message
+}
+
+// requireGroupMember fails unless userID is a member of groupID, for what
+// any member can do whatever the group's permissions. It returns their role.
+func requireGroupMember(q policy.Querier, groupID, userID int) (policy.GroupRole, error) {
+	role, err := policy.LoadGroupRole(q, groupID, userID)
+	if err != nil {
+		return role, err
+	}
+	if !role.IsMember() {
+		return role, &statusError{http.StatusForbidden, "Not a group member"}
+	}
+	return role, nil
+}
+
+// addGroupMember makes userID a member of groupID with role and lets them
+// into the group chat
+func addGroupMember(tx sqlExecer, groupID, userID int, role string) error {
+	_, err := tx.Exec(`
+		INSERT INTO group_members (group_id, user_id, role, joined_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)`, groupID, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to add group member: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_chat_status (user_id, chat_id)
+		SELECT ?, chat_id FROM groups WHERE id = ? AND chat_id IS NOT NULL
+		ON CONFLICT(user_id, chat_id) DO NOTHING`, userID, groupID)
+	if err != nil {
+		return fmt.Errorf("failed to add member to group chat: %w", err)
+	}
+	return nil
+}
+
+// acceptJoinRequests lets in everyone waiting for approval to join groupID,
+// for groups that became public. It returns who joined.
+func acceptJoinRequests(tx sqlExecer, groupID int) ([]int, error) {
+	rows, err := tx.Query(`
+		SELECT DISTINCT invitee_id FROM group_invitations
+		WHERE group_id = ? AND type = 'request' AND status = 'pending'
+		AND invitee_id NOT IN (SELECT user_id FROM group_members WHERE group_id = ?)`,
+		groupID, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load join requests: %w", err)
+	}
+	var joined []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan join request: %w", err)
+		}
+		joined = append(joined, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load join requests: %w", err)
+	}
+
+	for _, userID := range joined {
+		if err := addGroupMember(tx, groupID, userID, "member"); err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO notifications (user_id, type, content, group_id, created_at)
+			VALUES (?, 'group_join_accepted', 'Your request to join the group has been accepted', ?, CURRENT_TIMESTAMP)`,
+			userID, groupID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to notify accepted member: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE group_invitations SET status = 'accepted'
+		WHERE group_id = ? AND type = 'request' AND status = 'pending'`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept join requests: %w", err)
+	}
+	_, err = tx.Exec(`
+		DELETE FROM notifications WHERE type = 'group_join_request' AND group_id = ?`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear join request notifications: %w", err)
+	}
+	return joined, nil
+}
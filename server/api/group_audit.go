@@ -0,0 +1,122 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// Actions recorded in the group audit log
+const (
+	auditInviteLinkCreated = "invite_link_created"
+	auditInviteLinkRevoked = "invite_link_revoked"
+	auditInviteLinkJoined  = "invite_link_joined"
+)
+
+// GroupAuditEntry is an entry of a group's audit log
+type GroupAuditEntry struct {
+	ID             int       `json:"id"`
+	Action         string    `json:"action"`
+	ActorID        *int      `json:"actorId,omitempty"`
+	ActorUsername  string    `json:"actorUsername,omitempty"`
+	TargetUserID   *int      `json:"targetUserId,omitempty"`
+	TargetUsername string    `json:"targetUsername,omitempty"`
+	InviteLinkID   *int      `json:"inviteLinkId,omitempty"`
+	Details        string    `json:"details,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// recordGroupAudit adds an entry to the audit log of groupID. targetUserID
+// and inviteLinkID are left out when 0.
+func recordGroupAudit(tx sqlExecer, groupID, actorID int, action string, targetUserID, inviteLinkID int, details string) error {
+	_, err := tx.Exec(`
+		INSERT INTO group_audit_log (group_id, actor_id, action, target_user_id, invite_link_id, details)
+		VALUES (?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), ?)`,
+		groupID, actorID, action, targetUserID, inviteLinkID, details)
+	if err != nil {
+		return fmt.Errorf("failed to record group audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetGroupAuditLog lists what happened in a group, the newest first, for
+// the members who approve who gets in. ?before=<id> pages back.
+func GetGroupAuditLog(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	before := 0
+	if value := r.URL.Query().Get("before"); value != "" {
+		before, err = strconv.Atoi(value)
+		if err != nil || before <= 0 {
+			sendJSONError(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapApproveRequests); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT l.id, l.action, l.actor_id, COALESCE(a.username, ''),
+			l.target_user_id, COALESCE(t.username, ''), l.invite_link_id, l.details, l.created_at
+		FROM group_audit_log l
+		LEFT JOIN users a ON a.id = l.actor_id
+		LEFT JOIN users t ON t.id = l.target_user_id
+		WHERE l.group_id = ? AND (? = 0 OR l.id < ?)
+		ORDER BY l.id DESC
+		LIMIT 50`, groupID, before, before)
+	if err != nil {
+		log.Printf("Error getting group audit log: %v", err)
+		sendJSONError(w, "Failed to get audit log", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []GroupAuditEntry{}
+	for rows.Next() {
+		var entry GroupAuditEntry
+		var actorID, targetUserID, inviteLinkID sql.NullInt64
+		if err := rows.Scan(&entry.ID, &entry.Action, &actorID, &entry.ActorUsername,
+			&targetUserID, &entry.TargetUsername, &inviteLinkID, &entry.Details, &entry.CreatedAt); err != nil {
+			log.Printf("Error scanning group audit entry: %v", err)
+			sendJSONError(w, "Failed to get audit log", http.StatusInternalServerError)
+			return
+		}
+		entry.ActorID = nullableID(actorID)
+		entry.TargetUserID = nullableID(targetUserID)
+		entry.InviteLinkID = nullableID(inviteLinkID)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating group audit log: %v", err)
+		sendJSONError(w, "Failed to get audit log", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, entries)
+}
+
+// nullableID turns a NULL ID column into nil
+func nullableID(id sql.NullInt64) *int {
+	if !id.Valid {
+		return nil
+	}
+	value := int(id.Int64)
+	return &value
+}
@@ -0,0 +1,402 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// GroupInviteLink is a link that lets whoever holds its token join a group
+// with Role, without a join request
+type GroupInviteLink struct {
+	ID                int        `json:"id"`
+	GroupID           int        `json:"groupId"`
+	Token             string     `json:"token"`
+	CreatedByID       *int       `json:"createdById,omitempty"`
+	CreatedByUsername string     `json:"createdBy,omitempty"`
+	Role              string     `json:"role"`
+	MaxUses           *int       `json:"maxUses,omitempty"`
+	Uses              int        `json:"uses"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt         *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	Active            bool       `json:"active"`
+}
+
+// validInviteLink is the SQL condition of the links, aliased as l, that can
+// still be redeemed
+const validInviteLink = `l.revoked_at IS NULL
+	AND (l.expires_at IS NULL OR l.expires_at > CURRENT_TIMESTAMP)
+	AND (l.max_uses IS NULL OR l.uses < l.max_uses)`
+
+const inviteLinkQuery = `
+	SELECT l.id, l.group_id, l.token, l.created_by, COALESCE(u.username, ''), l.role,
+		l.max_uses, l.uses, l.expires_at, l.revoked_at, l.created_at,
+		` + validInviteLink + `
+	FROM group_invite_links l
+	LEFT JOIN users u ON u.id = l.created_by`
+
+func scanInviteLink(row interface{ Scan(...interface{}) error }) (GroupInviteLink, error) {
+	var link GroupInviteLink
+	var createdBy, maxUses sql.NullInt64
+	var expiresAt, revokedAt sql.NullTime
+	err := row.Scan(&link.ID, &link.GroupID, &link.Token, &createdBy, &link.CreatedByUsername, &link.Role,
+		&maxUses, &link.Uses, &expiresAt, &revokedAt, &link.CreatedAt, &link.Active)
+	if err != nil {
+		return link, err
+	}
+	link.CreatedByID = nullableID(createdBy)
+	link.MaxUses = nullableID(maxUses)
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		link.RevokedAt = &revokedAt.Time
+	}
+	return link, nil
+}
+
+// newInviteToken returns a random URL safe token for an invite link
+func newInviteToken() (string, error) {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate invite token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// CreateGroupInviteLink makes an invite link for a group, as {"role":
+// "member", "maxUses": 10, "duration": "168h"}. Every field is optional, the
+// role defaults to member and has to be below the creator's own.
+func CreateGroupInviteLink(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Role      string     `json:"role"`
+		MaxUses   *int       `json:"maxUses"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+		Duration  string     `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	linkRole := policy.RoleMember
+	if body.Role != "" {
+		linkRole, err = policy.ParseGroupRole(body.Role)
+		if err != nil || linkRole == policy.RoleCreator {
+			sendJSONError(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+	}
+	if body.MaxUses != nil && *body.MaxUses <= 0 {
+		sendJSONError(w, "maxUses must be positive", http.StatusBadRequest)
+		return
+	}
+	expiresAt, err := parseExpiry(body.ExpiresAt, body.Duration)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	role, err := requireGroupCapability(tx, groupID, userID, policy.CapApproveRequests)
+	if err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+	if !role.Outranks(linkRole) {
+		sendJSONError(w, "You can't create links for "+string(linkRole)+"s", http.StatusForbidden)
+		return
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		log.Printf("Error creating invite link: %v", err)
+		sendJSONError(w, "Failed to create invite link", http.StatusInternalServerError)
+		return
+	}
+	result, err := tx.Exec(`
+		INSERT INTO group_invite_links (group_id, token, created_by, role, max_uses, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		groupID, token, userID, linkRole, body.MaxUses, sqlExpiry(expiresAt))
+	if err != nil {
+		log.Printf("Error creating invite link: %v", err)
+		sendJSONError(w, "Failed to create invite link", http.StatusInternalServerError)
+		return
+	}
+	linkID, _ := result.LastInsertId()
+
+	if err := recordGroupAudit(tx, groupID, userID, auditInviteLinkCreated, 0, int(linkID), string(linkRole)); err != nil {
+		log.Printf("Error creating invite link: %v", err)
+		sendJSONError(w, "Failed to create invite link", http.StatusInternalServerError)
+		return
+	}
+
+	link, err := scanInviteLink(tx.QueryRow(inviteLinkQuery+` WHERE l.id = ?`, linkID))
+	if err != nil {
+		log.Printf("Error loading invite link: %v", err)
+		sendJSONError(w, "Failed to create invite link", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to create invite link", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, link)
+}
+
+// GetGroupInviteLinks lists the invite links of a group, revoked and used up
+// ones included
+func GetGroupInviteLinks(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapApproveRequests); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	rows, err := sqlite.DB.Query(inviteLinkQuery+`
+		WHERE l.group_id = ?
+		ORDER BY l.created_at DESC, l.id DESC`, groupID)
+	if err != nil {
+		log.Printf("Error getting invite links: %v", err)
+		sendJSONError(w, "Failed to get invite links", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	links := []GroupInviteLink{}
+	for rows.Next() {
+		link, err := scanInviteLink(rows)
+		if err != nil {
+			log.Printf("Error scanning invite link: %v", err)
+			sendJSONError(w, "Failed to get invite links", http.StatusInternalServerError)
+			return
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating invite links: %v", err)
+		sendJSONError(w, "Failed to get invite links", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, links)
+}
+
+// RevokeGroupInviteLink stops an invite link from working. Members who
+// joined through it stay.
+func RevokeGroupInviteLink(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	linkID, err := strconv.Atoi(r.PathValue("linkId"))
+	if err != nil {
+		sendJSONError(w, "Invalid link ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	role, err := requireGroupCapability(tx, groupID, userID, policy.CapApproveRequests)
+	if err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	link, err := scanInviteLink(tx.QueryRow(inviteLinkQuery+` WHERE l.id = ? AND l.group_id = ?`, linkID, groupID))
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Invite link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading invite link: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if link.RevokedAt != nil {
+		sendJSONError(w, "Invite link is already revoked", http.StatusBadRequest)
+		return
+	}
+	if !role.Outranks(policy.GroupRole(link.Role)) {
+		sendJSONError(w, "You can't revoke links for "+link.Role+"s", http.StatusForbidden)
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE group_invite_links SET revoked_at = CURRENT_TIMESTAMP WHERE id = ?`, linkID)
+	if err == nil {
+		err = recordGroupAudit(tx, groupID, userID, auditInviteLinkRevoked, 0, linkID, "")
+	}
+	if err != nil {
+		log.Printf("Error revoking invite link %d: %v", linkID, err)
+		sendJSONError(w, "Failed to revoke invite link", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to revoke invite link", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Invite link revoked",
+		"id":      linkID,
+	})
+}
+
+// RedeemGroupInviteLink makes the user of the session a member of the
+// link's group with the link's role, skipping the join request queue
+func RedeemGroupInviteLink(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Taking the use is the first write, so two users can't both redeem the
+	// last use of a link. Refusing the user below rolls it back.
+	result, err := tx.Exec(`
+		UPDATE group_invite_links AS l SET uses = uses + 1
+		WHERE l.token = ? AND `+validInviteLink, token)
+	if err != nil {
+		log.Printf("Error redeeming invite link: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if redeemed, err := result.RowsAffected(); err != nil || redeemed == 0 {
+		sendJSONError(w, "Invite link is invalid or expired", http.StatusNotFound)
+		return
+	}
+
+	var linkID, groupID int
+	var linkRole, title string
+	err = tx.QueryRow(`
+		SELECT l.id, l.group_id, l.role, g.title
+		FROM group_invite_links l
+		JOIN groups g ON g.id = l.group_id
+		WHERE l.token = ?`, token).Scan(&linkID, &groupID, &linkRole, &title)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Invite link is invalid or expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading invite link: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := requireNotBanned(tx, groupID, userID, "You are banned from this group"); err != nil {
+		writeStatusError(w, err, "Failed to join group")
+		return
+	}
+	role, err := policy.LoadGroupRole(tx, groupID, userID)
+	if err != nil {
+		log.Printf("Error loading group role: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if role.IsMember() {
+		sendJSONError(w, "User is already a member", http.StatusBadRequest)
+		return
+	}
+
+	if err := addGroupMember(tx, groupID, userID, linkRole); err != nil {
+		log.Printf("Error joining group %d through invite link %d: %v", groupID, linkID, err)
+		sendJSONError(w, "Failed to join group", http.StatusInternalServerError)
+		return
+	}
+	// Invitations and requests waiting for this user are answered by the link
+	_, err = tx.Exec(`
+		UPDATE group_invitations SET status = 'accepted'
+		WHERE group_id = ? AND invitee_id = ? AND status = 'pending'`, groupID, userID)
+	if err == nil {
+		_, err = tx.Exec(`
+			DELETE FROM notifications
+			WHERE group_id = ? AND ((type = 'group_invitation' AND user_id = ?)
+				OR (type = 'group_join_request' AND from_user_id = ?))`, groupID, userID, userID)
+	}
+	if err == nil {
+		err = recordGroupAudit(tx, groupID, userID, auditInviteLinkJoined, 0, linkID, linkRole)
+	}
+	if err == nil {
+		var username string
+		if err = tx.QueryRow(`SELECT username FROM users WHERE id = ?`, userID).Scan(&username); err == nil {
+			err = notifyGroupAdmins(tx, groupID, userID, "group_member_joined",
+				fmt.Sprintf("%s joined %s through an invite link", username, title))
+		}
+	}
+	if err != nil {
+		log.Printf("Error joining group %d through invite link %d: %v", groupID, linkID, err)
+		sendJSONError(w, "Failed to join group", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to join group", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Joined group successfully",
+		"joined":  true,
+		"groupId": groupID,
+		"role":    linkRole,
+	})
+}
@@ -0,0 +1,460 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// maxModerationReason is the longest reason a ban or mute can be given
+const maxModerationReason = 500
+
+// GroupBan is an entry of a group's ban list
+type GroupBan struct {
+	UserID     int        `json:"userId"`
+	Username   string     `json:"username"`
+	Reason     string     `json:"reason"`
+	BannedByID *int       `json:"bannedById,omitempty"`
+	BannedBy   string     `json:"bannedBy,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// moderationRequest is the body of a ban or mute. Without expiresAt or
+// duration it lasts until it is lifted.
+type moderationRequest struct {
+	UserID    int        `json:"userId"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Duration  string     `json:"duration"`
+}
+
+// readModerationRequest reads the body of a ban or mute, which can be empty
+func readModerationRequest(r *http.Request) (moderationRequest, *time.Time, error) {
+	var req moderationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return req, nil, errors.New("Invalid request body")
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > maxModerationReason {
+		return req, nil, fmt.Errorf("Reason can't be longer than %d characters", maxModerationReason)
+	}
+	expiresAt, err := parseExpiry(req.ExpiresAt, req.Duration)
+	return req, expiresAt, err
+}
+
+// requireModerator checks that userID can moderate targetID in groupID:
+// they need the remove_member permission and to outrank the target when the
+// target is a member. It returns the target's role, empty for non-members.
+func requireModerator(tx *sql.Tx, groupID, userID, targetID int) (policy.GroupRole, error) {
+	role, err := requireGroupCapability(tx, groupID, userID, policy.CapRemoveMember)
+	if err != nil {
+		return "", err
+	}
+	if targetID == userID {
+		return "", &statusError{http.StatusBadRequest, "You can't moderate yourself"}
+	}
+	targetRole, err := policy.LoadGroupRole(tx, groupID, targetID)
+	if err != nil {
+		return "", err
+	}
+	if targetRole.IsMember() && !role.Outranks(targetRole) {
+		return "", &statusError{http.StatusForbidden, "You can't moderate this member"}
+	}
+	return targetRole, nil
+}
+
+// restrictMember stores a ban or mute of userID in groupID, replacing the
+// one they had
+func restrictMember(tx *sql.Tx, groupID, userID, actorID int, status policy.MemberStatus, reason string, expiresAt *time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO group_member_status (group_id, user_id, status, reason, actor_id, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(group_id, user_id) DO UPDATE SET
+			status = excluded.status,
+			reason = excluded.reason,
+			actor_id = excluded.actor_id,
+			expires_at = excluded.expires_at,
+			created_at = CURRENT_TIMESTAMP`,
+		groupID, userID, status, reason, actorID, sqlExpiry(expiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to store member status: %w", err)
+	}
+	return nil
+}
+
+// notifyRestriction tells userID they were banned from or muted in groupID,
+// action reads "banned from" or "muted in"
+func notifyRestriction(tx *sql.Tx, groupID, userID, actorID int, kind, action, reason string, expiresAt *time.Time) error {
+	var title string
+	if err := tx.QueryRow(`SELECT title FROM groups WHERE id = ?`, groupID).Scan(&title); err != nil {
+		return fmt.Errorf("failed to load group title: %w", err)
+	}
+
+	content := restrictionMessage(fmt.Sprintf("You have been %s %s", action, title),
+		policy.MemberRestriction{ExpiresAt: expiresAt})
+	if reason != "" {
+		content += ": " + reason
+	}
+	_, err := tx.Exec(`
+		INSERT INTO notifications (user_id, type, content, group_id, from_user_id, created_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		userID, kind, content, groupID, actorID)
+	if err != nil {
+		return fmt.Errorf("failed to notify member: %w", err)
+	}
+	return nil
+}
+
+// clearExpiredRestrictions deletes the bans and mutes of groupID that ran
+// out. They already stopped applying, this keeps the table small.
+func clearExpiredRestrictions(q sqlExecer, groupID int) error {
+	_, err := q.Exec(`
+		DELETE FROM group_member_status
+		WHERE group_id = ? AND expires_at IS NOT NULL AND expires_at <= CURRENT_TIMESTAMP`, groupID)
+	if err != nil {
+		return fmt.Errorf("failed to clear expired member statuses: %w", err)
+	}
+	return nil
+}
+
+// BanGroupMember removes a user from a group and keeps them from joining,
+// being invited, posting or chatting there until the ban expires or is
+// lifted, as {"userId": 5, "reason": "spam", "duration": "72h"}. Users who
+// aren't members can be banned too.
+func BanGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	req, expiresAt, err := readModerationRequest(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserID == 0 {
+		sendJSONError(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	targetRole, err := requireModerator(tx, groupID, userID, req.UserID)
+	if err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, req.UserID).Scan(&exists)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		sendJSONError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if targetRole.IsMember() {
+		_, err = tx.Exec(`DELETE FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, req.UserID)
+		if err == nil {
+			_, err = tx.Exec(`
+				DELETE FROM user_chat_status
+				WHERE user_id = ? AND chat_id = (SELECT chat_id FROM groups WHERE id = ?)`,
+				req.UserID, groupID)
+		}
+		if err == nil {
+			_, err = tx.Exec(`
+				UPDATE group_ownership_transfers SET status = 'cancelled', responded_at = CURRENT_TIMESTAMP
+				WHERE group_id = ? AND to_user_id = ? AND status = 'pending'`, groupID, req.UserID)
+		}
+		if err != nil {
+			log.Printf("Error removing banned member %d from group %d: %v", req.UserID, groupID, err)
+			sendJSONError(w, "Failed to ban user", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Pending invitations and join requests go with the ban
+	_, err = tx.Exec(`
+		DELETE FROM group_invitations
+		WHERE group_id = ? AND invitee_id = ? AND status = 'pending'`, groupID, req.UserID)
+	if err == nil {
+		_, err = tx.Exec(`
+			DELETE FROM notifications
+			WHERE group_id = ? AND ((type = 'group_invitation' AND user_id = ?)
+				OR (type = 'group_join_request' AND from_user_id = ?))`, groupID, req.UserID, req.UserID)
+	}
+	if err != nil {
+		log.Printf("Error clearing invitations of banned user %d: %v", req.UserID, err)
+		sendJSONError(w, "Failed to ban user", http.StatusInternalServerError)
+		return
+	}
+
+	if err := restrictMember(tx, groupID, req.UserID, userID, policy.MemberBanned, req.Reason, expiresAt); err != nil {
+		log.Printf("Error banning user %d from group %d: %v", req.UserID, groupID, err)
+		sendJSONError(w, "Failed to ban user", http.StatusInternalServerError)
+		return
+	}
+	if err := notifyRestriction(tx, groupID, req.UserID, userID, "group_ban", "banned from", req.Reason, expiresAt); err != nil {
+		log.Printf("Error notifying banned user: %v", err)
+		sendJSONError(w, "Failed to ban user", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to ban user", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":       "User banned",
+		"userId":        req.UserID,
+		"removedMember": targetRole.IsMember(),
+	}
+	if expiresAt != nil {
+		response["expiresAt"] = expiresAt.UTC()
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+// UnbanGroupMember lifts a ban, the user can join or be invited again
+func UnbanGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	bannedID, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		sendJSONError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapRemoveMember); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	result, err := sqlite.DB.Exec(`
+		DELETE FROM group_member_status
+		WHERE group_id = ? AND user_id = ? AND status = ?`,
+		groupID, bannedID, policy.MemberBanned)
+	if err != nil {
+		log.Printf("Error unbanning user %d from group %d: %v", bannedID, groupID, err)
+		sendJSONError(w, "Failed to unban user", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		sendJSONError(w, "User is not banned", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "User unbanned",
+		"userId":  bannedID,
+	})
+}
+
+// GetGroupBans lists the users banned from a group. Bans that expired are
+// lifted on the way.
+func GetGroupBans(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapRemoveMember); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	if err := clearExpiredRestrictions(sqlite.DB, groupID); err != nil {
+		log.Printf("Error clearing expired bans: %v", err)
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT gms.user_id, u.username, gms.reason, gms.actor_id, COALESCE(a.username, ''),
+			gms.expires_at, gms.created_at
+		FROM group_member_status gms
+		JOIN users u ON u.id = gms.user_id
+		LEFT JOIN users a ON a.id = gms.actor_id
+		WHERE gms.group_id = ? AND gms.status = ?
+		ORDER BY gms.created_at DESC, gms.user_id`, groupID, policy.MemberBanned)
+	if err != nil {
+		log.Printf("Error getting group bans: %v", err)
+		sendJSONError(w, "Failed to get bans", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	bans := []GroupBan{}
+	for rows.Next() {
+		var ban GroupBan
+		var actorID sql.NullInt64
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&ban.UserID, &ban.Username, &ban.Reason, &actorID, &ban.BannedBy,
+			&expiresAt, &ban.CreatedAt); err != nil {
+			log.Printf("Error scanning group ban: %v", err)
+			sendJSONError(w, "Failed to get bans", http.StatusInternalServerError)
+			return
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			ban.BannedByID = &id
+		}
+		if expiresAt.Valid {
+			ban.ExpiresAt = &expiresAt.Time
+		}
+		bans = append(bans, ban)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating group bans: %v", err)
+		sendJSONError(w, "Failed to get bans", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, bans)
+}
+
+// MuteGroupMember lets a member keep reading a group while they can't post,
+// comment or chat in it, as {"reason": "cool down", "duration": "24h"}
+func MuteGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	memberID, err := strconv.Atoi(r.PathValue("memberId"))
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	req, expiresAt, err := readModerationRequest(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	targetRole, err := requireModerator(tx, groupID, userID, memberID)
+	if err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+	if !targetRole.IsMember() {
+		sendJSONError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	if err := restrictMember(tx, groupID, memberID, userID, policy.MemberMuted, req.Reason, expiresAt); err != nil {
+		log.Printf("Error muting member %d in group %d: %v", memberID, groupID, err)
+		sendJSONError(w, "Failed to mute member", http.StatusInternalServerError)
+		return
+	}
+	if err := notifyRestriction(tx, groupID, memberID, userID, "group_mute", "muted in", req.Reason, expiresAt); err != nil {
+		log.Printf("Error notifying muted member: %v", err)
+		sendJSONError(w, "Failed to mute member", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to mute member", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":  "Member muted",
+		"memberId": memberID,
+	}
+	if expiresAt != nil {
+		response["expiresAt"] = expiresAt.UTC()
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+// UnmuteGroupMember lets a muted member post, comment and chat again
+func UnmuteGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	memberID, err := strconv.Atoi(r.PathValue("memberId"))
+	if err != nil {
+		sendJSONError(w, "Invalid member ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapRemoveMember); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	result, err := sqlite.DB.Exec(`
+		DELETE FROM group_member_status
+		WHERE group_id = ? AND user_id = ? AND status = ?`,
+		groupID, memberID, policy.MemberMuted)
+	if err != nil {
+		log.Printf("Error unmuting member %d in group %d: %v", memberID, groupID, err)
+		sendJSONError(w, "Failed to unmute member", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		sendJSONError(w, "Member is not muted", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":  "Member unmuted",
+		"memberId": memberID,
+	})
+}
@@ -0,0 +1,340 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// OwnershipTransfer is an offer from a group's creator to hand the group
+// over to one of its members
+type OwnershipTransfer struct {
+	ID           int       `json:"id"`
+	GroupID      int       `json:"groupId"`
+	FromUserID   int       `json:"fromUserId"`
+	FromUsername string    `json:"fromUsername"`
+	ToUserID     int       `json:"toUserId"`
+	ToUsername   string    `json:"toUsername"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+const ownershipTransferQuery = `
+	SELECT t.id, t.group_id, t.from_user_id, fu.username, t.to_user_id, tu.username, t.status, t.created_at
+	FROM group_ownership_transfers t
+	JOIN users fu ON fu.id = t.from_user_id
+	JOIN users tu ON tu.id = t.to_user_id`
+
+func scanOwnershipTransfer(row interface{ Scan(...interface{}) error }) (OwnershipTransfer, error) {
+	var t OwnershipTransfer
+	err := row.Scan(&t.ID, &t.GroupID, &t.FromUserID, &t.FromUsername, &t.ToUserID, &t.ToUsername, &t.Status, &t.CreatedAt)
+	return t, err
+}
+
+// transferOwnership makes toID the creator of groupID. The previous creator
+// stays on as an admin and pending offers are cancelled.
+func transferOwnership(tx sqlExecer, groupID, fromID, toID int) error {
+	_, err := tx.Exec(`UPDATE group_members SET role = 'admin' WHERE group_id = ? AND user_id = ?`, groupID, fromID)
+	if err != nil {
+		return fmt.Errorf("failed to demote previous creator: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE group_members SET role = 'creator' WHERE group_id = ? AND user_id = ?`, groupID, toID)
+	if err != nil {
+		return fmt.Errorf("failed to promote new creator: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE groups SET creator_id = ? WHERE id = ?`, toID, groupID)
+	if err != nil {
+		return fmt.Errorf("failed to update group creator: %w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE group_ownership_transfers SET status = 'cancelled', responded_at = CURRENT_TIMESTAMP
+		WHERE group_id = ? AND status = 'pending'`, groupID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel ownership offers: %w", err)
+	}
+	return nil
+}
+
+// handOverGroup gives groupID to the member next in line when its creator
+// leaves: the oldest admin, or the oldest moderator or member when there is
+// no admin. It returns the new creator.
+func handOverGroup(tx sqlExecer, groupID, creatorID int) (int, error) {
+	var successorID int
+	err := tx.QueryRow(`
+		SELECT user_id FROM group_members
+		WHERE group_id = ? AND user_id != ?
+		ORDER BY CASE role WHEN 'admin' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END, joined_at, user_id
+		LIMIT 1`, groupID, creatorID).Scan(&successorID)
+	if err == sql.ErrNoRows {
+		return 0, &statusError{http.StatusBadRequest, "You are the last member of this group, delete it instead"}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the next creator: %w", err)
+	}
+	return successorID, transferOwnership(tx, groupID, creatorID, successorID)
+}
+
+// notifyGroupAdmins notifies the admins and the creator of groupID, except
+// fromUserID who caused it
+func notifyGroupAdmins(tx sqlExecer, groupID, fromUserID int, kind, content string) error {
+	_, err := tx.Exec(`
+		INSERT INTO notifications (user_id, type, content, group_id, from_user_id, created_at)
+		SELECT user_id, ?, ?, group_id, ?, CURRENT_TIMESTAMP
+		FROM group_members
+		WHERE group_id = ? AND role IN ('admin', 'creator') AND user_id != ?`,
+		kind, content, fromUserID, groupID, fromUserID)
+	if err != nil {
+		return fmt.Errorf("failed to notify group admins: %w", err)
+	}
+	return nil
+}
+
+// OfferGroupOwnership lets the creator of a group offer it to one of its
+// members, as {"userId": 5}. Nothing changes until they accept. A new offer
+// replaces a pending one.
+func OfferGroupOwnership(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		UserID int `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.UserID == 0 {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if body.UserID == userID {
+		sendJSONError(w, "You already own this group", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Handing the group over isn't a permission, it's the creator's alone
+	role, err := policy.LoadGroupRole(tx, groupID, userID)
+	if err != nil {
+		log.Printf("Error loading group role: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if role != policy.RoleCreator {
+		sendJSONError(w, "Only the group creator can transfer ownership", http.StatusForbidden)
+		return
+	}
+
+	targetRole, err := policy.LoadGroupRole(tx, groupID, body.UserID)
+	if err != nil {
+		log.Printf("Error loading group role: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !targetRole.IsMember() {
+		sendJSONError(w, "Ownership can only be transferred to a member", http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE group_ownership_transfers SET status = 'cancelled', responded_at = CURRENT_TIMESTAMP
+		WHERE group_id = ? AND status = 'pending'`, groupID)
+	if err != nil {
+		sendJSONError(w, "Failed to offer ownership", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO group_ownership_transfers (group_id, from_user_id, to_user_id)
+		VALUES (?, ?, ?)`, groupID, userID, body.UserID)
+	if err != nil {
+		log.Printf("Error offering ownership of group %d: %v", groupID, err)
+		sendJSONError(w, "Failed to offer ownership", http.StatusInternalServerError)
+		return
+	}
+	transferID, _ := result.LastInsertId()
+
+	transfer, err := scanOwnershipTransfer(tx.QueryRow(ownershipTransferQuery+` WHERE t.id = ?`, transferID))
+	if err != nil {
+		sendJSONError(w, "Failed to offer ownership", http.StatusInternalServerError)
+		return
+	}
+
+	var groupTitle string
+	if err := tx.QueryRow(`SELECT title FROM groups WHERE id = ?`, groupID).Scan(&groupTitle); err != nil {
+		sendJSONError(w, "Failed to offer ownership", http.StatusInternalServerError)
+		return
+	}
+	_, err = tx.Exec(`
+		INSERT INTO notifications (user_id, type, content, group_id, from_user_id, created_at)
+		VALUES (?, 'group_ownership_offer', ?, ?, ?, CURRENT_TIMESTAMP)`,
+		body.UserID, fmt.Sprintf("%s wants to make you the owner of %s", transfer.FromUsername, groupTitle),
+		groupID, userID)
+	if err != nil {
+		log.Printf("Failed to create ownership offer notification: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to offer ownership", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, transfer)
+}
+
+// GetGroupOwnershipTransfer returns the pending ownership offer of a group
+// to its creator and to the member it was made to, null for anyone else
+func GetGroupOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	transfer, err := scanOwnershipTransfer(sqlite.DB.QueryRow(ownershipTransferQuery+`
+		WHERE t.group_id = ? AND t.status = 'pending' AND (t.from_user_id = ? OR t.to_user_id = ?)
+		ORDER BY t.id DESC LIMIT 1`, groupID, userID, userID))
+	if err == sql.ErrNoRows {
+		sendJSONResponse(w, http.StatusOK, map[string]interface{}{"transfer": nil})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading ownership transfer: %v", err)
+		sendJSONError(w, "Failed to load ownership transfer", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{"transfer": transfer})
+}
+
+// RespondToOwnershipTransfer answers a pending ownership offer. The member
+// it was made to can accept or decline it, the creator can cancel it.
+func RespondToOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	transferID, err := strconv.Atoi(r.PathValue("transferId"))
+	if err != nil {
+		sendJSONError(w, "Invalid transfer ID", http.StatusBadRequest)
+		return
+	}
+	action := r.PathValue("action")
+	statuses := map[string]string{"accept": "accepted", "decline": "declined", "cancel": "cancelled"}
+	status, ok := statuses[action]
+	if !ok {
+		sendJSONError(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	transfer, err := scanOwnershipTransfer(tx.QueryRow(ownershipTransferQuery+`
+		WHERE t.id = ? AND t.group_id = ? AND t.status = 'pending'`, transferID, groupID))
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Ownership transfer not found or already answered", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading ownership transfer %d: %v", transferID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if action == "cancel" && userID != transfer.FromUserID ||
+		action != "cancel" && userID != transfer.ToUserID {
+		sendJSONError(w, "You can't "+action+" this ownership transfer", http.StatusForbidden)
+		return
+	}
+
+	if action == "accept" {
+		// Roles may have changed since the offer was made
+		fromRole, err := policy.LoadGroupRole(tx, groupID, transfer.FromUserID)
+		if err != nil {
+			sendJSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		toRole, err := policy.LoadGroupRole(tx, groupID, transfer.ToUserID)
+		if err != nil {
+			sendJSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if fromRole != policy.RoleCreator || !toRole.IsMember() {
+			sendJSONError(w, "This ownership transfer is no longer valid", http.StatusConflict)
+			return
+		}
+		if err := transferOwnership(tx, groupID, transfer.FromUserID, transfer.ToUserID); err != nil {
+			log.Printf("Error transferring group %d: %v", groupID, err)
+			sendJSONError(w, "Failed to transfer ownership", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE group_ownership_transfers SET status = ?, responded_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, status, transferID)
+	if err != nil {
+		sendJSONError(w, "Failed to answer ownership transfer", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM notifications
+		WHERE type = 'group_ownership_offer' AND group_id = ? AND user_id = ?`,
+		groupID, transfer.ToUserID)
+	if err != nil {
+		log.Printf("Failed to delete ownership offer notification: %v", err)
+	}
+
+	if action != "cancel" {
+		_, err = tx.Exec(`
+			INSERT INTO notifications (user_id, type, content, group_id, from_user_id, created_at)
+			VALUES (?, 'group_ownership_response', ?, ?, ?, CURRENT_TIMESTAMP)`,
+			transfer.FromUserID, fmt.Sprintf("%s has %s your offer to own the group", transfer.ToUsername, status),
+			groupID, transfer.ToUserID)
+		if err != nil {
+			log.Printf("Failed to create ownership response notification: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to answer ownership transfer", http.StatusInternalServerError)
+		return
+	}
+
+	transfer.Status = status
+	sendJSONResponse(w, http.StatusOK, transfer)
+}
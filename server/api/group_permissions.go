@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// GetGroupPermissions returns what every role can do in a group, the
+// overrides the group made to the defaults and what the user of the session
+// can do
+func GetGroupPermissions(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if err := requireGroupReader(groupID, userID); err != nil {
+		writeStatusError(w, err, "Failed to load permissions")
+		return
+	}
+
+	role, err := policy.LoadGroupRole(sqlite.DB, groupID, userID)
+	if err != nil {
+		log.Printf("Error loading group role: %v", err)
+		sendJSONError(w, "Failed to load permissions", http.StatusInternalServerError)
+		return
+	}
+	overrides, err := policy.LoadGroupPermissions(sqlite.DB, groupID)
+	if err != nil {
+		log.Printf("Error loading group permissions: %v", err)
+		sendJSONError(w, "Failed to load permissions", http.StatusInternalServerError)
+		return
+	}
+
+	effective := overrides.Effective()
+	capabilities := []policy.Capability{}
+	if role.IsMember() {
+		capabilities = effective[role]
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"role":         role,
+		"capabilities": capabilities,
+		"roles":        effective,
+		"overrides":    overrides,
+	})
+}
+
+// UpdateGroupPermissions grants or takes away capabilities from roles of a
+// group, as {"permissions": {"member": {"post": false}}}. Members with the
+// manage_roles permission change the roles below their own. Setting a
+// capability back to its default removes the override.
+func UpdateGroupPermissions(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Permissions map[string]map[string]bool `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Permissions) == 0 {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	editorRole, err := requireGroupCapability(tx, groupID, userID, policy.CapManageRoles)
+	if err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	for roleName, capabilities := range body.Permissions {
+		role, err := policy.ParseGroupRole(roleName)
+		if err != nil {
+			sendJSONError(w, "Invalid role "+strconv.Quote(roleName), http.StatusBadRequest)
+			return
+		}
+		if !policy.CanOverride(editorRole, role) {
+			sendJSONError(w, "You can't change the permissions of "+roleName+"s", http.StatusForbidden)
+			return
+		}
+
+		for name, allowed := range capabilities {
+			capability, err := policy.ParseCapability(name)
+			if err != nil {
+				sendJSONError(w, "Invalid capability "+strconv.Quote(name), http.StatusBadRequest)
+				return
+			}
+
+			if allowed == policy.IsDefault(role, capability) {
+				_, err = tx.Exec(`
+					DELETE FROM group_role_permissions
+					WHERE group_id = ? AND role = ? AND capability = ?`,
+					groupID, role, capability)
+			} else {
+				_, err = tx.Exec(`
+					INSERT INTO group_role_permissions (group_id, role, capability, allowed, updated_by)
+					VALUES (?, ?, ?, ?, ?)
+					ON CONFLICT(group_id, role, capability) DO UPDATE SET
+						allowed = excluded.allowed,
+						updated_by = excluded.updated_by,
+						updated_at = CURRENT_TIMESTAMP`,
+					groupID, role, capability, allowed, userID)
+			}
+			if err != nil {
+				log.Printf("Error saving group %d permission: %v", groupID, err)
+				sendJSONError(w, "Failed to update permissions", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	overrides, err := policy.LoadGroupPermissions(tx, groupID)
+	if err != nil {
+		log.Printf("Error loading group permissions: %v", err)
+		sendJSONError(w, "Failed to update permissions", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to update permissions", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"roles":     overrides.Effective(),
+		"overrides": overrides,
+	})
+}
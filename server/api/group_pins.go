@@ -0,0 +1,274 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// activePin is the SQL condition of the group posts, aliased as p, that are
+// pinned and whose pin hasn't expired
+const activePin = "(p.pinned_at IS NOT NULL AND (p.pinned_until IS NULL OR p.pinned_until > CURRENT_TIMESTAMP))"
+
+// groupPostPath reads the group and post IDs of a request on a group post
+func groupPostPath(w http.ResponseWriter, r *http.Request) (groupID, postID int, ok bool) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	postID, err = strconv.Atoi(r.PathValue("postId"))
+	if err != nil {
+		sendJSONError(w, "Invalid post ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return groupID, postID, true
+}
+
+// loadPublishedGroupPost returns the title and author of a published post of
+// groupID, pending and rejected posts can't be pinned or announced
+func loadPublishedGroupPost(tx *sql.Tx, groupID, postID int) (title string, authorID int, err error) {
+	err = tx.QueryRow(`
+		SELECT title, author_id FROM group_posts
+		WHERE id = ? AND group_id = ? AND status = ?`,
+		postID, groupID, policy.PostPublished).Scan(&title, &authorID)
+	if err == sql.ErrNoRows {
+		return "", 0, &statusError{http.StatusNotFound, "Post not found"}
+	}
+	return title, authorID, err
+}
+
+// PinGroupPost keeps a post at the top of its group, until the optional
+// {"expiresAt": ...} or {"duration": "72h"}. Groups keep up to their
+// max_pinned_posts pinned at once, pinning again changes the expiry.
+func PinGroupPost(w http.ResponseWriter, r *http.Request) {
+	groupID, postID, ok := groupPostPath(w, r)
+	if !ok {
+		return
+	}
+
+	expiresAt, err := readExpiry(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := requireGroupCapability(tx, groupID, userID, policy.CapPinPost); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+	if _, _, err := loadPublishedGroupPost(tx, groupID, postID); err != nil {
+		writeStatusError(w, err, "Failed to pin post")
+		return
+	}
+
+	var pinned, maxPinned int
+	err = tx.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM group_posts p WHERE p.group_id = g.id AND p.id != ? AND `+activePin+`),
+			g.max_pinned_posts
+		FROM groups g WHERE g.id = ?`, postID, groupID).Scan(&pinned, &maxPinned)
+	if err != nil {
+		log.Printf("Error counting pinned posts of group %d: %v", groupID, err)
+		sendJSONError(w, "Failed to pin post", http.StatusInternalServerError)
+		return
+	}
+	if pinned >= maxPinned {
+		sendJSONError(w, fmt.Sprintf("This group can't have more than %d pinned posts", maxPinned), http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE group_posts SET pinned_at = CURRENT_TIMESTAMP, pinned_until = ?, pinned_by = ?
+		WHERE id = ?`, sqlExpiry(expiresAt), userID, postID)
+	if err != nil {
+		log.Printf("Error pinning post %d: %v", postID, err)
+		sendJSONError(w, "Failed to pin post", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to pin post", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Post pinned",
+		"id":      postID,
+	}
+	if expiresAt != nil {
+		response["pinnedUntil"] = expiresAt.UTC()
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+// UnpinGroupPost puts a pinned post back in its place
+func UnpinGroupPost(w http.ResponseWriter, r *http.Request) {
+	groupID, postID, ok := groupPostPath(w, r)
+	if !ok {
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapPinPost); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	result, err := sqlite.DB.Exec(`
+		UPDATE group_posts SET pinned_at = NULL, pinned_until = NULL, pinned_by = NULL
+		WHERE id = ? AND group_id = ? AND pinned_at IS NOT NULL`, postID, groupID)
+	if err != nil {
+		log.Printf("Error unpinning post %d: %v", postID, err)
+		sendJSONError(w, "Failed to unpin post", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		sendJSONError(w, "Post is not pinned", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Post unpinned",
+		"id":      postID,
+	})
+}
+
+// AnnounceGroupPost marks a post as an announcement and notifies every
+// member of its group, over the WebSocket for those who are online. A post
+// is announced once.
+func AnnounceGroupPost(w http.ResponseWriter, r *http.Request) {
+	groupID, postID, ok := groupPostPath(w, r)
+	if !ok {
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := requireGroupCapability(tx, groupID, userID, policy.CapPinPost); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+	title, _, err := loadPublishedGroupPost(tx, groupID, postID)
+	if err != nil {
+		writeStatusError(w, err, "Failed to announce post")
+		return
+	}
+
+	result, err := tx.Exec(`
+		UPDATE group_posts SET is_announcement = 1, announced_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND is_announcement = 0`, postID)
+	if err != nil {
+		log.Printf("Error announcing post %d: %v", postID, err)
+		sendJSONError(w, "Failed to announce post", http.StatusInternalServerError)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		sendJSONError(w, "Post is already an announcement", http.StatusBadRequest)
+		return
+	}
+
+	var groupTitle string
+	if err := tx.QueryRow(`SELECT title FROM groups WHERE id = ?`, groupID).Scan(&groupTitle); err != nil {
+		sendJSONError(w, "Failed to announce post", http.StatusInternalServerError)
+		return
+	}
+
+	// Every member gets a notification of their own, written in one
+	// statement. The stored dates come back for the WebSocket.
+	type announcement struct {
+		id        int64
+		createdAt time.Time
+	}
+	content := fmt.Sprintf("Announcement in %s: %s", groupTitle, title)
+	rows, err := tx.Query(`
+		INSERT INTO notifications (user_id, type, content, group_id, from_user_id, created_at)
+		SELECT user_id, 'group_announcement', ?, group_id, ?, CURRENT_TIMESTAMP
+		FROM group_members
+		WHERE group_id = ? AND user_id != ?
+		RETURNING id, user_id, created_at`,
+		content, userID, groupID, userID)
+	if err != nil {
+		log.Printf("Error notifying members of group %d of announcement: %v", groupID, err)
+		sendJSONError(w, "Failed to announce post", http.StatusInternalServerError)
+		return
+	}
+	notifications := make(map[int]announcement)
+	for rows.Next() {
+		var memberID int
+		var n announcement
+		if err := rows.Scan(&n.id, &memberID, &n.createdAt); err != nil {
+			rows.Close()
+			log.Printf("Error reading announcement notification: %v", err)
+			sendJSONError(w, "Failed to announce post", http.StatusInternalServerError)
+			return
+		}
+		notifications[memberID] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error notifying members of group %d of announcement: %v", groupID, err)
+		sendJSONError(w, "Failed to announce post", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to announce post", http.StatusInternalServerError)
+		return
+	}
+
+	for memberID, n := range notifications {
+		broadcast <- models.BroadcastMessage{
+			Data: models.WebSocketMessage{Type: "notification", Data: map[string]interface{}{
+				"id":         n.id,
+				"type":       "group_announcement",
+				"content":    content,
+				"userId":     memberID,
+				"fromUserId": userID,
+				"groupId":    groupID,
+				"postId":     postID,
+				"isRead":     false,
+				"createdAt":  n.createdAt.Format(time.RFC3339),
+			}},
+			TargetUsers: mapIntSliceToMap([]int{memberID}),
+		}
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":  "Post announced",
+		"id":       postID,
+		"notified": len(notifications),
+	})
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/media"
+	"social-network/pkg/policy"
+)
+
+// groupPostStatus decides whether a new post of userID in groupID is
+// published right away or waits in the approval queue. Members who can
+// delete content moderate the queue and never wait in it.
+func groupPostStatus(q policy.GroupQuerier, groupID, userID int) (policy.PostStatus, error) {
+	moderator, _, err := policy.UserHasCapability(q, groupID, userID, policy.CapDeleteContent)
+	if err != nil || moderator {
+		return policy.PostPublished, err
+	}
+
+	var approval string
+	var days int
+	var joinedAt time.Time
+	err = q.QueryRow(`
+		SELECT g.post_approval, g.post_approval_days, gm.joined_at
+		FROM groups g
+		JOIN group_members gm ON gm.group_id = g.id AND gm.user_id = ?
+		WHERE g.id = ?`, userID, groupID).Scan(&approval, &days, &joinedAt)
+	if err != nil {
+		return policy.PostPublished, fmt.Errorf("failed to load post approval: %w", err)
+	}
+	setting, err := policy.ParsePostApproval(approval)
+	if err != nil {
+		return policy.PostPublished, err
+	}
+	if policy.NeedsApproval(setting, days, joinedAt, time.Now()) {
+		return policy.PostPending, nil
+	}
+	return policy.PostPublished, nil
+}
+
+// groupPostMentions lists the users indexed as mentioned in a group post
+func groupPostMentions(q sqlExecer, postID int) ([]int, error) {
+	rows, err := q.Query(`
+		SELECT mentioned_user_id FROM content_mentions
+		WHERE content_type = ? AND content_id = ?`, contentGroupPost, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentioned []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		mentioned = append(mentioned, id)
+	}
+	return mentioned, rows.Err()
+}
+
+// groupModeratorRoles lists the roles that can delete content in groupID,
+// given its overrides
+func groupModeratorRoles(q policy.GroupQuerier, groupID int) ([]interface{}, error) {
+	overrides, err := policy.LoadGroupPermissions(q, groupID)
+	if err != nil {
+		return nil, err
+	}
+	var roles []interface{}
+	for _, role := range policy.GroupRoles {
+		if policy.HasCapability(role, policy.CapDeleteContent, overrides) {
+			roles = append(roles, string(role))
+		}
+	}
+	return roles, nil
+}
+
+// notifyGroupModerators notifies the members who moderate the posts of
+// groupID, except fromUserID who caused it
+func notifyGroupModerators(tx *sql.Tx, groupID, fromUserID int, kind, content string) error {
+	roles, err := groupModeratorRoles(tx, groupID)
+	if err != nil {
+		return err
+	}
+	args := append([]interface{}{kind, content, fromUserID, groupID, fromUserID}, roles...)
+	_, err = tx.Exec(`
+		INSERT INTO notifications (user_id, type, content, group_id, from_user_id, created_at)
+		SELECT user_id, ?, ?, group_id, ?, CURRENT_TIMESTAMP
+		FROM group_members
+		WHERE group_id = ? AND user_id != ? AND role IN (`+placeholders(len(roles))+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to notify group moderators: %w", err)
+	}
+	return nil
+}
+
+// GetGroupPostQueue lists the posts of a group that wait for approval, the
+// oldest first
+func GetGroupPostQueue(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapDeleteContent); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT p.id, p.group_id, p.author_id, u.username, p.title, p.content, COALESCE(p.media, ''),
+			p.status, p.created_at, p.updated_at
+		FROM group_posts p
+		JOIN users u ON p.author_id = u.id
+		WHERE p.group_id = ? AND p.status = ?
+		ORDER BY p.created_at, p.id`, groupID, policy.PostPending)
+	if err != nil {
+		log.Printf("Error getting post queue: %v", err)
+		sendJSONError(w, "Failed to get pending posts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	posts := []m.GroupPost{}
+	for rows.Next() {
+		var post m.GroupPost
+		if err := rows.Scan(&post.ID, &post.GroupID, &post.AuthorID, &post.Author, &post.Title, &post.Content,
+			&post.Media, &post.Status, &post.CreatedAt, &post.UpdatedAt); err != nil {
+			log.Printf("Error scanning pending post: %v", err)
+			sendJSONError(w, "Failed to get pending posts", http.StatusInternalServerError)
+			return
+		}
+		if post.Media != "" {
+			post.MediaURL = media.SignedURL(media.CategoryGroupPost, post.Media)
+			post.Thumbnails = mediaThumbnails(string(media.CategoryGroupPost), post.Media)
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating post queue: %v", err)
+		sendJSONError(w, "Failed to get pending posts", http.StatusInternalServerError)
+		return
+	}
+
+	var batch entityBatch
+	for i := range posts {
+		batch.add(posts[i].Content, &posts[i].Entities)
+	}
+	batch.resolve()
+
+	sendJSONResponse(w, http.StatusOK, posts)
+}
+
+// ReviewGroupPost approves or rejects a pending post, with an optional
+// {"reason": "..."} that is required to reject. The author is notified of
+// the outcome.
+func ReviewGroupPost(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	postID, err := strconv.Atoi(r.PathValue("postId"))
+	if err != nil {
+		sendJSONError(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	var status policy.PostStatus
+	switch r.PathValue("action") {
+	case "approve":
+		status = policy.PostPublished
+	case "reject":
+		status = policy.PostRejected
+	default:
+		sendJSONError(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if status == policy.PostRejected && body.Reason == "" {
+		sendJSONError(w, "A reason is required to reject a post", http.StatusBadRequest)
+		return
+	}
+	if len(body.Reason) > maxModerationReason {
+		sendJSONError(w, fmt.Sprintf("Reason can't be longer than %d characters", maxModerationReason), http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := requireGroupCapability(tx, groupID, userID, policy.CapDeleteContent); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	var authorID int
+	var title, content, groupTitle string
+	err = tx.QueryRow(`
+		SELECT p.author_id, p.title, p.content, g.title
+		FROM group_posts p
+		JOIN groups g ON g.id = p.group_id
+		WHERE p.id = ? AND p.group_id = ? AND p.status = ?`,
+		postID, groupID, policy.PostPending).Scan(&authorID, &title, &content, &groupTitle)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Post not found or already reviewed", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading pending post %d: %v", postID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE group_posts
+		SET status = ?, reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP, review_reason = ?
+		WHERE id = ?`, status, userID, body.Reason, postID)
+	if err != nil {
+		log.Printf("Error reviewing post %d: %v", postID, err)
+		sendJSONError(w, "Failed to review post", http.StatusInternalServerError)
+		return
+	}
+
+	kind, notice := "group_post_approved", fmt.Sprintf("Your post %q in %s was approved", title, groupTitle)
+	if status == policy.PostRejected {
+		kind, notice = "group_post_rejected", fmt.Sprintf("Your post %q in %s was rejected", title, groupTitle)
+	}
+	if body.Reason != "" {
+		notice += ": " + body.Reason
+	}
+	_, err = tx.Exec(`
+		INSERT INTO notifications (user_id, type, content, group_id, from_user_id, created_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		authorID, kind, notice, groupID, userID)
+	if err != nil {
+		log.Printf("Error notifying post author: %v", err)
+		sendJSONError(w, "Failed to review post", http.StatusInternalServerError)
+		return
+	}
+
+	// Mentions were held back with the post, they go out once it is public
+	var mentioned []int
+	if status == policy.PostPublished {
+		mentioned, err = groupPostMentions(tx, postID)
+		if err != nil {
+			log.Printf("Error loading mentions of post %d: %v", postID, err)
+			sendJSONError(w, "Failed to review post", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to review post", http.StatusInternalServerError)
+		return
+	}
+
+	notifyMentions(mentioned, contentGroupPost, postID, authorID, groupID, content, groupAudience(groupID))
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Post reviewed",
+		"id":      postID,
+		"status":  status,
+	})
+}
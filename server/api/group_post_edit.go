@@ -0,0 +1,395 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/media"
+	"social-network/pkg/policy"
+)
+
+// groupPostMedia returns the media record attached to a group post, if any.
+// Posts from before the upload pipeline only have a filename, they get a
+// record without an ID so just the file is removed.
+func groupPostMedia(tx *sql.Tx, postID int) (*m.Media, error) {
+	var record m.Media
+	err := tx.QueryRow(`
+		SELECT COALESCE(md.id, 0), COALESCE(md.category, ?), COALESCE(md.filename, p.media)
+		FROM group_posts p
+		LEFT JOIN media md ON md.id = p.media_id
+		WHERE p.id = ? AND (md.id IS NOT NULL OR COALESCE(p.media, '') != '')`,
+		media.CategoryGroupPost, postID).Scan(&record.ID, &record.Category, &record.Filename)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// groupPostAudience lists the members of groupID who can see a post with the
+// given status and author: everyone for published posts, the author and the
+// moderators for the others
+func groupPostAudience(q policy.GroupQuerier, groupID, authorID int, status policy.PostStatus) ([]int, error) {
+	query := `SELECT user_id FROM group_members WHERE group_id = ?`
+	args := []interface{}{groupID}
+	if status != policy.PostPublished {
+		roles, err := groupModeratorRoles(q, groupID)
+		if err != nil {
+			return nil, err
+		}
+		query += ` AND role IN (` + placeholders(len(roles)) + `)`
+		args = append(args, roles...)
+	}
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load group members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []int{authorID}
+	for rows.Next() {
+		var memberID int
+		if err := rows.Scan(&memberID); err != nil {
+			return nil, err
+		}
+		if memberID != authorID {
+			members = append(members, memberID)
+		}
+	}
+	return members, rows.Err()
+}
+
+// broadcastGroupPostChange tells the online members who can see a post that
+// it, or one of its comments when commentID isn't 0, changed, so open
+// clients fetch it again
+func broadcastGroupPostChange(kind string, groupID, postID, commentID, authorID int, status policy.PostStatus) {
+	members, err := groupPostAudience(sqlite.DB, groupID, authorID, status)
+	if err != nil {
+		log.Printf("Error loading audience of post %d: %v", postID, err)
+		return
+	}
+	data := map[string]interface{}{
+		"groupId": groupID,
+		"postId":  postID,
+	}
+	if commentID != 0 {
+		data["commentId"] = commentID
+	}
+	SendNotification(members, m.WebSocketMessage{Type: kind, Data: data})
+}
+
+// UpdateGroupPost lets the author of a group post change its title and
+// content. It takes the same multipart form as CreateGroupPost: a new file in
+// "media" replaces the attachment and "remove_media=true" drops it. When the
+// group would hold a new post of the author for approval, the edited post
+// goes back to pending, the returned status says which.
+func UpdateGroupPost(w http.ResponseWriter, r *http.Request) {
+	if err := parseUploadForm(w, r); err != nil {
+		log.Printf("Error parsing multipart form: %v", err)
+		writeMediaError(w, err)
+		return
+	}
+
+	groupID, postID, ok := groupPostPath(w, r)
+	if !ok {
+		return
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	content := strings.TrimSpace(r.FormValue("content"))
+	if title == "" || content == "" {
+		sendJSONError(w, "Title and content are required", http.StatusBadRequest)
+		return
+	}
+	removeMedia := r.FormValue("remove_media") == "true"
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if _, err := requireGroupCapability(sqlite.DB, groupID, userID, policy.CapPost); err != nil {
+		writeStatusError(w, err, "Failed to verify permissions")
+		return
+	}
+
+	upload, err := saveUploadedMedia(r, "media", media.CategoryGroupPost, userID)
+	if err != nil {
+		log.Printf("Error storing group post media: %v", err)
+		writeMediaError(w, err)
+		return
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			discardMedia(upload)
+		}
+	}()
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	var status policy.PostStatus
+	err = tx.QueryRow(`
+		SELECT author_id, status FROM group_posts
+		WHERE id = ? AND group_id = ?`, postID, groupID).Scan(&authorID, &status)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching group post %d: %v", postID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if authorID != userID {
+		sendJSONError(w, "You can only edit your own posts", http.StatusForbidden)
+		return
+	}
+
+	previous, err := groupPostMedia(tx, postID)
+	if err != nil {
+		log.Printf("Error fetching media of group post %d: %v", postID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// The attachment stays unless it is replaced or removed
+	var replaced *m.Media
+	if upload != nil || removeMedia {
+		var mediaPath string
+		if upload != nil {
+			mediaPath = upload.Filename
+		}
+		_, err = tx.Exec(`UPDATE group_posts SET media = ?, media_id = ? WHERE id = ?`,
+			mediaPath, mediaID(upload), postID)
+		if err != nil {
+			log.Printf("Error updating media of group post %d: %v", postID, err)
+			sendJSONError(w, "Failed to update post", http.StatusInternalServerError)
+			return
+		}
+		replaced = previous
+	}
+
+	_, err = tx.Exec(`
+		UPDATE group_posts SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, title, content, postID)
+	if err != nil {
+		log.Printf("Error updating group post %d: %v", postID, err)
+		sendJSONError(w, "Failed to update post", http.StatusInternalServerError)
+		return
+	}
+
+	mentioned, err := indexEntities(tx, contentGroupPost, postID, userID, content)
+	if err != nil {
+		log.Printf("Error indexing post: %v", err)
+		sendJSONError(w, "Failed to update post", http.StatusInternalServerError)
+		return
+	}
+
+	// An edit is reviewed like a new post would be, so an approved post
+	// can't be changed behind the moderators' back. Editing a rejected post
+	// resubmits it, to the queue or straight to the group.
+	previousStatus := status
+	status, err = groupPostStatus(tx, groupID, userID)
+	if err != nil {
+		log.Printf("Error checking post approval: %v", err)
+		sendJSONError(w, "Failed to update post", http.StatusInternalServerError)
+		return
+	}
+	if status != previousStatus || status == policy.PostPending {
+		_, err = tx.Exec(`
+			UPDATE group_posts
+			SET status = ?, reviewed_by = NULL, reviewed_at = NULL, review_reason = ''
+			WHERE id = ?`, status, postID)
+		if err == nil && status == policy.PostPending {
+			var username string
+			err = tx.QueryRow(`SELECT username FROM users WHERE id = ?`, userID).Scan(&username)
+			if err == nil {
+				err = notifyGroupModerators(tx, groupID, userID, "group_post_pending",
+					fmt.Sprintf("%s edited %q and it is waiting for approval", username, title))
+			}
+		}
+		// Mentions held back with the post go out now that it is public
+		if err == nil && status == policy.PostPublished {
+			mentioned, err = groupPostMentions(tx, postID)
+		}
+		if err != nil {
+			log.Printf("Error resubmitting edited post %d: %v", postID, err)
+			sendJSONError(w, "Failed to update post", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to update post", http.StatusInternalServerError)
+		return
+	}
+	committed = true
+	discardMedia(replaced)
+
+	// Mentions of pending posts go out when they are approved
+	if status == policy.PostPublished {
+		notifyMentions(mentioned, contentGroupPost, postID, userID, groupID, content, groupAudience(groupID))
+	}
+	// Members who saw the post before it went back to the queue refresh too
+	audience := status
+	if previousStatus == policy.PostPublished {
+		audience = previousStatus
+	}
+	broadcastGroupPostChange("group_post_updated", groupID, postID, 0, authorID, audience)
+
+	var post m.GroupPost
+	var mediaName sql.NullString
+	err = sqlite.DB.QueryRow(`
+		SELECT p.id, p.group_id, p.author_id, u.username, p.title, p.content, p.media,
+			p.status, p.created_at, p.updated_at
+		FROM group_posts p
+		JOIN users u ON u.id = p.author_id
+		WHERE p.id = ?`, postID).Scan(&post.ID, &post.GroupID, &post.AuthorID, &post.Author, &post.Title,
+		&post.Content, &mediaName, &post.Status, &post.CreatedAt, &post.UpdatedAt)
+	if err != nil {
+		log.Printf("Error fetching updated post: %v", err)
+		sendJSONError(w, "Failed to fetch updated post", http.StatusInternalServerError)
+		return
+	}
+	if mediaName.String != "" {
+		post.Media = mediaName.String
+		post.MediaURL = media.SignedURL(media.CategoryGroupPost, post.Media)
+		post.Thumbnails = mediaThumbnails(string(media.CategoryGroupPost), post.Media)
+	}
+	post.Entities = resolveEntities(post.Content)
+
+	sendJSONResponse(w, http.StatusOK, post)
+}
+
+// DeleteGroupPost removes a group post with its comments, for its author and
+// the members who can delete content. The files attached to the post and its
+// comments are removed once the deletion is committed.
+func DeleteGroupPost(w http.ResponseWriter, r *http.Request) {
+	groupID, postID, ok := groupPostPath(w, r)
+	if !ok {
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	canDelete, _, err := policy.UserHasCapability(sqlite.DB, groupID, userID, policy.CapDeleteContent)
+	if err != nil {
+		log.Printf("Error checking group permissions: %v", err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	var status policy.PostStatus
+	err = tx.QueryRow(`
+		SELECT author_id, status FROM group_posts
+		WHERE id = ? AND group_id = ?`, postID, groupID).Scan(&authorID, &status)
+	if err == sql.ErrNoRows {
+		sendJSONError(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching group post %d: %v", postID, err)
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if userID != authorID && !canDelete {
+		sendJSONError(w, "You don't have permission to delete this post", http.StatusForbidden)
+		return
+	}
+
+	// Collect the attachments before the rows pointing at them are gone
+	var attachments []*m.Media
+	rows, err := tx.Query(`
+		SELECT md.id, md.category, md.filename
+		FROM media md
+		JOIN group_post_comments c ON c.media_id = md.id
+		WHERE c.post_id = ?`, postID)
+	if err != nil {
+		log.Printf("Error fetching comment media of post %d: %v", postID, err)
+		sendJSONError(w, "Failed to delete post", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var record m.Media
+		if err := rows.Scan(&record.ID, &record.Category, &record.Filename); err == nil {
+			attachments = append(attachments, &record)
+		}
+	}
+	rows.Close()
+
+	attachment, err := groupPostMedia(tx, postID)
+	if err != nil {
+		log.Printf("Error fetching media of group post %d: %v", postID, err)
+		sendJSONError(w, "Failed to delete post", http.StatusInternalServerError)
+		return
+	}
+	if attachment != nil {
+		attachments = append(attachments, attachment)
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM content_hashtags
+		WHERE content_type = ? AND content_id IN (SELECT id FROM group_post_comments WHERE post_id = ?)`,
+		contentGroupComment, postID)
+	if err == nil {
+		_, err = tx.Exec(`
+			DELETE FROM content_mentions
+			WHERE content_type = ? AND content_id IN (SELECT id FROM group_post_comments WHERE post_id = ?)`,
+			contentGroupComment, postID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM group_post_comments WHERE post_id = ?`, postID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM group_posts WHERE id = ?`, postID)
+	}
+	if err == nil {
+		err = removeEntities(tx, contentGroupPost, postID)
+	}
+	if err != nil {
+		log.Printf("Error deleting group post %d: %v", postID, err)
+		sendJSONError(w, "Failed to delete post", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to delete post", http.StatusInternalServerError)
+		return
+	}
+	for _, record := range attachments {
+		discardMedia(record)
+	}
+
+	broadcastGroupPostChange("group_post_deleted", groupID, postID, 0, authorID, status)
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Post deleted successfully",
+		"id":      postID,
+	})
+}
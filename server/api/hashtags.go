@@ -0,0 +1,473 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/entities"
+	"social-network/pkg/policy"
+)
+
+// Kinds of content hashtags and mentions are indexed for, as stored in the
+// content_type column of content_hashtags and content_mentions
+const (
+	contentPost         = "post"
+	contentComment      = "comment"
+	contentGroupPost    = "group_post"
+	contentGroupComment = "group_comment"
+	contentChatMessage  = "chat_message"
+)
+
+const (
+	defaultHashtagPostsLimit = 20
+	maxHashtagPostsLimit     = 100
+	defaultTrendingLimit     = 10
+	maxTrendingLimit         = 50
+	defaultTrendingWindow    = 24 * time.Hour
+	maxTrendingWindow        = 30 * 24 * time.Hour
+)
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// placeholders returns n comma separated SQL placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// lookupUsernames maps the given usernames to the IDs of the users that
+// exist
+func lookupUsernames(q sqlExecer, usernames []string) (map[string]int, error) {
+	ids := make(map[string]int, len(usernames))
+	if len(usernames) == 0 {
+		return ids, nil
+	}
+	args := make([]interface{}, len(usernames))
+	for i, name := range usernames {
+		args[i] = name
+	}
+	rows, err := q.Query("SELECT id, username FROM users WHERE username IN ("+placeholders(len(usernames))+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return nil, err
+		}
+		ids[username] = id
+	}
+	return ids, rows.Err()
+}
+
+// indexEntities makes the hashtags and mentions indexed for a piece of
+// content match its text. Entries that were already indexed keep their date,
+// so editing doesn't bump a tag in the trending list. It returns the users
+// mentioned for the first time, who are the ones to notify.
+func indexEntities(db sqlExecer, kind string, contentID, authorID int, text string) ([]int, error) {
+	found := entities.Parse(text)
+
+	tags := entities.Hashtags(found)
+	tagArgs := []interface{}{kind, contentID}
+	for _, tag := range tags {
+		tagArgs = append(tagArgs, tag)
+	}
+	_, err := db.Exec(`
+		DELETE FROM content_hashtags
+		WHERE content_type = ? AND content_id = ? AND tag NOT IN (`+placeholders(len(tags))+`)`, tagArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear hashtags: %w", err)
+	}
+	for _, tag := range tags {
+		_, err := db.Exec(`
+			INSERT OR IGNORE INTO content_hashtags (content_type, content_id, tag, author_id)
+			VALUES (?, ?, ?, ?)`, kind, contentID, tag, authorID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index hashtag: %w", err)
+		}
+	}
+
+	ids, err := lookupUsernames(db, entities.Usernames(found))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up mentions: %w", err)
+	}
+
+	previous := make(map[int]bool)
+	rows, err := db.Query(`
+		SELECT mentioned_user_id FROM content_mentions
+		WHERE content_type = ? AND content_id = ?`, kind, contentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mentions: %w", err)
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		previous[id] = true
+	}
+	rows.Close()
+
+	mentionArgs := []interface{}{kind, contentID}
+	var mentioned []int
+	for _, name := range entities.Usernames(found) {
+		id, ok := ids[name]
+		// Mentioning yourself is nothing to keep track of
+		if !ok || id == authorID {
+			continue
+		}
+		mentionArgs = append(mentionArgs, id)
+		if !previous[id] {
+			mentioned = append(mentioned, id)
+		}
+	}
+	_, err = db.Exec(`
+		DELETE FROM content_mentions
+		WHERE content_type = ? AND content_id = ? AND mentioned_user_id NOT IN (`+placeholders(len(mentionArgs)-2)+`)`,
+		mentionArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear mentions: %w", err)
+	}
+	for _, id := range mentionArgs[2:] {
+		_, err := db.Exec(`
+			INSERT OR IGNORE INTO content_mentions (content_type, content_id, mentioned_user_id, author_id)
+			VALUES (?, ?, ?, ?)`, kind, contentID, id, authorID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index mention: %w", err)
+		}
+	}
+
+	return mentioned, nil
+}
+
+// removeEntities drops the hashtags and mentions of deleted content
+func removeEntities(db sqlExecer, kind string, contentID int) error {
+	if _, err := db.Exec("DELETE FROM content_hashtags WHERE content_type = ? AND content_id = ?", kind, contentID); err != nil {
+		return fmt.Errorf("failed to remove hashtags: %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM content_mentions WHERE content_type = ? AND content_id = ?", kind, contentID); err != nil {
+		return fmt.Errorf("failed to remove mentions: %w", err)
+	}
+	return nil
+}
+
+// resolveEntities parses a text for a response. Mentions get the ID of the
+// user they name, mentions of unknown usernames are left out.
+func resolveEntities(text string) []entities.Entity {
+	var found []entities.Entity
+	var batch entityBatch
+	batch.add(text, &found)
+	batch.resolve()
+	return found
+}
+
+// entityBatch resolves the entities of a page of content, like
+// resolveEntities, looking up the users mentioned anywhere on the page with
+// one query
+type entityBatch struct {
+	texts []string
+	dests []*[]entities.Entity
+}
+
+// add queues a text, resolve writes its entities to dest. dest has to stay
+// where it is until then, so items are added once the slice holding them is
+// complete.
+func (b *entityBatch) add(text string, dest *[]entities.Entity) {
+	b.texts = append(b.texts, text)
+	b.dests = append(b.dests, dest)
+}
+
+func (b *entityBatch) resolve() {
+	found := make([][]entities.Entity, len(b.texts))
+	var usernames []string
+	seen := make(map[string]bool)
+	for i, text := range b.texts {
+		found[i] = entities.Parse(text)
+		for _, name := range entities.Usernames(found[i]) {
+			if !seen[name] {
+				seen[name] = true
+				usernames = append(usernames, name)
+			}
+		}
+	}
+
+	ids, err := lookupUsernames(sqlite.DB, usernames)
+	if err != nil {
+		log.Printf("Error resolving mentions: %v", err)
+		ids = nil
+	}
+	for i, entityList := range found {
+		resolved := entityList[:0]
+		for _, e := range entityList {
+			if e.Type == entities.TypeMention {
+				id, ok := ids[e.Text]
+				if !ok {
+					continue
+				}
+				e.UserID = id
+			}
+			resolved = append(resolved, e)
+		}
+		*b.dests[i] = resolved
+	}
+	b.texts, b.dests = nil, nil
+}
+
+// contentAudience reports whether a user can see a piece of content, mentions
+// of users who can't are not notified
+type contentAudience func(userID int) (bool, error)
+
+func postAudience(postID int) contentAudience {
+	return func(userID int) (bool, error) {
+		return policy.CanUserViewPost(sqlite.DB, postID, userID)
+	}
+}
+
+func groupAudience(groupID int) contentAudience {
+	return func(userID int) (bool, error) {
+		access, err := policy.LoadGroupAccess(sqlite.DB, groupID, userID)
+		if err != nil {
+			return false, err
+		}
+		return policy.CanReadGroup(access), nil
+	}
+}
+
+// chatAudience lets in the participants of a direct chat and the members of
+// the group a group chat belongs to
+func chatAudience(chatID int) contentAudience {
+	return func(userID int) (bool, error) {
+		var participant bool
+		err := sqlite.DB.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM user_chat_status WHERE chat_id = ? AND user_id = ?)
+				OR EXISTS(
+					SELECT 1 FROM groups g
+					JOIN group_members gm ON gm.group_id = g.id
+					WHERE g.chat_id = ? AND gm.user_id = ?
+				)`, chatID, userID, chatID, userID).Scan(&participant)
+		return participant, err
+	}
+}
+
+// notifyMentions notifies the mentioned users that can see the content they
+// were mentioned in. groupID is 0 outside groups.
+func notifyMentions(mentioned []int, kind string, contentID, authorID, groupID int, text string, canSee contentAudience) {
+	for _, userID := range mentioned {
+		visible, err := canSee(userID)
+		if err != nil {
+			log.Printf("Error checking whether user %d sees a mention: %v", userID, err)
+			continue
+		}
+		if !visible {
+			continue
+		}
+		if err := CreateMentionNotification(userID, authorID, groupID, kind, contentID, text); err != nil {
+			log.Printf("Error notifying user %d about a mention: %v", userID, err)
+		}
+	}
+}
+
+// GetHashtagPosts returns a page of the posts tagged with a hashtag that the
+// user of the session can see, newest first. Posts of the groups they are a
+// member of are included.
+func GetHashtagPosts(w http.ResponseWriter, r *http.Request) {
+	tag := entities.NormalizeHashtag(r.PathValue("tag"))
+	if tag == "" {
+		sendJSONError(w, "Invalid hashtag", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePagination(r, defaultHashtagPostsLimit, maxHashtagPostsLimit)
+	if err != nil {
+		sendJSONError(w, "Invalid pagination", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	visible, visibleArgs := policy.VisiblePostsCondition("p", userID)
+	readable, readableArgs := policy.ReadableGroupCondition("gp.group_id", userID)
+	notBlocked, blockArgs := policy.NotBlockedCondition("gp.author_id", userID)
+	args := []interface{}{tag}
+	args = append(args, visibleArgs...)
+	args = append(args, tag)
+	args = append(args, readableArgs...)
+	args = append(args, blockArgs...)
+	args = append(args, limit, offset)
+	rows, err := sqlite.DB.Query(`
+		SELECT id, title, content, privacy, author, username, avatar, media_category, media_filename, created_at, group_id
+		FROM (
+			SELECT p.id, p.title, p.content, p.privacy, p.author, u.username, COALESCE(u.avatar, '') AS avatar,
+				COALESCE(md.category, '') AS media_category, COALESCE(md.filename, '') AS media_filename,
+				p.created_at, 0 AS group_id
+			FROM content_hashtags h
+			JOIN posts p ON p.id = h.content_id
+			JOIN users u ON u.id = p.author
+			LEFT JOIN media md ON md.id = p.media_id
+			WHERE h.content_type = 'post' AND h.tag = ? AND `+visible+`
+
+			UNION ALL
+
+			SELECT gp.id, gp.title, gp.content, 0, gp.author_id, u.username, COALESCE(u.avatar, ''),
+				CASE WHEN COALESCE(gp.media, '') != '' THEN 'group_posts' ELSE '' END, COALESCE(gp.media, ''),
+				gp.created_at, gp.group_id
+			FROM content_hashtags h
+			JOIN group_posts gp ON gp.id = h.content_id
+			JOIN users u ON u.id = gp.author_id
+			WHERE h.content_type = 'group_post' AND h.tag = ?
+				AND gp.status = 'published'
+				AND `+readable+`
+				AND `+notBlocked+`
+		)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		log.Printf("Error fetching posts for #%s: %v", tag, err)
+		sendJSONError(w, "Failed to fetch posts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	posts := []m.Post{}
+	for rows.Next() {
+		var post m.Post
+		var mediaCategory, mediaFilename string
+		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.Privacy, &post.Author,
+			&post.AuthorName, &post.AuthorAvatar, &mediaCategory, &mediaFilename, &post.CreatedAt, &post.GroupID); err != nil {
+			log.Printf("Error scanning hashtag post: %v", err)
+			sendJSONError(w, "Failed to fetch posts", http.StatusInternalServerError)
+			return
+		}
+		if url := mediaURL(mediaCategory, mediaFilename); url != "" {
+			post.Media = url
+			post.Thumbnails = mediaThumbnails(mediaCategory, mediaFilename)
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		sendJSONError(w, "Failed to fetch posts", http.StatusInternalServerError)
+		return
+	}
+
+	var batch entityBatch
+	for i := range posts {
+		batch.add(posts[i].Content, &posts[i].Entities)
+	}
+	batch.resolve()
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"tag":    tag,
+		"posts":  posts,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// TrendingHashtag is a hashtag and how much it was used in a time window
+type TrendingHashtag struct {
+	Tag     string `json:"tag"`
+	Uses    int    `json:"uses"`
+	Authors int    `json:"authors"`
+}
+
+// GetTrendingHashtags returns the hashtags used the most over a time window,
+// ?window=24h by default. Only content the user of the session can see is
+// counted, so private posts never make a tag trend for outsiders.
+func GetTrendingHashtags(w http.ResponseWriter, r *http.Request) {
+	window := defaultTrendingWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxTrendingWindow {
+			sendJSONError(w, "Invalid window, use a duration up to 720h", http.StatusBadRequest)
+			return
+		}
+		window = parsed
+	}
+
+	limit := defaultTrendingLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			sendJSONError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxTrendingLimit)
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	visiblePost, postArgs := policy.VisiblePostsCondition("p", userID)
+	visibleCommented, commentedArgs := policy.VisiblePostsCondition("cp", userID)
+	readable, readableArgs := policy.ReadableGroupCondition("COALESCE(gp.group_id, gcp.group_id)", userID)
+	since := time.Now().UTC().Add(-window).Format("2006-01-02 15:04:05")
+	args := []interface{}{since}
+	args = append(args, postArgs...)
+	args = append(args, commentedArgs...)
+	args = append(args, readableArgs...)
+	args = append(args, limit)
+	rows, err := sqlite.DB.Query(`
+		SELECT h.tag, COUNT(*) AS uses, COUNT(DISTINCT h.author_id) AS authors
+		FROM content_hashtags h
+		LEFT JOIN posts p ON h.content_type = 'post' AND p.id = h.content_id
+		LEFT JOIN comments c ON h.content_type = 'comment' AND c.id = h.content_id
+		LEFT JOIN posts cp ON cp.id = c.post_id
+		LEFT JOIN group_posts gp ON h.content_type = 'group_post' AND gp.id = h.content_id
+		LEFT JOIN group_post_comments gc ON h.content_type = 'group_comment' AND gc.id = h.content_id
+		LEFT JOIN group_posts gcp ON gcp.id = gc.post_id
+		WHERE h.created_at >= ?
+			AND h.content_type != 'chat_message'
+			AND (
+				(p.id IS NOT NULL AND `+visiblePost+`)
+				OR (cp.id IS NOT NULL AND `+visibleCommented+`)
+				OR (COALESCE(gp.group_id, gcp.group_id) IS NOT NULL AND COALESCE(gp.status, 'published') = 'published'
+					AND `+readable+`)
+			)
+		GROUP BY h.tag
+		ORDER BY uses DESC, authors DESC, h.tag
+		LIMIT ?`, args...)
+	if err != nil {
+		log.Printf("Error fetching trending hashtags: %v", err)
+		sendJSONError(w, "Failed to fetch trending hashtags", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	trending := []TrendingHashtag{}
+	for rows.Next() {
+		var t TrendingHashtag
+		if err := rows.Scan(&t.Tag, &t.Uses, &t.Authors); err != nil {
+			sendJSONError(w, "Failed to fetch trending hashtags", http.StatusInternalServerError)
+			return
+		}
+		trending = append(trending, t)
+	}
+	if err := rows.Err(); err != nil {
+		sendJSONError(w, "Failed to fetch trending hashtags", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"window":   window.String(),
+		"hashtags": trending,
+	})
+}
@@ -0,0 +1,498 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/media"
+	"social-network/pkg/policy"
+	"social-network/pkg/storage"
+	"social-network/util"
+)
+
+// maxUploadRequestSize leaves room for the other form fields next to the file
+const maxUploadRequestSize = media.MaxFileSize + 1<<20
+
+// isMultipart reports whether the request body is a multipart form
+func isMultipart(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+}
+
+// parseUploadForm limits the request body and parses it as a multipart form
+func parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadRequestSize)
+	return r.ParseMultipartForm(maxUploadRequestSize)
+}
+
+// saveUploadedMedia runs the file in the given form field through the upload
+// pipeline and records it. It returns nil when the field is empty.
+func saveUploadedMedia(r *http.Request, field string, category media.Category, ownerID int) (*m.Media, error) {
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		if err == http.ErrMissingFile {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	log.Printf("Received %s upload %q (%d bytes) from user %d", category, header.Filename, header.Size, ownerID)
+
+	stored, err := media.Save(category, file)
+	if err != nil {
+		return nil, err
+	}
+	return recordMedia(stored, ownerID)
+}
+
+// saveDataURLMedia stores an image sent inline as a base64 data URL, the
+// format used by JSON clients
+func saveDataURLMedia(dataURL string, category media.Category, ownerID int) (*m.Media, error) {
+	header, payload, found := strings.Cut(dataURL, ",")
+	if !found || !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") {
+		return nil, media.ErrInvalidImage
+	}
+
+	if base64.StdEncoding.DecodedLen(len(payload)) > media.MaxFileSize+2 {
+		return nil, media.ErrTooLarge
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, media.ErrInvalidImage
+	}
+
+	// The declared type in the header is ignored, the pipeline sniffs the content
+	stored, err := media.Save(category, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return recordMedia(stored, ownerID)
+}
+
+// recordMedia inserts the media row for a stored file. The file is removed
+// again if the row can't be written.
+func recordMedia(stored *media.File, ownerID int) (*m.Media, error) {
+	result, err := sqlite.DB.Exec(`
+		INSERT INTO media (owner_id, category, filename, mime_type, size, width, height)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		ownerID, stored.Category, stored.Name, stored.MimeType, stored.Size, stored.Width, stored.Height)
+	if err != nil {
+		media.Remove(stored.Category, stored.Name)
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		media.Remove(stored.Category, stored.Name)
+		return nil, err
+	}
+
+	return &m.Media{
+		ID:         int(id),
+		OwnerID:    ownerID,
+		Category:   string(stored.Category),
+		Filename:   stored.Name,
+		MimeType:   stored.MimeType,
+		Size:       stored.Size,
+		Width:      stored.Width,
+		Height:     stored.Height,
+		URL:        media.URL(stored.Category, stored.Name),
+		Thumbnails: mediaThumbnails(string(stored.Category), stored.Name),
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// discardMedia removes a media record and its file, used when the row that
+// was going to reference it couldn't be created. Records without an ID stand
+// for files stored before media rows existed, only the file is removed.
+func discardMedia(record *m.Media) {
+	if record == nil {
+		return
+	}
+	if record.ID != 0 {
+		if _, err := sqlite.DB.Exec("DELETE FROM media WHERE id = ?", record.ID); err != nil {
+			log.Printf("Failed to delete media record %d: %v", record.ID, err)
+		}
+	}
+	if err := media.Remove(media.Category(record.Category), record.Filename); err != nil {
+		log.Printf("Failed to remove media file %s: %v", record.Filename, err)
+	}
+}
+
+// mediaID returns the ID to store for an optional media record
+func mediaID(record *m.Media) interface{} {
+	if record == nil {
+		return nil
+	}
+	return record.ID
+}
+
+// mediaURL builds the signed URL for the category and filename selected from
+// a LEFT JOIN on media, or "" when there is no media. Callers have already
+// checked the user may see the row the media belongs to.
+func mediaURL(category, filename string) string {
+	if category == "" || filename == "" {
+		return ""
+	}
+	return media.SignedURL(media.Category(category), filename)
+}
+
+// chatMediaURL checks that a chat attachment was uploaded by the sender and
+// returns its URL
+func chatMediaURL(mediaID, senderID int) (string, error) {
+	var filename string
+	err := sqlite.DB.QueryRow(`
+		SELECT filename FROM media
+		WHERE id = ? AND owner_id = ? AND category = ?`,
+		mediaID, senderID, media.CategoryChat).Scan(&filename)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("attachment not found")
+		}
+		return "", fmt.Errorf("database error: %w", err)
+	}
+	return media.SignedURL(media.CategoryChat, filename), nil
+}
+
+// canUserViewMedia checks whether the user may see the post, comment, group
+// or chat a stored file, or one of its thumbnails, is attached to. Uploaders
+// can always see their files.
+func canUserViewMedia(userID int, category media.Category, filename string) (bool, error) {
+	// Thumbnails and poster frames share the stem of the original
+	stem := media.OriginalStem(filename) + "."
+	var mediaID, ownerID int
+	err := sqlite.DB.QueryRow(`
+		SELECT id, owner_id, filename FROM media
+		WHERE category = ? AND (filename = ? OR substr(filename, 1, ?) = ?)`,
+		category, filename, len(stem), stem).Scan(&mediaID, &ownerID, &filename)
+	if err == sql.ErrNoRows {
+		// Group post files uploaded before the media table only exist on the post
+		if category == media.CategoryGroupPost {
+			return canUserViewGroupMedia(userID, 0, filename)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if ownerID == userID {
+		return true, nil
+	}
+
+	switch category {
+	case media.CategoryAvatar:
+		return true, nil
+
+	case media.CategoryPost, media.CategoryComment:
+		var postID int
+		query := "SELECT id FROM posts WHERE media_id = ?"
+		if category == media.CategoryComment {
+			query = "SELECT post_id FROM comments WHERE media_id = ?"
+		}
+		err := sqlite.DB.QueryRow(query, mediaID).Scan(&postID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return canUserViewPost(postID, userID)
+
+	case media.CategoryGroupPost:
+		return canUserViewGroupMedia(userID, mediaID, filename)
+
+	case media.CategoryChat:
+		var isParticipant bool
+		err := sqlite.DB.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM chat_messages m
+				JOIN user_chat_status s ON s.chat_id = m.chat_id
+				WHERE m.media_id = ? AND s.user_id = ?
+			)`, mediaID, userID).Scan(&isParticipant)
+		return isParticipant, err
+	}
+
+	return false, nil
+}
+
+// canUserViewGroupMedia checks that a file attached to a group post or one
+// of its comments belongs to a group the user can read. Files of posts
+// waiting for approval are only opened by their author here, moderators get
+// signed URLs from the queue.
+func canUserViewGroupMedia(userID, mediaID int, filename string) (bool, error) {
+	readable, readableArgs := policy.ReadableGroupCondition("attached.group_id", userID)
+	var canRead bool
+	err := sqlite.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM (
+				SELECT group_id FROM group_posts
+				WHERE (media_id = ? OR media = ?) AND (status = 'published' OR author_id = ?)
+				UNION
+				SELECT gp.group_id FROM group_post_comments c
+				JOIN group_posts gp ON gp.id = c.post_id
+				WHERE c.media_id = ?
+			) attached
+			WHERE `+readable+`
+		)`, append([]interface{}{mediaID, filename, userID, mediaID}, readableArgs...)...).Scan(&canRead)
+	return canRead, err
+}
+
+// mediaThumbnails returns signed URLs for the thumbnails of a stored file,
+// keyed by size, plus the poster frame for GIFs
+func mediaThumbnails(category, filename string) map[string]string {
+	if category == "" || filename == "" {
+		return nil
+	}
+
+	c := media.Category(category)
+	thumbnails := make(map[string]string, len(media.ThumbnailSizes)+1)
+	for _, size := range media.ThumbnailSizes {
+		thumbnails[strconv.Itoa(size)] = media.SignedURL(c, media.ThumbnailName(filename, size))
+	}
+	if media.HasPoster(filename) {
+		thumbnails["poster"] = media.SignedURL(c, media.PosterName(filename))
+	}
+	return thumbnails
+}
+
+// avatarThumbnails returns the thumbnail URLs for an avatar stored through
+// the upload pipeline, avatars set any other way have none
+func avatarThumbnails(avatar string) map[string]string {
+	prefix := media.URL(media.CategoryAvatar, "")
+	if !strings.HasPrefix(avatar, prefix) {
+		return nil
+	}
+	return mediaThumbnails(string(media.CategoryAvatar), strings.TrimPrefix(avatar, prefix))
+}
+
+// writeMediaError sends the status code matching an upload pipeline error
+func writeMediaError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, media.ErrTooLarge), errors.As(err, &maxBytesErr):
+		sendJSONError(w, media.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, media.ErrUnsupportedType):
+		sendJSONError(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, media.ErrInvalidImage), errors.Is(err, media.ErrDimensions):
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error storing upload: %v", err)
+		sendJSONError(w, "Failed to store upload", http.StatusInternalServerError)
+	}
+}
+
+// UploadChatMedia stores an image attachment for a chat the user takes part
+// in. The returned media ID is then sent along with the WebSocket message.
+func UploadChatMedia(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	chatID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		sendJSONError(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	var isParticipant bool
+	err = sqlite.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_chat_status
+			WHERE chat_id = ? AND user_id = ?
+		)`, chatID, userID).Scan(&isParticipant)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		sendJSONError(w, "You are not a participant in this chat", http.StatusForbidden)
+		return
+	}
+
+	if err := parseUploadForm(w, r); err != nil {
+		writeMediaError(w, err)
+		return
+	}
+
+	record, err := saveUploadedMedia(r, "media", media.CategoryChat, userID)
+	if err != nil {
+		writeMediaError(w, err)
+		return
+	}
+	if record == nil {
+		sendJSONError(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, record)
+}
+
+// UploadAvatar replaces the current user's avatar with an uploaded image
+func UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	if err := parseUploadForm(w, r); err != nil {
+		writeMediaError(w, err)
+		return
+	}
+
+	record, err := saveUploadedMedia(r, "avatar", media.CategoryAvatar, userID)
+	if err != nil {
+		writeMediaError(w, err)
+		return
+	}
+	if record == nil {
+		sendJSONError(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+
+	_, err = sqlite.DB.Exec("UPDATE users SET avatar = ? WHERE id = ?", record.URL, userID)
+	if err != nil {
+		discardMedia(record)
+		sendJSONError(w, "Failed to update avatar", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"avatar":            record.URL,
+		"avatar_thumbnails": record.Thumbnails,
+		"media":             record,
+	})
+}
+
+// ServeMedia serves an uploaded file from one of the media categories
+func ServeMedia(w http.ResponseWriter, r *http.Request) {
+	category, err := media.ParseCategory(r.PathValue("category"))
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	serveMedia(w, r, category, r.PathValue("filename"))
+}
+
+// serveMedia checks access to a stored file and writes it to the response.
+// Requests either carry a signed URL, so images can be embedded without
+// cookies, or come from a session allowed to see what the file belongs to.
+func serveMedia(w http.ResponseWriter, r *http.Request, category media.Category, filename string) {
+	if filename == "" {
+		http.Error(w, "No filename provided", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Has("sig") {
+		expiry, ok := media.VerifySignature(media.URL(category, filename), query.Get("exp"), query.Get("sig"))
+		if !ok {
+			http.Error(w, "Invalid or expired link", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(time.Until(expiry).Seconds())))
+		serveUpload(w, r, category, filename)
+		return
+	}
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	err = sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err != nil {
+		http.Error(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := canUserViewMedia(userID, category, filename)
+	if err != nil {
+		log.Printf("Error checking media access for %s/%s: %v", category, filename, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		// Same response as a missing file so filenames can't be probed
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Access can be revoked, so cached copies have to be revalidated
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Cookie")
+	serveUpload(w, r, category, filename)
+}
+
+// serveUpload writes a stored file to the response
+func serveUpload(w http.ResponseWriter, r *http.Request, category media.Category, filename string) {
+	body, info, err := media.Store.Get(media.Key(category, filename))
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error reading %s/%s from storage: %v", category, filename, err)
+		}
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer body.Close()
+
+	// ServeContent needs to seek for range requests, remote objects are
+	// small enough to buffer
+	content, ok := body.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(io.LimitReader(body, media.MaxFileSize+1))
+		if err != nil {
+			log.Printf("Error reading %s/%s from storage: %v", category, filename, err)
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	// Stored files are never rewritten, the store's ETag identifies the
+	// content. ServeContent answers If-None-Match with a 304.
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+
+	// Stored files were sniffed on upload, never let the browser guess again
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, filename, info.ModTime, content)
+}
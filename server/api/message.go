@@ -127,11 +127,15 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 			m.id,
 			m.sender_id,
 			m.content,
+			COALESCE(m.message_type, 'text'),
+			COALESCE(md.category, ''),
+			COALESCE(md.filename, ''),
 			m.created_at,
 			u.username as sender_name,
 			u.avatar as sender_avatar
 		FROM chat_messages m
 		JOIN users u ON m.sender_id = u.id
+		LEFT JOIN media md ON md.id = m.media_id
 		WHERE m.chat_id = ?
 		ORDER BY m.created_at ASC
 	`, chatId)
@@ -145,10 +149,14 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 	var messages []models.ChatMessage
 	for rows.Next() {
 		var msg models.ChatMessage
+		var mediaCategory, mediaFilename string
 		if err := rows.Scan(
 			&msg.ID,
 			&msg.SenderID,
 			&msg.Content,
+			&msg.MessageType,
+			&mediaCategory,
+			&mediaFilename,
 			&msg.CreatedAt,
 			&msg.SenderName,
 			&msg.SenderAvatar,
@@ -166,10 +174,17 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 
 		// Set the chat ID
 		msg.ChatID = chatId
-
+		msg.MediaURL = mediaURL(mediaCategory, mediaFilename)
 		messages = append(messages, msg)
 	}
 
+	// Mentions are resolved for the whole page at once
+	var batch entityBatch
+	for i := range messages {
+		batch.add(messages[i].Content, &messages[i].Entities)
+	}
+	batch.resolve()
+
 	// Return messages with chat ID
 	response := map[string]interface{}{
 		"messages": messages,
@@ -266,6 +281,13 @@ func GetGroupMessages(w http.ResponseWriter, r *http.Request) {
 		messages = append(messages, msg)
 	}
 
+	// Mentions are resolved for the whole page at once
+	var batch entityBatch
+	for i := range messages {
+		batch.add(messages[i].Content, &messages[i].Entities)
+	}
+	batch.resolve()
+
 	if err := json.NewEncoder(w).Encode(messages); err != nil {
 		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 		return
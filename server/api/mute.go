@@ -0,0 +1,245 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/pkg/db/sqlite"
+)
+
+// Mute is an entry of the muted users and groups list
+type Mute struct {
+	Type      string     `json:"type"` // "user" or "group"
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Avatar    string     `json:"avatar,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	MutedAt   time.Time  `json:"mutedAt"`
+}
+
+// muteTarget is the column of user_mutes a mute is stored in
+type muteTarget struct {
+	kind   string
+	column string
+	table  string
+}
+
+var (
+	userMute  = muteTarget{kind: "user", column: "muted_user_id", table: "users"}
+	groupMute = muteTarget{kind: "group", column: "muted_group_id", table: "groups"}
+)
+
+// readExpiry reads the optional expiry of a mute or a pin. The body can be
+// empty, or hold either an expiresAt time or a duration such as "8h".
+func readExpiry(r *http.Request) (*time.Time, error) {
+	var req struct {
+		ExpiresAt *time.Time `json:"expiresAt"`
+		Duration  string     `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.New("Invalid request body")
+	}
+	return parseExpiry(req.ExpiresAt, req.Duration)
+}
+
+// parseExpiry checks an optional expiry given as either a time or a duration
+// from now. Neither means it never expires.
+func parseExpiry(expiresAt *time.Time, duration string) (*time.Time, error) {
+	switch {
+	case expiresAt != nil && duration != "":
+		return nil, errors.New("Use either expiresAt or duration")
+	case duration != "":
+		parsed, err := time.ParseDuration(duration)
+		if err != nil || parsed <= 0 {
+			return nil, errors.New("Invalid duration")
+		}
+		expires := time.Now().Add(parsed)
+		return &expires, nil
+	case expiresAt != nil:
+		if !expiresAt.After(time.Now()) {
+			return nil, errors.New("expiresAt must be in the future")
+		}
+		return expiresAt, nil
+	}
+	return nil, nil
+}
+
+// sqlExpiry formats an optional expiry like CURRENT_TIMESTAMP, so expiry
+// compares as text
+func sqlExpiry(expiresAt *time.Time) interface{} {
+	if expiresAt == nil {
+		return nil
+	}
+	return expiresAt.UTC().Format("2006-01-02 15:04:05")
+}
+
+// mute stores a mute of the user or group in the path for the user of the
+// session. Muting again replaces the expiry.
+func mute(w http.ResponseWriter, r *http.Request, target muteTarget, pathKey string) {
+	targetID, err := strconv.Atoi(r.PathValue(pathKey))
+	if err != nil {
+		sendJSONError(w, "Invalid "+target.kind+" ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+	if target == userMute && targetID == userID {
+		sendJSONError(w, "Cannot mute yourself", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt, err := readExpiry(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = sqlite.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM "+target.table+" WHERE id = ?)", targetID).Scan(&exists)
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		sendJSONError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = sqlite.DB.Exec(`
+		INSERT INTO user_mutes (user_id, `+target.column+`, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(user_id, `+target.column+`) DO UPDATE SET
+			expires_at = excluded.expires_at,
+			created_at = CURRENT_TIMESTAMP`,
+		userID, targetID, sqlExpiry(expiresAt))
+	if err != nil {
+		log.Printf("Error muting %s: %v", target.kind, err)
+		sendJSONError(w, "Failed to mute", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Muted",
+		"type":    target.kind,
+		"id":      targetID,
+	}
+	if expiresAt != nil {
+		response["expiresAt"] = expiresAt.UTC()
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+// unmute removes a mute of the user or group in the path
+func unmute(w http.ResponseWriter, r *http.Request, target muteTarget, pathKey string) {
+	targetID, err := strconv.Atoi(r.PathValue(pathKey))
+	if err != nil {
+		sendJSONError(w, "Invalid "+target.kind+" ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	result, err := sqlite.DB.Exec(`
+		DELETE FROM user_mutes
+		WHERE user_id = ? AND `+target.column+` = ?`, userID, targetID)
+	if err != nil {
+		log.Printf("Error unmuting %s: %v", target.kind, err)
+		sendJSONError(w, "Failed to unmute", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		sendJSONError(w, "Not muted", http.StatusNotFound)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Unmuted",
+		"type":    target.kind,
+		"id":      targetID,
+	})
+}
+
+// MuteUser hides the posts of a user and silences their chat notifications
+func MuteUser(w http.ResponseWriter, r *http.Request) {
+	mute(w, r, userMute, "userID")
+}
+
+// UnmuteUser removes a mute of a user
+func UnmuteUser(w http.ResponseWriter, r *http.Request) {
+	unmute(w, r, userMute, "userID")
+}
+
+// MuteGroup hides the posts of a group from the main feed and silences its
+// chat notifications
+func MuteGroup(w http.ResponseWriter, r *http.Request) {
+	mute(w, r, groupMute, "id")
+}
+
+// UnmuteGroup removes a mute of a group
+func UnmuteGroup(w http.ResponseWriter, r *http.Request) {
+	unmute(w, r, groupMute, "id")
+}
+
+// GetMutes lists the active mutes of the user of the session
+func GetMutes(w http.ResponseWriter, r *http.Request) {
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	rows, err := sqlite.DB.Query(`
+		SELECT
+			CASE WHEN um.muted_user_id IS NOT NULL THEN 'user' ELSE 'group' END,
+			COALESCE(um.muted_user_id, um.muted_group_id),
+			COALESCE(u.username, g.title, ''),
+			COALESCE(u.avatar, ''),
+			um.expires_at,
+			um.created_at
+		FROM user_mutes um
+		LEFT JOIN users u ON u.id = um.muted_user_id
+		LEFT JOIN groups g ON g.id = um.muted_group_id
+		WHERE um.user_id = ? AND (um.expires_at IS NULL OR um.expires_at > CURRENT_TIMESTAMP)
+		ORDER BY um.created_at DESC, um.id DESC`, userID)
+	if err != nil {
+		log.Printf("Error getting mutes: %v", err)
+		sendJSONError(w, "Failed to get mutes", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	mutes := []Mute{}
+	for rows.Next() {
+		var entry Mute
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&entry.Type, &entry.ID, &entry.Name, &entry.Avatar, &expiresAt, &entry.MutedAt); err != nil {
+			log.Printf("Error scanning mute: %v", err)
+			sendJSONError(w, "Failed to get mutes", http.StatusInternalServerError)
+			return
+		}
+		if expiresAt.Valid {
+			entry.ExpiresAt = &expiresAt.Time
+		}
+		mutes = append(mutes, entry)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating mutes: %v", err)
+		sendJSONError(w, "Failed to get mutes", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, mutes)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"social-network/models"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
 	"social-network/util"
 	"strconv"
 	"time"
@@ -46,6 +47,9 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Fetching notifications for user ID: %d", userID)
 
+	// Notifications from blocked users are hidden, including the ones sent
+	// before the block
+	notBlocked, blockArgs := policy.NotBlockedCondition("n.from_user_id", userID)
 	rows, err := sqlite.DB.Query(`
         SELECT 
             n.id,
@@ -69,9 +73,9 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
         FROM notifications n
         LEFT JOIN group_members gm ON n.group_id = gm.group_id AND gm.user_id = n.user_id
         LEFT JOIN group_invitations gi ON n.invitation_id = gi.id
-        WHERE n.user_id = ?
+        WHERE n.user_id = ? AND `+notBlocked+`
         ORDER BY n.created_at DESC`,
-		userID)
+		append([]interface{}{userID}, blockArgs...)...)
 
 	if err != nil {
 		log.Printf("Error fetching notifications: %v", err)
@@ -190,12 +194,13 @@ func MarkNotificationAsRead(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get updated unread count
+	notBlocked, blockArgs := policy.NotBlockedCondition("notifications.from_user_id", userID)
 	var unreadCount int
 	err = tx.QueryRow(`
         SELECT COUNT(*) 
         FROM notifications 
-        WHERE user_id = ? AND is_read = false`,
-		userID).Scan(&unreadCount)
+        WHERE user_id = ? AND is_read = false AND `+notBlocked,
+		append([]interface{}{userID}, blockArgs...)...).Scan(&unreadCount)
 
 	if err != nil {
 		sendJSONError(w, "Failed to get unread count", http.StatusInternalServerError)
@@ -216,6 +221,15 @@ func MarkNotificationAsRead(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateChatNotification(recipientID, senderID int, content string) error {
+	if blocked, err := policy.IsBlocked(sqlite.DB, recipientID, senderID); err != nil || blocked {
+		return err
+	}
+	// Messages from a muted user are still delivered, just without a
+	// notification
+	if muted, err := policy.HasMutedUser(sqlite.DB, recipientID, senderID); err != nil || muted {
+		return err
+	}
+
 	// Get sender info
 	var senderName, senderAvatar string
 	err := sqlite.DB.QueryRow(
@@ -295,3 +309,151 @@ func sendJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(data)
 }
+
+// CreateCommentReplyNotification tells the author of a comment that someone
+// replied to it. groupID is 0 for comments outside groups.
+func CreateCommentReplyNotification(recipientID, replierID, postID, groupID int, content string) error {
+	// Replying to yourself doesn't need a notification
+	if recipientID == replierID {
+		return nil
+	}
+	if blocked, err := policy.IsBlocked(sqlite.DB, recipientID, replierID); err != nil || blocked {
+		return err
+	}
+
+	var replierName, replierAvatar string
+	err := sqlite.DB.QueryRow(
+		"SELECT first_name || ' ' || last_name, avatar FROM users WHERE id = ?",
+		replierID).Scan(&replierName, &replierAvatar)
+	if err != nil {
+		return fmt.Errorf("error getting replier info: %w", err)
+	}
+
+	var group interface{}
+	if groupID != 0 {
+		group = groupID
+	}
+
+	message := fmt.Sprintf("%s replied to your comment: %s", replierName, truncateMessage(content))
+	result, err := sqlite.DB.Exec(
+		`INSERT INTO notifications (type, content, user_id, from_user_id, group_id, is_read, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		"comment_reply",
+		message,
+		recipientID,
+		replierID,
+		group,
+		false)
+	if err != nil {
+		return fmt.Errorf("error inserting notification: %w", err)
+	}
+
+	notificationID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting notification ID: %w", err)
+	}
+
+	// The WebSocket carries the stored date, like the notification list
+	var createdAt time.Time
+	err = sqlite.DB.QueryRow("SELECT created_at FROM notifications WHERE id = ?", notificationID).Scan(&createdAt)
+	if err != nil {
+		return fmt.Errorf("error reading notification: %w", err)
+	}
+
+	notification := map[string]interface{}{
+		"id":             notificationID,
+		"type":           "comment_reply",
+		"content":        message,
+		"userId":         recipientID,
+		"fromUserId":     replierID,
+		"fromUserName":   replierName,
+		"fromUserAvatar": replierAvatar,
+		"postId":         postID,
+		"isRead":         false,
+		"createdAt":      createdAt.Format(time.RFC3339),
+	}
+	if groupID != 0 {
+		notification["groupId"] = groupID
+	}
+
+	broadcast <- models.BroadcastMessage{
+		Data:        models.WebSocketMessage{Type: "notification", Data: notification},
+		TargetUsers: mapIntSliceToMap([]int{recipientID}),
+	}
+
+	return nil
+}
+
+// CreateMentionNotification tells a user they were mentioned. kind and
+// contentID say where, groupID is 0 outside groups.
+func CreateMentionNotification(recipientID, authorID, groupID int, kind string, contentID int, content string) error {
+	if blocked, err := policy.IsBlocked(sqlite.DB, recipientID, authorID); err != nil || blocked {
+		return err
+	}
+	if muted, err := policy.HasMutedUser(sqlite.DB, recipientID, authorID); err != nil || muted {
+		return err
+	}
+
+	var authorName, authorAvatar string
+	err := sqlite.DB.QueryRow(
+		"SELECT first_name || ' ' || last_name, COALESCE(avatar, '') FROM users WHERE id = ?",
+		authorID).Scan(&authorName, &authorAvatar)
+	if err != nil {
+		return fmt.Errorf("error getting author info: %w", err)
+	}
+
+	var group interface{}
+	if groupID != 0 {
+		group = groupID
+	}
+
+	message := fmt.Sprintf("%s mentioned you: %s", authorName, truncateMessage(content))
+	result, err := sqlite.DB.Exec(
+		`INSERT INTO notifications (type, content, user_id, from_user_id, group_id, is_read, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		"mention",
+		message,
+		recipientID,
+		authorID,
+		group,
+		false)
+	if err != nil {
+		return fmt.Errorf("error inserting notification: %w", err)
+	}
+
+	notificationID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting notification ID: %w", err)
+	}
+
+	// The WebSocket carries the stored date, like the notification list
+	var createdAt time.Time
+	err = sqlite.DB.QueryRow("SELECT created_at FROM notifications WHERE id = ?", notificationID).Scan(&createdAt)
+	if err != nil {
+		return fmt.Errorf("error reading notification: %w", err)
+	}
+
+	notification := map[string]interface{}{
+		"id":             notificationID,
+		"type":           "mention",
+		"content":        message,
+		"userId":         recipientID,
+		"fromUserId":     authorID,
+		"fromUserName":   authorName,
+		"fromUserAvatar": authorAvatar,
+		"contentType":    kind,
+		"contentId":      contentID,
+		"isRead":         false,
+		"createdAt":      createdAt.Format(time.RFC3339),
+	}
+	if groupID != 0 {
+		notification["groupId"] = groupID
+	}
+
+	broadcast <- models.BroadcastMessage{
+		Data:        models.WebSocketMessage{Type: "notification", Data: notification},
+		TargetUsers: mapIntSliceToMap([]int{recipientID}),
+	}
+
+	return nil
+}
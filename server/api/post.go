@@ -10,6 +10,8 @@ import (
 
 	m "social-network/models"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/media"
+	"social-network/pkg/policy"
 	"social-network/util"
 )
 
@@ -46,52 +48,28 @@ func GetMyPosts(w http.ResponseWriter, r *http.Request) {
 		userIdBody = requestData.UserID
 	}
 
-	canViewPosts := false
-	if userID == userIdBody {
-		canViewPosts = true
-	}
-
-	//check if the userIdBody is public
-	var isPrivate bool
-	err = sqlite.DB.QueryRow(`
-	select is_private 
-	FROM users WHERE id = ?`, userIdBody).Scan(&isPrivate)
-
+	// The posts list is part of the full profile
+	canViewPosts, err := policy.CanUserViewProfile(sqlite.DB, userIdBody, userID)
 	if err != nil {
-		log.Printf("Error checking user privacy: %v", err)
+		log.Printf("Error checking profile visibility: %v", err)
 		http.Error(w, "Failed to check user privacy", http.StatusInternalServerError)
 		return
 	}
 
-	if !isPrivate {
-		canViewPosts = true
-	}
-
-	//check if the user is a follower of the user whose posts are being fetched
-	if !canViewPosts {
-		err = sqlite.DB.QueryRow(`
-			SELECT EXISTS(
-				SELECT 1 FROM followers 
-				WHERE follower_id = ? AND followed_id = ?
-			)`, userID, userIdBody).Scan(&canViewPosts)
-		if err != nil {
-			log.Printf("Error checking follower status: %v", err)
-			http.Error(w, "Failed to check follower status", http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Fetch posts from the database
 	if !canViewPosts {
 		return
 	}
 
+	// Each post is still subject to its own audience
+	visible, args := policy.VisiblePostsCondition("p", userID)
 	rows, err := sqlite.DB.Query(`
-		SELECT p.id, p.title, p.content, p.media, p.privacy, p.author, p.created_at, p.group_id
+		SELECT p.id, p.title, p.content, COALESCE(p.media, ''), COALESCE(md.category, ''), COALESCE(md.filename, ''), p.privacy, p.author, p.created_at, p.group_id
 		FROM posts p
-		WHERE p.author = ?
+		LEFT JOIN media md ON md.id = p.media_id
+		WHERE p.author = ? AND `+visible+`
 		ORDER BY p.created_at DESC`,
-		userIdBody)
+		append([]interface{}{userIdBody}, args...)...)
 	if err != nil {
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		log.Printf("Error fetching posts: %v", err)
@@ -105,12 +83,17 @@ func GetMyPosts(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var post m.Post
 		var groupID *int // Use a pointer for the nullable GroupID
+		var mediaCategory, mediaFilename string
 
-		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.Media, &post.Privacy, &post.Author, &post.CreatedAt, &groupID); err != nil {
+		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.Media, &mediaCategory, &mediaFilename, &post.Privacy, &post.Author, &post.CreatedAt, &groupID); err != nil {
 			http.Error(w, "Error reading posts", http.StatusInternalServerError)
 			log.Printf("Error scanning post: %v", err)
 			return
 		}
+		if url := mediaURL(mediaCategory, mediaFilename); url != "" {
+			post.Media = url
+			post.Thumbnails = mediaThumbnails(mediaCategory, mediaFilename)
+		}
 
 		// Fetch the author's username from the database
 		var authorName string
@@ -139,6 +122,13 @@ func GetMyPosts(w http.ResponseWriter, r *http.Request) {
 		posts = append(posts, post)
 	}
 
+	// Mentions are resolved for the whole page at once
+	var batch entityBatch
+	for i := range posts {
+		batch.add(posts[i].Content, &posts[i].Entities)
+	}
+	batch.resolve()
+
 	// Return the posts as JSON
 	json.NewEncoder(w).Encode(posts)
 
@@ -169,12 +159,63 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var post m.Post
-	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid JSON data",
-		})
-		return
+	var upload *m.Media
+	if isMultipart(r) {
+		if err := parseUploadForm(w, r); err != nil {
+			writeMediaError(w, err)
+			return
+		}
+
+		post.Title = r.FormValue("title")
+		post.Content = r.FormValue("content")
+		if privacy := r.FormValue("privacy"); privacy != "" {
+			post.Privacy, err = strconv.Atoi(privacy)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Invalid privacy value",
+				})
+				return
+			}
+		}
+
+		if listID := r.FormValue("audienceListId"); listID != "" {
+			id, err := strconv.Atoi(listID)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Invalid audience list ID",
+				})
+				return
+			}
+			post.AudienceList = &id
+		}
+
+		// Selected users can be sent as repeated fields or a comma separated list
+		for _, value := range r.MultipartForm.Value["selectedUsers"] {
+			for _, idStr := range strings.Split(value, ",") {
+				if strings.TrimSpace(idStr) == "" {
+					continue
+				}
+				selectedID, err := strconv.Atoi(strings.TrimSpace(idStr))
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": "Invalid selected user ID",
+					})
+					return
+				}
+				post.SelectedUsers = append(post.SelectedUsers, selectedID)
+			}
+		}
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Invalid JSON data",
+			})
+			return
+		}
 	}
 
 	post.Author = userID
@@ -187,11 +228,64 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set default privacy if not provided
-	if post.Privacy == 0 {
-		post.Privacy = 1 // 1 for public, 2 for private, 3 for followers only
+	// Posts without a privacy value are public
+	if _, err := policy.ParsePrivacy(post.Privacy); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Invalid privacy value",
+		})
+		return
+	}
+
+	// A private post can be shared with one of the author's audience lists
+	if post.AudienceList != nil {
+		if policy.Privacy(post.Privacy) != policy.Private {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Audience lists can only be used with private posts",
+			})
+			return
+		}
+		var owned bool
+		err = sqlite.DB.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM audience_lists WHERE id = ? AND owner_id = ?)`,
+			*post.AudienceList, userID).Scan(&owned)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Failed to check audience list",
+			})
+			return
+		}
+		if !owned {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Audience list not found",
+			})
+			return
+		}
+	}
+
+	// Run the attachment through the upload pipeline, JSON clients send it as a data URL
+	if isMultipart(r) {
+		upload, err = saveUploadedMedia(r, "media", media.CategoryPost, userID)
+	} else if post.Media != "" {
+		upload, err = saveDataURLMedia(post.Media, media.CategoryPost, userID)
+	}
+	if err != nil {
+		writeMediaError(w, err)
+		return
 	}
 
+	// The media row is written outside the transaction, drop it again unless
+	// the post is committed. Deferred before the rollback so it runs after it.
+	committed := false
+	defer func() {
+		if !committed {
+			discardMedia(upload)
+		}
+	}()
+
 	// Start a transaction
 	tx, err := sqlite.DB.Begin()
 	if err != nil {
@@ -205,8 +299,8 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 
 	// Insert the post into the database using the transaction
 	result, err := tx.Exec(
-		"INSERT INTO posts (title, content, media, privacy, author, created_at) VALUES (?, ?, ?, ?, ?, datetime('now'))",
-		post.Title, post.Content, post.Media, post.Privacy, post.Author)
+		"INSERT INTO posts (title, content, media_id, privacy, audience_list_id, author, created_at) VALUES (?, ?, ?, ?, ?, ?, datetime('now'))",
+		post.Title, post.Content, mediaID(upload), post.Privacy, post.AudienceList, post.Author)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -227,7 +321,7 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Handle private post viewers
-	if post.Privacy == 2 && len(post.SelectedUsers) > 0 {
+	if policy.Privacy(post.Privacy) == policy.Private && len(post.SelectedUsers) > 0 {
 		// Insert into post_PrivateViews for each selected user
 		for _, selectedUserID := range post.SelectedUsers {
 			_, err = tx.Exec(
@@ -243,6 +337,16 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	mentioned, err := indexEntities(tx, contentPost, int(postID), userID, post.Content)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to create post",
+		})
+		log.Printf("Error indexing post: %v", err)
+		return
+	}
+
 	// Commit the transaction
 	if err = tx.Commit(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -251,20 +355,28 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	committed = true
+
+	notifyMentions(mentioned, contentPost, int(postID), userID, 0, post.Content, postAudience(int(postID)))
 
 	// After successfully creating the post, fetch the complete post data
 	var completePost m.Post
+	var mediaCategory, mediaFilename string
 	err = sqlite.DB.QueryRow(`
-        SELECT p.id, p.title, p.content, p.media, p.privacy, p.author, p.created_at,
+        SELECT p.id, p.title, p.content, COALESCE(p.media, ''), COALESCE(md.category, ''), COALESCE(md.filename, ''),
+               p.privacy, p.author, p.created_at,
                u.username as authorName, u.avatar as authorAvatar
         FROM posts p
         JOIN users u ON p.author = u.id
+        LEFT JOIN media md ON md.id = p.media_id
         WHERE p.id = ?`,
 		postID).Scan(
 		&completePost.ID,
 		&completePost.Title,
 		&completePost.Content,
 		&completePost.Media,
+		&mediaCategory,
+		&mediaFilename,
 		&completePost.Privacy,
 		&completePost.Author,
 		&completePost.CreatedAt,
@@ -278,6 +390,12 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	completePost.AudienceList = post.AudienceList
+	completePost.Entities = resolveEntities(completePost.Content)
+	if url := mediaURL(mediaCategory, mediaFilename); url != "" {
+		completePost.Media = url
+		completePost.Thumbnails = mediaThumbnails(mediaCategory, mediaFilename)
+	}
 
 	// Return the complete post data
 	w.WriteHeader(http.StatusCreated)
@@ -337,22 +455,20 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("UserID: %v", userID)
 
-	// Updated query to handle all privacy cases
+	// Only the posts the visibility policy lets the user see, without the
+	// authors and groups they muted
+	visible, args := policy.VisiblePostsCondition("p", userID)
+	notMutedUser, mutedUserArgs := policy.NotMutedUserCondition("p.author", userID)
+	notMutedGroup, mutedGroupArgs := policy.NotMutedGroupCondition("p.group_id", userID)
+	args = append(append(args, mutedUserArgs...), mutedGroupArgs...)
 	rows, err := sqlite.DB.Query(`
-			-- Update the query to check for accepted followers status
-			SELECT DISTINCT p.id, p.title, p.content, p.media, p.privacy, p.author, p.created_at, p.group_id
+			SELECT p.id, p.title, p.content, COALESCE(p.media, ''), COALESCE(md.category, ''), COALESCE(md.filename, ''), p.privacy, p.author, p.created_at, p.group_id
 			FROM posts p
-			LEFT JOIN followers f ON p.author = f.followed_id 
-				AND f.follower_id = ? 
-				AND f.status = 'accepted'  -- Add status check
-			LEFT JOIN post_PrivateViews pv ON p.id = pv.post_id AND pv.user_id = ?
-			WHERE p.privacy = 0  -- Public posts (everyone can view)
-			OR p.author = ?      -- User's own posts
-			OR (p.privacy = 1 AND f.follower_id IS NOT NULL)  -- Posts visible to followers only
-			OR (p.privacy = 2 AND pv.user_id IS NOT NULL)     -- Private posts visible to selected users only
+			LEFT JOIN media md ON md.id = p.media_id
+			WHERE `+visible+` AND `+notMutedUser+` AND `+notMutedGroup+`
 			ORDER BY p.created_at DESC
 			`,
-		userID, userID, userID)
+		args...)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -368,12 +484,15 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var post m.Post
 		var groupID sql.NullInt64 // Handle nullable group_id
+		var mediaCategory, mediaFilename string
 
 		if err := rows.Scan(
 			&post.ID,
 			&post.Title,
 			&post.Content,
 			&post.Media,
+			&mediaCategory,
+			&mediaFilename,
 			&post.Privacy,
 			&post.Author,
 			&post.CreatedAt,
@@ -401,6 +520,10 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 
 		post.AuthorName = authorName
 		post.AuthorAvatar = authorAvatar
+		if url := mediaURL(mediaCategory, mediaFilename); url != "" {
+			post.Media = url
+			post.Thumbnails = mediaThumbnails(mediaCategory, mediaFilename)
+		}
 
 		// Handle nullable group_id
 		if groupID.Valid {
@@ -418,49 +541,20 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Mentions are resolved for the whole page at once
+	var batch entityBatch
+	for i := range posts {
+		batch.add(posts[i].Content, &posts[i].Entities)
+	}
+	batch.resolve()
+
 	json.NewEncoder(w).Encode(posts)
 }
 
-// Add this helper function
+// canUserViewPost reports whether userID can see a post, following the
+// visibility policy. It returns sql.ErrNoRows when the post doesn't exist.
 func canUserViewPost(postID, userID int) (bool, error) {
-	var post m.Post
-	var isAllowed bool
-
-	// First get the post details
-	err := sqlite.DB.QueryRow(`
-        SELECT p.privacy, p.author 
-        FROM posts p 
-        WHERE p.id = ?`, postID).Scan(&post.Privacy, &post.Author)
-	if err != nil {
-		return false, err
-	}
-
-	// If user is the author or post is public, they can view it
-	if post.Author == userID || post.Privacy == 0 {
-		return true, nil
-	}
-
-	// For almost-private posts (followers only)
-	if post.Privacy == 1 {
-		err = sqlite.DB.QueryRow(`
-            SELECT EXISTS(
-                SELECT 1 FROM followers 
-                WHERE follower_id = ? AND followed_id = ?
-            )`, userID, post.Author).Scan(&isAllowed)
-		return isAllowed, err
-	}
-
-	// For private posts
-	if post.Privacy == 2 {
-		err = sqlite.DB.QueryRow(`
-            SELECT EXISTS(
-                SELECT 1 FROM post_PrivateViews 
-                WHERE post_id = ? AND user_id = ?
-            )`, postID, userID).Scan(&isAllowed)
-		return isAllowed, err
-	}
-
-	return false, nil
+	return policy.CanUserViewPost(sqlite.DB, postID, userID)
 }
 
 func ViewPost(w http.ResponseWriter, r *http.Request) {
@@ -500,11 +594,14 @@ func ViewPost(w http.ResponseWriter, r *http.Request) {
 
 	// Fetch the post
 	var post m.Post
+	var groupID sql.NullInt64
+	var mediaCategory, mediaFilename string
 	err = sqlite.DB.QueryRow(`
-		SELECT p.id, p.title, p.content, p.media, p.privacy, p.author, p.created_at, p.group_id
+		SELECT p.id, p.title, p.content, COALESCE(p.media, ''), COALESCE(md.category, ''), COALESCE(md.filename, ''), p.privacy, p.author, p.created_at, p.group_id
 		FROM posts p
+		LEFT JOIN media md ON md.id = p.media_id
 		WHERE p.id = ?`,
-		postID).Scan(&post.ID, &post.Title, &post.Content, &post.Media, &post.Privacy, &post.Author, &post.CreatedAt, &post.GroupID)
+		postID).Scan(&post.ID, &post.Title, &post.Content, &post.Media, &mediaCategory, &mediaFilename, &post.Privacy, &post.Author, &post.CreatedAt, &groupID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -522,31 +619,32 @@ func ViewPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if user has permission to view the post
-	if post.Privacy != 0 && post.Author != userID {
-		// For private posts, check if user is a follower
-		if post.Privacy == 2 {
-			var isFollower bool
-			err = sqlite.DB.QueryRow(`
-				SELECT EXISTS(
-					SELECT 1 FROM followers 
-					WHERE follower_id = ? AND following_id = ?
-				)`,
-				userID, post.Author).Scan(&isFollower)
-			if err != nil || !isFollower {
-				w.WriteHeader(http.StatusForbidden)
-				json.NewEncoder(w).Encode(map[string]string{
-					"error": "You don't have permission to view this post",
-				})
-				return
-			}
-		} else {
-			w.WriteHeader(http.StatusForbidden)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "You don't have permission to view this post",
-			})
-			return
-		}
+	canView, err := canUserViewPost(post.ID, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to check post permissions",
+		})
+		log.Printf("Error checking post permissions: %v", err)
+		return
+	}
+	if !canView {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "You don't have permission to view this post",
+		})
+		return
+	}
+
+	if groupID.Valid {
+		post.GroupID = int(groupID.Int64)
 	}
+	if url := mediaURL(mediaCategory, mediaFilename); url != "" {
+		post.Media = url
+		post.Thumbnails = mediaThumbnails(mediaCategory, mediaFilename)
+	}
+
+	post.Entities = resolveEntities(post.Content)
 
 	// Return the post
 	json.NewEncoder(w).Encode(post)
@@ -588,11 +686,13 @@ func GetPostDetails(w http.ResponseWriter, r *http.Request) {
 	// Fetch for post details to get the author and check if the user is authorized to view the post
 	var post m.Post
 	var groupID *int // Use a pointer for the nullable GroupID
+	var mediaCategory, mediaFilename string
 	err = sqlite.DB.QueryRow(`
-		SELECT p.id, p.title, p.content, p.media, p.privacy, p.author, p.created_at, p.group_id
+		SELECT p.id, p.title, p.content, COALESCE(p.media, ''), COALESCE(md.category, ''), COALESCE(md.filename, ''), p.privacy, p.author, p.created_at, p.group_id
 		FROM posts p
+		LEFT JOIN media md ON md.id = p.media_id
 		WHERE p.id = ?`,
-		postID).Scan(&post.ID, &post.Title, &post.Content, &post.Media, &post.Privacy, &post.Author, &post.CreatedAt, &groupID)
+		postID).Scan(&post.ID, &post.Title, &post.Content, &post.Media, &mediaCategory, &mediaFilename, &post.Privacy, &post.Author, &post.CreatedAt, &groupID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -628,30 +728,21 @@ func GetPostDetails(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if user has permission to view the post
-	if post.Privacy != 0 && post.Author != userID {
-		// For private posts, check if user is a follower
-		if post.Privacy == 2 {
-			var isFollower bool
-			err = sqlite.DB.QueryRow(`
-				SELECT EXISTS(
-					SELECT 1 FROM followers 
-					WHERE follower_id = ? AND following_id = ?
-				)`,
-				userID, post.Author).Scan(&isFollower)
-			if err != nil || !isFollower {
-				w.WriteHeader(http.StatusForbidden)
-				json.NewEncoder(w).Encode(map[string]string{
-					"error": "You don't have permission to view this post",
-				})
-				return
-			}
-		} else {
-			w.WriteHeader(http.StatusForbidden)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "You don't have permission to view this post",
-			})
-			return
-		}
+	canView, err := canUserViewPost(post.ID, userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to check post permissions",
+		})
+		log.Printf("Error checking post permissions: %v", err)
+		return
+	}
+	if !canView {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "You don't have permission to view this post",
+		})
+		return
 	}
 
 	// Fetch the author's username from the database
@@ -684,11 +775,13 @@ WHERE id = ?`,
 	}
 
 	post.AuthorName = authorName
+	if url := mediaURL(mediaCategory, mediaFilename); url != "" {
+		post.Media = url
+		post.Thumbnails = mediaThumbnails(mediaCategory, mediaFilename)
+	}
 
 	//get the comments in that post
-	rows, err := sqlite.DB.Query(`
-		SELECT c.id, c.content, c.author, c.created_at
-		FROM comments c
+	rows, err := sqlite.DB.Query(postCommentQuery+`
 		WHERE c.post_id = ?
 		ORDER BY c.created_at DESC`,
 		postID)
@@ -703,39 +796,22 @@ WHERE id = ?`,
 
 	defer rows.Close()
 
-	var comments []m.Comment
-	for rows.Next() {
-		var comment m.Comment
-		if err := rows.Scan(&comment.ID, &comment.Content, &comment.Author, &comment.CreatedAt); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Error reading comments",
-			})
-			log.Printf("Error scanning comment: %v", err)
-			return
-		}
-
-		// Fetch the author's username from the database
-		var authorName string
-		var authorAvatar string
-		err = sqlite.DB.QueryRow(`
-		SELECT username, avatar
-		FROM users
-		WHERE id = ?`,
-			comment.Author).Scan(&authorName, &authorAvatar)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Failed to fetch author's username",
-			})
-			log.Printf("Error fetching author's username: %v", err)
-			return
-		}
-
-		comment.AuthorName = authorName
-		comment.AuthorAvatar = authorAvatar
+	var batch entityBatch
+	comments, err := scanPostComments(rows, &batch)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Error reading comments",
+		})
+		log.Printf("Error scanning comment: %v", err)
+		return
+	}
+	// The post is resolved along with its comments
+	batch.add(post.Content, &post.Entities)
+	batch.resolve()
 
-		comments = append(comments, comment)
+	if nestedView(r) {
+		comments = nestComments(comments)
 	}
 
 	//return the post and the comments
@@ -771,112 +847,59 @@ func AddPostComment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the post ID and comment content from the body
-	var requestData struct {
-		PostID  string `json:"postId"`
-		Content string `json:"content"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid JSON data",
-		})
-		return
-	}
-
-	//check if current user has permission to comment on the post
-	//check if the posts public if not check if the current user follows that post author
-	var postPrivacy int
-	err = sqlite.DB.QueryRow(`
-		SELECT privacy
-		FROM posts
-		WHERE id = ?`,
-		requestData.PostID).Scan(&postPrivacy)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Failed to fetch post privacy",
-		})
-		return
-	}
-
-	if postPrivacy != 0 {
-		if postPrivacy == 2 {
-			var isFollower bool
-			err = sqlite.DB.QueryRow(`
-				SELECT EXISTS(
-					SELECT 1 FROM followers 
-					WHERE follower_id = ? AND following_id = (
-						SELECT author FROM posts WHERE id = ?
-					)
-				)`,
-				userID, requestData.PostID).Scan(&isFollower)
-			if err != nil || !isFollower {
-				w.WriteHeader(http.StatusForbidden)
-				json.NewEncoder(w).Encode(map[string]string{
-					"error": "You don't have permission to comment on this post",
-				})
+	// Comments with an image attachment are sent as a multipart form
+	var input newComment
+	var postIDValue string
+	if isMultipart(r) {
+		input, err = readCommentForm(w, r)
+		if err != nil {
+			writeCommentError(w, err)
+			return
+		}
+		postIDValue = r.FormValue("postId")
+		// parentCommentId is accepted like in JSON bodies
+		if input.ParentCommentID == nil {
+			input.ParentCommentID, err = parseParentCommentID(r.FormValue("parentCommentId"))
+			if err != nil {
+				sendJSONError(w, "Invalid parent comment ID", http.StatusBadRequest)
 				return
 			}
-		} else {
-			w.WriteHeader(http.StatusForbidden)
+		}
+	} else {
+		var requestData struct {
+			PostID          string `json:"postId"`
+			Content         string `json:"content"`
+			Media           string `json:"media"`
+			ParentCommentID *int   `json:"parentCommentId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]string{
-				"error": "You don't have permission to comment on this post",
+				"error": "Invalid JSON data",
 			})
 			return
 		}
+		postIDValue = requestData.PostID
+		input = newComment{
+			Content:         requestData.Content,
+			ParentCommentID: requestData.ParentCommentID,
+			MediaDataURL:    requestData.Media,
+		}
 	}
 
-	// Insert the comment into the database
-	_, err = sqlite.DB.Exec(
-		"INSERT INTO comments (content, author, post_id, created_at) VALUES (?, ?, ?, datetime('now'))",
-		requestData.Content, userID, requestData.PostID)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Failed to create comment",
-		})
-		log.Printf("Error creating comment: %v", err)
-		return
-	}
-
-	// Return comment object that will have the author details and the comment
-	// itself with the ID and the created_at timestamp
-	var comment m.Comment
-	err = sqlite.DB.QueryRow(`
-		SELECT c.id, c.content, c.author, c.created_at
-		FROM comments c
-		WHERE c.author = ? AND c.post_id = ? AND c.content = ?`,
-		userID, requestData.PostID, requestData.Content).Scan(&comment.ID, &comment.Content, &comment.Author, &comment.CreatedAt)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Failed to fetch comment",
-		})
-		log.Printf("Error fetching comment: %v", err)
+	postID, err := strconv.Atoi(postIDValue)
+	if err != nil || postID < 1 {
+		sendJSONError(w, "Invalid post ID", http.StatusBadRequest)
 		return
 	}
 
-	// Fetch the author's username from the database
-	var authorName string
-	var authorAvatar string
-	err = sqlite.DB.QueryRow(`
-		SELECT username, avatar
-		FROM users
-		WHERE id = ?`,
-		comment.Author).Scan(&authorName, &authorAvatar)
+	// The comment service checks the user can see the post and returns the
+	// comment with the author details, its ID and the created_at timestamp
+	comment, err := addPostComment(userID, postID, input)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Failed to fetch author's username",
-		})
-		log.Printf("Error fetching author's username: %v", err)
+		writeCommentError(w, err)
 		return
 	}
 
-	comment.AuthorName = authorName
-	comment.AuthorAvatar = authorAvatar
-
-	// Return the comment
 	json.NewEncoder(w).Encode(comment)
-
 }
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"social-network/pkg/db/sqlite"
+)
+
+// Actions recorded in privacy_audit_log
+const (
+	auditWentPublic       = "went_public"
+	auditWentPrivate      = "went_private"
+	auditRemovedFollowers = "removed_followers"
+)
+
+// privacyTransition is what changing the privacy of a profile did
+type privacyTransition struct {
+	// AcceptedRequests are the users whose pending requests were accepted
+	// when the profile went public
+	AcceptedRequests []int `json:"acceptedRequests,omitempty"`
+	// FollowersToReview is set when the profile went private, it is the number
+	// of current followers the user may want to review
+	FollowersToReview int `json:"followersToReview,omitempty"`
+}
+
+// recordPrivacyAudit adds an entry to the privacy audit log
+func recordPrivacyAudit(tx *sql.Tx, userID int, action string, affected []int) error {
+	if affected == nil {
+		affected = []int{}
+	}
+	ids, err := json.Marshal(affected)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO privacy_audit_log (user_id, action, affected_count, affected_user_ids)
+		VALUES (?, ?, ?, ?)`, userID, action, len(affected), string(ids))
+	return err
+}
+
+// applyPrivacyTransition runs the side effects of a profile changing its
+// privacy. Going public accepts every pending follow request and notifies the
+// requesters, going private counts the followers to review. Both are audited.
+func applyPrivacyTransition(tx *sql.Tx, userID int, nowPrivate bool) (privacyTransition, error) {
+	var transition privacyTransition
+
+	if nowPrivate {
+		err := tx.QueryRow(`
+			SELECT COUNT(*) FROM followers
+			WHERE followed_id = ? AND status = 'accepted'`, userID).Scan(&transition.FollowersToReview)
+		if err != nil {
+			return transition, err
+		}
+		return transition, recordPrivacyAudit(tx, userID, auditWentPrivate, nil)
+	}
+
+	rows, err := tx.Query(`
+		SELECT follower_id FROM followers
+		WHERE followed_id = ? AND status = 'pending'`, userID)
+	if err != nil {
+		return transition, err
+	}
+	for rows.Next() {
+		var requesterID int
+		if err := rows.Scan(&requesterID); err != nil {
+			rows.Close()
+			return transition, err
+		}
+		transition.AcceptedRequests = append(transition.AcceptedRequests, requesterID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return transition, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE followers SET status = 'accepted'
+		WHERE followed_id = ? AND status = 'pending'`, userID)
+	if err != nil {
+		return transition, err
+	}
+
+	// The requests are answered, their notifications are done with
+	_, err = tx.Exec(`
+		DELETE FROM notifications
+		WHERE user_id = ? AND type = 'follow_request'`, userID)
+	if err != nil {
+		return transition, err
+	}
+
+	for _, requesterID := range transition.AcceptedRequests {
+		_, err = tx.Exec(`
+			INSERT INTO notifications (user_id, type, content, from_user_id)
+			VALUES (?, 'follow_request_accepted', 'has accepted your follow request', ?)`,
+			requesterID, userID)
+		if err != nil {
+			return transition, err
+		}
+	}
+
+	return transition, recordPrivacyAudit(tx, userID, auditWentPublic, transition.AcceptedRequests)
+}
+
+// RemoveFollowers removes several followers of the user of the session at
+// once, for reviewing them after going private
+func RemoveFollowers(w http.ResponseWriter, r *http.Request) {
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	var req struct {
+		UserIDs []int `json:"userIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.UserIDs) == 0 {
+		sendJSONError(w, "No followers to remove", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	in := placeholders(len(req.UserIDs))
+	args := []interface{}{userID}
+	for _, id := range req.UserIDs {
+		args = append(args, id)
+	}
+
+	// Only the given users that actually follow are removed and audited
+	rows, err := tx.Query(`
+		SELECT follower_id FROM followers
+		WHERE followed_id = ? AND status = 'accepted' AND follower_id IN (`+in+`)`, args...)
+	if err != nil {
+		sendJSONError(w, "Failed to remove followers", http.StatusInternalServerError)
+		return
+	}
+	removed := []int{}
+	for rows.Next() {
+		var followerID int
+		if err := rows.Scan(&followerID); err != nil {
+			rows.Close()
+			sendJSONError(w, "Failed to remove followers", http.StatusInternalServerError)
+			return
+		}
+		removed = append(removed, followerID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading followers to remove: %v", err)
+		sendJSONError(w, "Failed to remove followers", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM followers
+		WHERE followed_id = ? AND status = 'accepted' AND follower_id IN (`+in+`)`, args...)
+	if err != nil {
+		sendJSONError(w, "Failed to remove followers", http.StatusInternalServerError)
+		return
+	}
+
+	if err := recordPrivacyAudit(tx, userID, auditRemovedFollowers, removed); err != nil {
+		log.Printf("Error auditing follower removal: %v", err)
+		sendJSONError(w, "Failed to remove followers", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to remove followers", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Followers removed",
+		"removed": removed,
+	})
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	m "social-network/models"
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/entities"
+	"social-network/pkg/media"
+	"social-network/pkg/policy"
+	"social-network/util"
+)
+
+// Length limits of the profile fields
+const (
+	maxNameLength     = 50
+	maxUsernameLength = 30
+	maxAboutMeLength  = 500
+)
+
+// profilePatch is a partial profile update. Fields left out of the request
+// are nil and keep their value.
+type profilePatch struct {
+	FirstName       *string           `json:"first_name"`
+	LastName        *string           `json:"last_name"`
+	Username        *string           `json:"username"`
+	AboutMe         *string           `json:"about_me"`
+	DateOfBirth     *string           `json:"date_of_birth"`
+	IsPrivate       *bool             `json:"is_private"`
+	FieldVisibility map[string]string `json:"field_visibility"`
+}
+
+// readProfilePatch reads a profile update sent as JSON, or as a multipart
+// form when it carries an avatar or cover image
+func readProfilePatch(w http.ResponseWriter, r *http.Request) (profilePatch, error) {
+	var patch profilePatch
+	if !isMultipart(r) {
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			return patch, &statusError{http.StatusBadRequest, "Invalid request body"}
+		}
+		return patch, nil
+	}
+
+	if err := parseUploadForm(w, r); err != nil {
+		return patch, err
+	}
+	value := func(key string) *string {
+		if values, ok := r.MultipartForm.Value[key]; ok && len(values) > 0 {
+			return &values[0]
+		}
+		return nil
+	}
+	patch.FirstName = value("first_name")
+	patch.LastName = value("last_name")
+	patch.Username = value("username")
+	patch.AboutMe = value("about_me")
+	patch.DateOfBirth = value("date_of_birth")
+	if isPrivate := value("is_private"); isPrivate != nil {
+		private := *isPrivate == "true" || *isPrivate == "1"
+		patch.IsPrivate = &private
+	}
+	if visibility := value("field_visibility"); visibility != nil {
+		if err := json.Unmarshal([]byte(*visibility), &patch.FieldVisibility); err != nil {
+			return patch, &statusError{http.StatusBadRequest, "Invalid field_visibility"}
+		}
+	}
+	return patch, nil
+}
+
+// parseDateOfBirth accepts a plain date or a full timestamp
+func parseDateOfBirth(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// validate normalizes the patch and checks every field it sets
+func (p *profilePatch) validate() error {
+	checkName := func(value *string, label string) error {
+		if value == nil {
+			return nil
+		}
+		*value = strings.TrimSpace(*value)
+		if *value == "" || utf8.RuneCountInString(*value) > maxNameLength {
+			return &statusError{http.StatusBadRequest, label + " must be 1 to 50 characters"}
+		}
+		return nil
+	}
+	if err := checkName(p.FirstName, "First name"); err != nil {
+		return err
+	}
+	if err := checkName(p.LastName, "Last name"); err != nil {
+		return err
+	}
+
+	if p.Username != nil {
+		*p.Username = strings.TrimSpace(*p.Username)
+		if *p.Username == "" || utf8.RuneCountInString(*p.Username) > maxUsernameLength {
+			return &statusError{http.StatusBadRequest, "Username must be 1 to 30 characters"}
+		}
+		// Anything else couldn't be mentioned
+		if !entities.ValidUsername(*p.Username) {
+			return &statusError{http.StatusBadRequest,
+				"Username can only have letters, numbers, _, . and -, and can't end with . or -"}
+		}
+	}
+
+	if p.AboutMe != nil && utf8.RuneCountInString(*p.AboutMe) > maxAboutMeLength {
+		return &statusError{http.StatusBadRequest, "About me must be at most 500 characters"}
+	}
+
+	if p.DateOfBirth != nil {
+		date, err := parseDateOfBirth(*p.DateOfBirth)
+		if err != nil || !date.Before(time.Now()) {
+			return &statusError{http.StatusBadRequest, "Invalid date of birth"}
+		}
+		*p.DateOfBirth = date.Format("2006-01-02")
+	}
+
+	for field, value := range p.FieldVisibility {
+		if err := policy.CheckProfileField(field); err != nil {
+			return &statusError{http.StatusBadRequest, "Unknown profile field " + field}
+		}
+		if _, err := policy.ParseAudience(value); err != nil {
+			return &statusError{http.StatusBadRequest, "Invalid audience for " + field}
+		}
+	}
+	return nil
+}
+
+// hideProfileFields blanks the fields of a profile that viewerID isn't in the
+// audience of
+func hideProfileFields(user *m.User, viewerID int) error {
+	ownerID := int(user.ID)
+	rel, err := policy.LoadRelation(sqlite.DB, viewerID, ownerID)
+	if err != nil {
+		return err
+	}
+	audiences, err := policy.LoadFieldAudiences(sqlite.DB, ownerID)
+	if err != nil {
+		return err
+	}
+
+	if !policy.CanViewField(audiences[policy.FieldEmail], rel) {
+		user.Email = ""
+	}
+	if !policy.CanViewField(audiences[policy.FieldDateOfBirth], rel) {
+		user.DateOfBirth = nil
+	}
+	if !policy.CanViewField(audiences[policy.FieldAboutMe], rel) {
+		user.AboutMe = ""
+	}
+	return nil
+}
+
+// PatchProfile updates the fields of the profile of the user of the session
+// that the request sets. Avatars and cover images are uploaded as the avatar
+// and cover files of a multipart form and go through the same checks as every
+// other upload.
+func PatchProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, err := util.GetUsernameFromSession(r)
+	if err != nil {
+		sendJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var userID int
+	if err := sqlite.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID); err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	patch, err := readProfilePatch(w, r)
+	if err == nil {
+		err = patch.validate()
+	}
+	if err != nil {
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			sendJSONError(w, statusErr.message, statusErr.status)
+		} else {
+			writeMediaError(w, err)
+		}
+		return
+	}
+
+	// Store the images before the transaction, their media rows are written
+	// on their own connection
+	var avatar, cover *m.Media
+	if isMultipart(r) {
+		if avatar, err = saveUploadedMedia(r, "avatar", media.CategoryAvatar, userID); err == nil {
+			// Cover images are profile pictures too and share the avatars category
+			cover, err = saveUploadedMedia(r, "cover", media.CategoryAvatar, userID)
+		}
+		if err != nil {
+			discardMedia(avatar)
+			writeMediaError(w, err)
+			return
+		}
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			discardMedia(avatar)
+			discardMedia(cover)
+		}
+	}()
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		sendJSONError(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var wasPrivate bool
+	if err := tx.QueryRow("SELECT is_private FROM users WHERE id = ?", userID).Scan(&wasPrivate); err != nil {
+		sendJSONError(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	// The unique index settles races between two users taking the same
+	// name, checking first gives the usual answer a clear message
+	if patch.Username != nil && *patch.Username != username {
+		var taken bool
+		err := tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM users WHERE username = ? AND id != ?)`,
+			*patch.Username, userID).Scan(&taken)
+		if err != nil {
+			sendJSONError(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			sendJSONError(w, "Username is already taken", http.StatusConflict)
+			return
+		}
+	}
+
+	var sets []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		sets = append(sets, column+" = ?")
+		args = append(args, value)
+	}
+	if patch.FirstName != nil {
+		set("first_name", *patch.FirstName)
+	}
+	if patch.LastName != nil {
+		set("last_name", *patch.LastName)
+	}
+	if patch.Username != nil {
+		set("username", *patch.Username)
+	}
+	if patch.AboutMe != nil {
+		set("about_me", *patch.AboutMe)
+	}
+	if patch.DateOfBirth != nil {
+		set("date_of_birth", *patch.DateOfBirth)
+	}
+	if patch.IsPrivate != nil {
+		set("is_private", *patch.IsPrivate)
+	}
+	if avatar != nil {
+		set("avatar", avatar.URL)
+	}
+	if cover != nil {
+		set("cover_image", cover.URL)
+	}
+
+	if len(sets) > 0 {
+		_, err = tx.Exec("UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, userID)...)
+		if isUniqueViolation(err) {
+			sendJSONError(w, "Username is already taken", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating profile: %v", err)
+			sendJSONError(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	for field, audience := range patch.FieldVisibility {
+		_, err = tx.Exec(`
+			INSERT INTO user_field_visibility (user_id, field, audience)
+			VALUES (?, ?, ?)
+			ON CONFLICT(user_id, field) DO UPDATE SET audience = excluded.audience`,
+			userID, field, audience)
+		if err != nil {
+			log.Printf("Error updating field visibility: %v", err)
+			sendJSONError(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var transition privacyTransition
+	if patch.IsPrivate != nil && *patch.IsPrivate != wasPrivate {
+		transition, err = applyPrivacyTransition(tx, userID, *patch.IsPrivate)
+		if err != nil {
+			log.Printf("Error applying privacy change: %v", err)
+			sendJSONError(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendJSONError(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+	committed = true
+
+	// Sessions are keyed by username
+	if patch.Username != nil && *patch.Username != username {
+		util.RenameSessions(username, *patch.Username)
+	}
+
+	profile, err := loadOwnProfile(userID)
+	if err != nil {
+		log.Printf("Error loading updated profile: %v", err)
+		sendJSONError(w, "Failed to load profile", http.StatusInternalServerError)
+		return
+	}
+	audiences, err := policy.LoadFieldAudiences(sqlite.DB, userID)
+	if err != nil {
+		log.Printf("Error loading field audiences: %v", err)
+		sendJSONError(w, "Failed to load profile", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"user":            profile,
+		"fieldVisibility": audiences,
+		"transition":      transition,
+	})
+}
+
+// loadOwnProfile loads every field of a profile, for its owner
+func loadOwnProfile(userID int) (*m.User, error) {
+	var user m.User
+	var avatar, aboutMe, cover sql.NullString
+	err := sqlite.DB.QueryRow(`
+		SELECT id, email, username, first_name, last_name, date_of_birth, avatar, about_me, cover_image, is_private, created_at
+		FROM users WHERE id = ?`, userID).Scan(
+		&user.ID, &user.Email, &user.Username, &user.FirstName, &user.LastName, &user.DateOfBirth,
+		&avatar, &aboutMe, &cover, &user.IsPrivate, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.Avatar = avatar.String
+	user.AvatarThumbnails = avatarThumbnails(user.Avatar)
+	user.AboutMe = aboutMe.String
+	user.CoverImage = cover.String
+	return &user, nil
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	// maxSearchTerms bounds how many words of a query are matched
+	maxSearchTerms = 10
+)
+
+// Types of search results
+const (
+	searchUser      = "user"
+	searchPost      = "post"
+	searchGroupPost = "group_post"
+	searchGroup     = "group"
+	searchEvent     = "event"
+)
+
+var searchTypes = []string{searchUser, searchPost, searchGroupPost, searchGroup, searchEvent}
+
+// SearchResult is a match of a search, Snippet is the matching text with the
+// matched terms wrapped in <mark> and everything else HTML escaped
+type SearchResult struct {
+	Type     string  `json:"type"`
+	ID       int     `json:"id"`
+	GroupID  int     `json:"groupId,omitempty"`
+	Title    string  `json:"title"`
+	Subtitle string  `json:"subtitle,omitempty"`
+	Snippet  string  `json:"snippet"`
+	Rank     float64 `json:"rank"` // bm25, lower is a better match
+}
+
+// Snippets are marked with control characters that can't appear in a query
+// so they survive escaping
+const (
+	snippetOpen  = "\x02"
+	snippetClose = "\x03"
+)
+
+// snippetSQL is the snippet() call for an FTS table
+func snippetSQL(table string) string {
+	return fmt.Sprintf(`snippet(%s, -1, char(2), char(3), '…', 12)`, table)
+}
+
+// markSnippet escapes a snippet and turns its markers into <mark> tags
+func markSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, snippetOpen, "<mark>")
+	return strings.ReplaceAll(escaped, snippetClose, "</mark>")
+}
+
+// ftsQuery turns what a user typed into an FTS5 query. Every word has to
+// match, as a prefix so results show up while typing. Operators and quotes
+// are dropped so no query is a syntax error.
+func ftsQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
+	}
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// parseSearchTypes reads the comma separated type filter, all types by
+// default
+func parseSearchTypes(value string) (map[string]bool, error) {
+	types := make(map[string]bool)
+	if strings.TrimSpace(value) == "" {
+		for _, t := range searchTypes {
+			types[t] = true
+		}
+		return types, nil
+	}
+	for _, t := range strings.Split(value, ",") {
+		t = strings.TrimSpace(t)
+		known := false
+		for _, searchType := range searchTypes {
+			if t == searchType {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown search type %q", t)
+		}
+		types[t] = true
+	}
+	return types, nil
+}
+
+// searchBranches builds one SELECT per requested type, each only returning
+// what viewerID is allowed to see
+func searchBranches(types map[string]bool, query string, viewerID int) ([]string, []interface{}) {
+	var branches []string
+	var args []interface{}
+
+	if types[searchUser] {
+		// About me can be hidden from the viewer, then it neither matches nor
+		// shows up in the snippet
+		notBlocked, blockArgs := policy.NotBlockedCondition("u.id", viewerID)
+		aboutVisible, aboutArgs := policy.VisibleFieldCondition("u", policy.FieldAboutMe, viewerID)
+		branches = append(branches, `
+			SELECT 'user' AS type, u.id AS id, 0 AS group_id, u.username AS title,
+				u.first_name || ' ' || u.last_name AS subtitle,
+				CASE WHEN `+aboutVisible+` THEN `+snippetSQL("users_fts")+`
+					ELSE u.first_name || ' ' || u.last_name END AS snippet,
+				bm25(users_fts, 10.0, 5.0, 5.0, 1.0) AS rank
+			FROM users_fts
+			JOIN users u ON u.id = users_fts.rowid
+			WHERE users_fts MATCH ? AND `+notBlocked+`
+				AND (`+aboutVisible+`
+					OR u.id IN (SELECT rowid FROM users_fts WHERE users_fts MATCH ?))`)
+		args = append(args, aboutArgs...)
+		args = append(args, query)
+		args = append(args, blockArgs...)
+		args = append(args, aboutArgs...)
+		args = append(args, "{username first_name last_name} : ("+query+")")
+	}
+
+	if types[searchPost] {
+		visible, visibleArgs := policy.VisiblePostsCondition("p", viewerID)
+		branches = append(branches, `
+			SELECT 'post', p.id, 0, p.title, '', `+snippetSQL("posts_fts")+`, bm25(posts_fts, 2.0, 1.0)
+			FROM posts_fts
+			JOIN posts p ON p.id = posts_fts.rowid
+			WHERE posts_fts MATCH ? AND `+visible)
+		args = append(append(args, query), visibleArgs...)
+	}
+
+	if types[searchGroupPost] {
+		readable, readableArgs := policy.ReadableGroupCondition("gp.group_id", viewerID)
+		notBlocked, blockArgs := policy.NotBlockedCondition("gp.author_id", viewerID)
+		branches = append(branches, `
+			SELECT 'group_post', gp.id, gp.group_id, gp.title, '', `+snippetSQL("group_posts_fts")+`, bm25(group_posts_fts, 2.0, 1.0)
+			FROM group_posts_fts
+			JOIN group_posts gp ON gp.id = group_posts_fts.rowid
+			WHERE group_posts_fts MATCH ?
+				AND gp.status = 'published'
+				AND `+readable+`
+				AND `+notBlocked)
+		args = append(append(append(args, query), readableArgs...), blockArgs...)
+	}
+
+	if types[searchGroup] {
+		findable, findableArgs := policy.FindableGroupsCondition("g", viewerID)
+		branches = append(branches, `
+			SELECT 'group', g.id, g.id, g.title, '', `+snippetSQL("groups_fts")+`, bm25(groups_fts, 2.0, 1.0)
+			FROM groups_fts
+			JOIN groups g ON g.id = groups_fts.rowid
+			WHERE groups_fts MATCH ? AND `+findable)
+		args = append(append(args, query), findableArgs...)
+	}
+
+	if types[searchEvent] {
+		readable, readableArgs := policy.ReadableGroupCondition("e.group_id", viewerID)
+		branches = append(branches, `
+			SELECT 'event', e.id, e.group_id, e.title, CAST(e.event_date AS TEXT), `+snippetSQL("group_events_fts")+`, bm25(group_events_fts, 2.0, 1.0)
+			FROM group_events_fts
+			JOIN group_events e ON e.id = group_events_fts.rowid
+			WHERE group_events_fts MATCH ? AND `+readable)
+		args = append(append(args, query), readableArgs...)
+	}
+
+	return branches, args
+}
+
+// Search looks for users, posts, group posts, groups and events matching ?q,
+// best matches first. ?type narrows it to a comma separated list of result
+// types. Only what the user of the session could open is returned.
+func Search(w http.ResponseWriter, r *http.Request) {
+	query := ftsQuery(r.URL.Query().Get("q"))
+	if query == "" {
+		sendJSONError(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	types, err := parseSearchTypes(r.URL.Query().Get("type"))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := parsePagination(r, defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		sendJSONError(w, "Invalid pagination", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	branches, args := searchBranches(types, query, userID)
+	// The column names are given here since any branch can come first
+	rows, err := sqlite.DB.Query(`
+		WITH results(type, id, group_id, title, subtitle, snippet, rank) AS (`+strings.Join(branches, " UNION ALL ")+`)
+		SELECT type, id, group_id, title, subtitle, snippet, rank
+		FROM results
+		ORDER BY rank, type, id
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		// The index tables only exist when the driver was built with FTS5
+		if strings.Contains(err.Error(), "no such table") || strings.Contains(err.Error(), "no such module") {
+			log.Printf("Search is unavailable: %v", err)
+			sendJSONError(w, "Search is not available on this server", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Error searching: %v", err)
+		sendJSONError(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(&result.Type, &result.ID, &result.GroupID, &result.Title,
+			&result.Subtitle, &result.Snippet, &result.Rank); err != nil {
+			log.Printf("Error scanning search result: %v", err)
+			sendJSONError(w, "Search failed", http.StatusInternalServerError)
+			return
+		}
+		result.Snippet = markSnippet(result.Snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		sendJSONError(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/gorilla/websocket"
 	"social-network/models"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
 	"social-network/util"
 )
 
@@ -350,7 +351,7 @@ func processChatMessage(userID int, conn *websocket.Conn, messageType int, rawMe
 	chatMessage.SenderID = userID
 
 	// Save the message to the database
-	if err := SaveMessage(chatMessage); err != nil {
+	if err := SaveMessage(&chatMessage); err != nil {
 		log.Printf("Error saving message from user %d: %v", userID, err)
 
 		// Send error response back to the sender
@@ -368,6 +369,33 @@ func processChatMessage(userID int, conn *websocket.Conn, messageType int, rawMe
 		return
 	}
 
+	// Attachments are sent by media ID, add the resolved URL for both sides,
+	// along with the parsed hashtags and mentions
+	if chatMessage.MediaURL != "" || len(chatMessage.Entities) > 0 {
+		updatedMsgData := make(map[string]interface{})
+		if originalData, ok := msg.Data.(map[string]interface{}); ok {
+			for k, v := range originalData {
+				updatedMsgData[k] = v
+			}
+		}
+		updatedMsgData["chatId"] = chatMessage.ChatID
+		if chatMessage.MediaURL != "" {
+			updatedMsgData["mediaUrl"] = chatMessage.MediaURL
+		}
+		updatedMsgData["messageType"] = chatMessage.MessageType
+		if len(chatMessage.Entities) > 0 {
+			updatedMsgData["entities"] = chatMessage.Entities
+		}
+
+		updatedMsg := msg
+		updatedMsg.Data = updatedMsgData
+		if updatedRawMessage, err := json.Marshal(updatedMsg); err != nil {
+			log.Printf("Error adding media URL to message: %v", err)
+		} else {
+			rawMessage = updatedRawMessage
+		}
+	}
+
 	// Echo the message back to the sender with updated fields (like ID)
 	if err := conn.WriteMessage(messageType, rawMessage); err != nil {
 		log.Printf("Error echoing message to sender (user %d): %v", userID, err)
@@ -417,18 +445,8 @@ func getOrCreateDirectChat(userID, recipientID int) (int, error) {
 		return 0, fmt.Errorf("database error: %w", err)
 	}
 
-	// No existing chat found, check if there's a follow relationship
-	var followExists bool
-	err = sqlite.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM followers 
-			WHERE ((follower_id = ? AND followed_id = ?) 
-			OR (follower_id = ? AND followed_id = ?))
-			AND status = 'accepted'
-		)`,
-		userID, recipientID, recipientID, userID,
-	).Scan(&followExists)
-
+	// No existing chat found, check the messaging policy
+	followExists, err := policy.CanUsersMessage(sqlite.DB, userID, recipientID)
 	if err != nil {
 		return 0, fmt.Errorf("database error checking follow status: %w", err)
 	}
@@ -477,7 +495,7 @@ func getOrCreateDirectChat(userID, recipientID int) (int, error) {
 }
 
 // SaveMessage saves a message to the database and returns its ID
-func SaveMessage(message models.ChatMessage) error {
+func SaveMessage(message *models.ChatMessage) error {
 	// Check if the message is for a direct chat
 	var chatType string
 	err := sqlite.DB.QueryRow("SELECT type FROM chats WHERE id = ?", message.ChatID).Scan(&chatType)
@@ -498,17 +516,8 @@ func SaveMessage(message models.ChatMessage) error {
 			return fmt.Errorf("failed to find other participant: %w", err)
 		}
 
-		// Check if at least one user follows the other
-		var followExists bool
-		err = sqlite.DB.QueryRow(`
-			SELECT EXISTS (
-				SELECT 1 FROM followers 
-				WHERE ((follower_id = ? AND followed_id = ?) 
-				OR (follower_id = ? AND followed_id = ?))
-				AND status = 'accepted'
-			)
-		`, message.SenderID, otherUserID, otherUserID, message.SenderID).Scan(&followExists)
-
+		// Check the messaging policy, at least one user has to follow the other
+		followExists, err := policy.CanUsersMessage(sqlite.DB, message.SenderID, otherUserID)
 		if err != nil {
 			return fmt.Errorf("database error: %w", err)
 		}
@@ -518,17 +527,30 @@ func SaveMessage(message models.ChatMessage) error {
 		}
 	}
 
+	// Attachments have to be chat uploads owned by the sender
+	var mediaID interface{}
+	message.MessageType = "text"
+	if message.MediaID != 0 {
+		url, err := chatMediaURL(message.MediaID, message.SenderID)
+		if err != nil {
+			return err
+		}
+		mediaID = message.MediaID
+		message.MediaURL = url
+		message.MessageType = "image"
+	}
+
 	// Insert the message into the database
 	statement, err := sqlite.DB.Prepare(`
-		INSERT INTO chat_messages (chat_id, sender_id, content, created_at)
-		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO chat_messages (chat_id, sender_id, content, message_type, media_id, created_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 	`)
 	if err != nil {
 		return fmt.Errorf("database error: %w", err)
 	}
 	defer statement.Close()
 
-	result, err := statement.Exec(message.ChatID, message.SenderID, message.Content)
+	result, err := statement.Exec(message.ChatID, message.SenderID, message.Content, message.MessageType, mediaID)
 	if err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
@@ -540,6 +562,16 @@ func SaveMessage(message models.ChatMessage) error {
 
 	message.ID = int(messageID)
 
+	// The recipient of a direct message is notified of it anyway, so only
+	// mentions in other chats get their own notification
+	mentioned, err := indexEntities(sqlite.DB, contentChatMessage, message.ID, message.SenderID, message.Content)
+	if err != nil {
+		log.Printf("Error indexing message %d: %v", message.ID, err)
+	} else if chatType != "direct" {
+		notifyMentions(mentioned, contentChatMessage, message.ID, message.SenderID, 0, message.Content, chatAudience(message.ChatID))
+	}
+	message.Entities = resolveEntities(message.Content)
+
 	return nil
 }
 
@@ -635,10 +667,56 @@ func processGroupChatMessage(userID int, conn *websocket.Conn, messageType int,
 		return
 	}
 
+	// Muted members read the chat but don't write to it
+	if err := requireNotMuted(sqlite.DB, groupID, userID); err != nil {
+		message := "Failed to check member status"
+		if statusErr, ok := err.(*statusError); ok {
+			message = statusErr.message
+		} else {
+			log.Printf("Error checking member status: %v", err)
+		}
+		errorResponse := models.WebSocketMessage{
+			Type: "error",
+			Data: map[string]interface{}{
+				"message": message,
+				"code":    "group_muted",
+			},
+		}
+
+		if err := conn.WriteJSON(errorResponse); err != nil {
+			log.Printf("Error sending error response: %v", err)
+		}
+		return
+	}
+
+	// Attachments have to be chat uploads owned by the sender
+	var mediaID interface{}
+	messageKind := "text"
+	groupMessage.Media = ""
+	if groupMessage.MediaID != 0 {
+		url, err := chatMediaURL(groupMessage.MediaID, userID)
+		if err != nil {
+			errorResponse := models.WebSocketMessage{
+				Type: "error",
+				Data: map[string]interface{}{
+					"message": err.Error(),
+					"code":    "message_save_failed",
+				},
+			}
+			if err := conn.WriteJSON(errorResponse); err != nil {
+				log.Printf("Error sending error response: %v", err)
+			}
+			return
+		}
+		mediaID = groupMessage.MediaID
+		messageKind = "image"
+		groupMessage.Media = url
+	}
+
 	// Save the message
 	stmt, err := sqlite.DB.Prepare(`
-		INSERT INTO chat_messages (chat_id, sender_id, content, created_at)
-		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO chat_messages (chat_id, sender_id, content, message_type, media_id, created_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 	`)
 	if err != nil {
 		log.Printf("Error preparing statement: %v", err)
@@ -650,6 +728,8 @@ func processGroupChatMessage(userID int, conn *websocket.Conn, messageType int,
 		groupMessage.ChatId,
 		groupMessage.UserID,
 		groupMessage.Content,
+		messageKind,
+		mediaID,
 	)
 	if err != nil {
 		log.Printf("Error saving group message: %v", err)
@@ -661,7 +741,13 @@ func processGroupChatMessage(userID int, conn *websocket.Conn, messageType int,
 		log.Printf("Error getting message ID: %v", err)
 	} else {
 		groupMessage.ID = int(messageID)
+		mentioned, err := indexEntities(sqlite.DB, contentChatMessage, groupMessage.ID, userID, groupMessage.Content)
+		if err != nil {
+			log.Printf("Error indexing group message %d: %v", groupMessage.ID, err)
+		}
+		notifyMentions(mentioned, contentChatMessage, groupMessage.ID, userID, groupID, groupMessage.Content, chatAudience(groupMessage.ChatId))
 	}
+	groupMessage.Entities = resolveEntities(groupMessage.Content)
 
 	// Get user info to include in the message
 	var firstName, lastName, avatar string
@@ -736,9 +822,19 @@ func processGroupChatMessage(userID int, conn *websocket.Conn, messageType int,
 		socketManager.Mu.RUnlock()
 
 		if !isOnline {
+			// Members who muted the group still get the message, just
+			// without a notification
+			muted, err := policy.HasMutedGroup(sqlite.DB, memberID, groupID)
+			if err != nil {
+				log.Printf("Error checking group mute for member %d: %v", memberID, err)
+			}
+			if muted {
+				continue
+			}
+
 			// Create notification for offline member
 			content := fmt.Sprintf("%s: %s", groupMessage.UserName, truncateMessage(groupMessage.Content))
-			_, err := sqlite.DB.Exec(`
+			_, err = sqlite.DB.Exec(`
 				INSERT INTO notifications (
 					type, content, user_id, group_id, from_user_id, is_read, created_at
 				) VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
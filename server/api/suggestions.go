@@ -0,0 +1,266 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"social-network/pkg/db/sqlite"
+	"social-network/pkg/policy"
+)
+
+// SuggestionsCacheTTL is how long the ranked suggestions of a user are reused
+// before the graph is walked again. SUGGESTIONS_CACHE_TTL overrides it.
+var SuggestionsCacheTTL = 10 * time.Minute
+
+func init() {
+	if value := os.Getenv("SUGGESTIONS_CACHE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			log.Printf("Ignoring invalid SUGGESTIONS_CACHE_TTL %q", value)
+			return
+		}
+		SuggestionsCacheTTL = ttl
+	}
+}
+
+const (
+	defaultSuggestionsLimit = 10
+	maxSuggestionsLimit     = 50
+	// maxCachedSuggestions bounds how many candidates are kept per user
+	maxCachedSuggestions = 200
+)
+
+// Weights of the signals a suggestion is ranked by
+const (
+	mutualFollowWeight = 3
+	sharedGroupWeight  = 2
+	sharedEventWeight  = 1
+)
+
+// Suggestion is a user someone might want to follow, with the reasons why
+type Suggestion struct {
+	UserID        int      `json:"userId"`
+	Username      string   `json:"username"`
+	FirstName     string   `json:"firstName"`
+	LastName      string   `json:"lastName"`
+	Avatar        string   `json:"avatar"`
+	IsPrivate     bool     `json:"isPrivate"`
+	MutualFollows int      `json:"mutualFollows"`
+	SharedGroups  int      `json:"sharedGroups"`
+	SharedEvents  int      `json:"sharedEvents"`
+	Score         int      `json:"score"`
+	Reasons       []string `json:"reasons"`
+}
+
+// suggestionsCache keeps the ranked candidates of each user for a while.
+// Follows and blocks made since are filtered out when a page is read, so a
+// stale entry only misses new candidates.
+type suggestionsCache struct {
+	mu      sync.Mutex
+	entries map[int]suggestionsEntry
+}
+
+type suggestionsEntry struct {
+	suggestions []Suggestion
+	expires     time.Time
+}
+
+var suggestions = suggestionsCache{entries: make(map[int]suggestionsEntry)}
+
+// get returns the ranked candidates of a user, computing them when the cached
+// ones are missing or expired
+func (c *suggestionsCache) get(userID int) ([]Suggestion, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.suggestions, nil
+	}
+
+	ranked, err := rankSuggestions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Drop expired entries while we hold the lock so the map doesn't grow
+	// with users who stopped asking
+	now := time.Now()
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[userID] = suggestionsEntry{suggestions: ranked, expires: now.Add(SuggestionsCacheTTL)}
+	return ranked, nil
+}
+
+// rankSuggestions walks the social graph around a user. Candidates are the
+// users followed by the people they follow, the members of their groups and
+// the users going to the same events. They are ranked by a weighted sum of
+// those signals. Users they already follow or requested to follow, and users
+// blocked either way, are left out before the best ones are kept.
+func rankSuggestions(userID int) ([]Suggestion, error) {
+	notBlocked, blockArgs := policy.NotBlockedCondition("u.id", userID)
+	args := []interface{}{userID, userID, userID, userID, userID}
+	args = append(args, blockArgs...)
+	args = append(args, mutualFollowWeight, sharedGroupWeight, sharedEventWeight, maxCachedSuggestions)
+
+	rows, err := sqlite.DB.Query(`
+		WITH
+		following AS (
+			SELECT followed_id AS id FROM followers
+			WHERE follower_id = ? AND status = 'accepted'
+		),
+		mutual AS (
+			SELECT f.followed_id AS candidate, COUNT(DISTINCT f.follower_id) AS n
+			FROM followers f
+			JOIN following fo ON fo.id = f.follower_id
+			WHERE f.status = 'accepted'
+			GROUP BY f.followed_id
+		),
+		shared_groups AS (
+			SELECT gm.user_id AS candidate, COUNT(DISTINCT gm.group_id) AS n
+			FROM group_members gm
+			JOIN group_members mine ON mine.group_id = gm.group_id AND mine.user_id = ?
+			GROUP BY gm.user_id
+		),
+		shared_events AS (
+			SELECT r.user_id AS candidate, COUNT(DISTINCT r.event_id) AS n
+			FROM group_event_RSVP r
+			JOIN group_event_RSVP mine ON mine.event_id = r.event_id
+				AND mine.user_id = ? AND mine.rsvp_status = 'going'
+			WHERE r.rsvp_status = 'going'
+			GROUP BY r.user_id
+		),
+		candidates AS (
+			SELECT candidate FROM mutual
+			UNION SELECT candidate FROM shared_groups
+			UNION SELECT candidate FROM shared_events
+		)
+		SELECT u.id, u.username, u.first_name, u.last_name, COALESCE(u.avatar, ''), u.is_private,
+			COALESCE(m.n, 0), COALESCE(g.n, 0), COALESCE(e.n, 0)
+		FROM candidates c
+		JOIN users u ON u.id = c.candidate
+		LEFT JOIN mutual m ON m.candidate = c.candidate
+		LEFT JOIN shared_groups g ON g.candidate = c.candidate
+		LEFT JOIN shared_events e ON e.candidate = c.candidate
+		WHERE u.id != ?
+			AND NOT EXISTS(SELECT 1 FROM followers x WHERE x.follower_id = ? AND x.followed_id = u.id)
+			AND `+notBlocked+`
+		ORDER BY COALESCE(m.n, 0) * ? + COALESCE(g.n, 0) * ? + COALESCE(e.n, 0) * ? DESC, u.id
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ranked := []Suggestion{}
+	for rows.Next() {
+		var s Suggestion
+		if err := rows.Scan(&s.UserID, &s.Username, &s.FirstName, &s.LastName, &s.Avatar, &s.IsPrivate,
+			&s.MutualFollows, &s.SharedGroups, &s.SharedEvents); err != nil {
+			return nil, err
+		}
+		s.Score = s.MutualFollows*mutualFollowWeight + s.SharedGroups*sharedGroupWeight + s.SharedEvents*sharedEventWeight
+		s.Reasons = suggestionReasons(s)
+		ranked = append(ranked, s)
+	}
+	return ranked, rows.Err()
+}
+
+// suggestionReasons explains a suggestion, strongest signal first
+func suggestionReasons(s Suggestion) []string {
+	var reasons []string
+	add := func(n int, singular, plural string) {
+		switch {
+		case n == 1:
+			reasons = append(reasons, "1 "+singular)
+		case n > 1:
+			reasons = append(reasons, fmt.Sprintf("%d %s", n, plural))
+		}
+	}
+	add(s.MutualFollows, "mutual follower", "mutual followers")
+	add(s.SharedGroups, "shared group", "shared groups")
+	add(s.SharedEvents, "shared event", "shared events")
+	return reasons
+}
+
+// excludedFromSuggestions returns the users that can't be suggested to
+// userID right now: the ones they follow or requested to follow, and the ones
+// blocked either way. Ranking already leaves them out, this catches the
+// follows and blocks made after the cached ranking was computed.
+func excludedFromSuggestions(userID int) (map[int]bool, error) {
+	rows, err := sqlite.DB.Query(`
+		SELECT followed_id FROM followers WHERE follower_id = ?
+		UNION SELECT blocked_id FROM user_blocks WHERE blocker_id = ?
+		UNION SELECT blocker_id FROM user_blocks WHERE blocked_id = ?`,
+		userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	excluded := map[int]bool{userID: true}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		excluded[id] = true
+	}
+	return excluded, rows.Err()
+}
+
+// GetFollowSuggestions returns a page of the users the user of the session
+// might want to follow, best first
+func GetFollowSuggestions(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r, defaultSuggestionsLimit, maxSuggestionsLimit)
+	if err != nil {
+		sendJSONError(w, "Invalid pagination", http.StatusBadRequest)
+		return
+	}
+
+	userID := sessionUserID(w, r)
+	if userID == 0 {
+		return
+	}
+
+	ranked, err := suggestions.get(userID)
+	if err != nil {
+		log.Printf("Error ranking suggestions for user %d: %v", userID, err)
+		sendJSONError(w, "Failed to get suggestions", http.StatusInternalServerError)
+		return
+	}
+
+	excluded, err := excludedFromSuggestions(userID)
+	if err != nil {
+		log.Printf("Error getting suggestion exclusions for user %d: %v", userID, err)
+		sendJSONError(w, "Failed to get suggestions", http.StatusInternalServerError)
+		return
+	}
+
+	eligible := make([]Suggestion, 0, len(ranked))
+	for _, s := range ranked {
+		if !excluded[s.UserID] {
+			eligible = append(eligible, s)
+		}
+	}
+
+	page := []Suggestion{}
+	if offset < len(eligible) {
+		page = eligible[offset:min(offset+limit, len(eligible))]
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"suggestions": page,
+		"total":       len(eligible),
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
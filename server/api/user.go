@@ -8,8 +8,11 @@ import (
 	"net/http"
 	"social-network/models"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/media"
+	"social-network/pkg/policy"
 	"social-network/util"
 	"strconv"
+	"strings"
 )
 
 type Profile struct {
@@ -57,25 +60,59 @@ func UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Insert the post into the database
-	result, err := sqlite.DB.Exec(
+	// Avatars are uploaded through PATCH /profile, only those can be set here
+	if profile.Image != "" && !strings.HasPrefix(profile.Image, media.URL(media.CategoryAvatar, "")) {
+		http.Error(w, "Invalid avatar", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var wasPrivate bool
+	if err := tx.QueryRow("SELECT is_private FROM users WHERE id = ?", userID).Scan(&wasPrivate); err != nil {
+		http.Error(w, "Failed to get user information", http.StatusInternalServerError)
+		return
+	}
+
+	// Update the profile
+	_, err = tx.Exec(
 		"update users set avatar = ?, about_me = ?, is_private = ? where id = ?",
 		profile.Image, profile.Description, profile.Privacy, userID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Failed to create post",
+			"error": "Failed to update profile",
 		})
-		log.Printf("Error creating post: %v", err)
+		log.Printf("Error updating profile: %v", err)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Follow request not found or already processed", http.StatusBadRequest)
+	// Switching privacy settles pending requests or starts a follower review
+	var transition privacyTransition
+	if wasPrivate != profile.Privacy {
+		transition, err = applyPrivacyTransition(tx, userID, profile.Privacy)
+		if err != nil {
+			log.Printf("Error applying privacy change: %v", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
 		return
 	}
 
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Profile updated",
+		"isPrivate":  profile.Privacy,
+		"transition": transition,
+	})
 }
 
 func UserProfile(w http.ResponseWriter, r *http.Request) {
@@ -111,35 +148,29 @@ func UserProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var canView bool = true
-	if currentUserID != userID {
-		// Check if the user is private
-		var isPrivate bool
-		err = sqlite.DB.QueryRow("SELECT is_private FROM users WHERE id = ?", userID).Scan(&isPrivate)
-		if err != nil {
-			http.Error(w, "Error getting user privacy settings", http.StatusInternalServerError)
+	// Private profiles only show their details and follow lists to accepted
+	// followers
+	canView, err := policy.CanUserViewProfile(sqlite.DB, userID, currentUserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User does not exist", http.StatusBadRequest)
 			return
 		}
-		//check if currentUserID follows the user
-		if isPrivate {
-
-			// Check if the user is following the user by the status of the follow request
-			var followStatus string
-			err = sqlite.DB.QueryRow("SELECT status FROM followers WHERE follower_id = ? AND followed_id = ?", currentUserID, userID).Scan(&followStatus)
-			if err != nil {
-				canView = false
-			}
-			if followStatus != "accepted" {
-				canView = false
-			}
-		}
+		http.Error(w, "Error getting user privacy settings", http.StatusInternalServerError)
+		return
+	}
+	canSeeFollowers, err := policy.CanUserSeeFollowers(sqlite.DB, userID, currentUserID)
+	if err != nil {
+		http.Error(w, "Error getting user privacy settings", http.StatusInternalServerError)
+		return
 	}
 
 	var userInfo models.User
 	var avatar sql.NullString // Handle nullable avatar
 	var aboutMe sql.NullString
+	var cover sql.NullString
 	if err := sqlite.DB.QueryRow(
-		"SELECT id, email, username, first_name, last_name, date_of_birth, avatar, about_me, is_private, created_at FROM users WHERE id = ?",
+		"SELECT id, email, username, first_name, last_name, date_of_birth, avatar, about_me, cover_image, is_private, created_at FROM users WHERE id = ?",
 		userID).Scan(
 		&userInfo.ID,
 		&userInfo.Email,
@@ -149,6 +180,7 @@ func UserProfile(w http.ResponseWriter, r *http.Request) {
 		&userInfo.DateOfBirth,
 		&avatar,
 		&aboutMe,
+		&cover,
 		&userInfo.IsPrivate,
 		&userInfo.CreatedAt); err != nil {
 		if err == sql.ErrNoRows {
@@ -163,11 +195,20 @@ func UserProfile(w http.ResponseWriter, r *http.Request) {
 	// Check if the avatar is valid (i.e., not null)
 	if avatar.Valid {
 		userInfo.Avatar = avatar.String // Use the value if it's valid
+		userInfo.AvatarThumbnails = avatarThumbnails(userInfo.Avatar)
 	}
 
 	if aboutMe.Valid {
 		userInfo.AboutMe = aboutMe.String
 	}
+	userInfo.CoverImage = cover.String
+
+	// Each optional field can have a narrower audience than the profile
+	if err := hideProfileFields(&userInfo, currentUserID); err != nil {
+		log.Printf("Error applying field visibility: %v", err)
+		http.Error(w, "Error getting user privacy settings", http.StatusInternalServerError)
+		return
+	}
 
 	// If the user cannot view the full profile, restrict the details
 	if !canView {
@@ -207,7 +248,7 @@ func UserProfile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if canView {
+	if canSeeFollowers {
 		//get the followers that follows the user
 		rows, err := sqlite.DB.Query("SELECT users.id, users.username, users.avatar, users.first_name, users.last_name FROM users INNER JOIN followers f ON users.id = f.follower_id WHERE f.followed_id = ? AND f.status = 'accepted'", userID)
 		if err != nil {
@@ -253,13 +294,39 @@ func UserProfile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Counts are shown on every profile, even when the lists are private
+	var followersCount, followingCount int
+	err = sqlite.DB.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM followers WHERE followed_id = ? AND status = 'accepted'),
+			(SELECT COUNT(*) FROM followers WHERE follower_id = ? AND status = 'accepted')`,
+		userID, userID).Scan(&followersCount, &followingCount)
+	if err != nil {
+		http.Error(w, "Error counting followers", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"user":           userInfo,
+		"followers":      followers,
+		"following":      following,
+		"requests":       requests,
+		"followersCount": followersCount,
+		"followingCount": followingCount,
+	}
+
+	// Users see the audiences they chose for their own fields
+	if currentUserID == userID {
+		audiences, err := policy.LoadFieldAudiences(sqlite.DB, userID)
+		if err != nil {
+			http.Error(w, "Error getting user privacy settings", http.StatusInternalServerError)
+			return
+		}
+		response["fieldVisibility"] = audiences
+	}
+
 	// Encode the userInfo and followers/following JSON and send it as a response
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"user":      userInfo,
-		"followers": followers,
-		"following": following,
-		"requests":  requests,
-	}); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "Error sending data", http.StatusInternalServerError)
 	}
 
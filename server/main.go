@@ -9,6 +9,8 @@ import (
 	"social-network/api"
 	"social-network/middleware"
 	"social-network/pkg/db/sqlite"
+	"social-network/pkg/media"
+	"social-network/pkg/storage"
 	"social-network/util"
 )
 
@@ -55,6 +57,11 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	// Pick the media storage backend
+	if err := media.Configure(); err != nil {
+		log.Fatal("Failed to configure media storage:", err)
+	}
+
 	var arg string
 
 	// check if an argument is passed
@@ -83,6 +90,25 @@ func main() {
 	} else if strings.EqualFold(arg, "show-data") {
 		sqlite.PrintDatabaseContent()
 		return
+	} else if strings.EqualFold(arg, "migrate-media") {
+		// copy uploads between storage backends, e.g. migrate-media local s3
+		if len(os.Args) < 4 {
+			log.Fatal("Usage: migrate-media <from> <to> (backends: local, s3)")
+		}
+		src, err := media.OpenStore(os.Args[2])
+		if err != nil {
+			log.Fatalf("Error opening source storage: %v", err)
+		}
+		dst, err := media.OpenStore(os.Args[3])
+		if err != nil {
+			log.Fatalf("Error opening destination storage: %v", err)
+		}
+		copied, skipped, err := storage.Copy(src, dst, "")
+		if err != nil {
+			log.Fatalf("Error migrating media after %d files: %v", copied, err)
+		}
+		log.Printf("Media migration done: %d copied, %d already present", copied, skipped)
+		return
 	}
 
 	mux := http.NewServeMux()
@@ -102,6 +128,9 @@ func main() {
 
 	mux.Handle("POST /comments", authMiddleware(http.HandlerFunc(api.CreateComment)))
 	mux.Handle("GET /comments/{postID}", authMiddleware(http.HandlerFunc(api.GetComments)))
+	mux.Handle("GET /comments/{id}/replies", authMiddleware(http.HandlerFunc(api.GetCommentReplies)))
+	mux.Handle("PUT /comments/{id}", authMiddleware(http.HandlerFunc(api.UpdateComment)))
+	mux.Handle("DELETE /comments/{id}", authMiddleware(http.HandlerFunc(api.DeleteComment)))
 
 	//explore page
 	mux.Handle("POST /explore", authMiddleware(http.HandlerFunc(api.GetExplore)))
@@ -119,11 +148,29 @@ func main() {
 	mux.Handle("GET /groups/{id}/members/role", authMiddleware(http.HandlerFunc(api.GetMemberRole)))
 	mux.Handle("PUT /groups/{id}/members/{memberId}/role", authMiddleware(http.HandlerFunc(api.UpdateMemberRole)))
 	mux.Handle("DELETE /groups/{id}/members/{memberId}", authMiddleware(http.HandlerFunc(api.RemoveMember)))
+	mux.Handle("POST /groups/{id}/members/{memberId}/mute", authMiddleware(http.HandlerFunc(api.MuteGroupMember)))
+	mux.Handle("DELETE /groups/{id}/members/{memberId}/mute", authMiddleware(http.HandlerFunc(api.UnmuteGroupMember)))
+	mux.Handle("GET /groups/{id}/bans", authMiddleware(http.HandlerFunc(api.GetGroupBans)))
+	mux.Handle("POST /groups/{id}/bans", authMiddleware(http.HandlerFunc(api.BanGroupMember)))
+	mux.Handle("DELETE /groups/{id}/bans/{userId}", authMiddleware(http.HandlerFunc(api.UnbanGroupMember)))
+	mux.Handle("GET /groups/{id}/permissions", authMiddleware(http.HandlerFunc(api.GetGroupPermissions)))
+	mux.Handle("PUT /groups/{id}/permissions", authMiddleware(http.HandlerFunc(api.UpdateGroupPermissions)))
+	mux.Handle("POST /groups/{id}/leave", authMiddleware(http.HandlerFunc(api.GroupLeave)))
+
+	// Group ownership transfer
+	mux.Handle("GET /groups/{id}/ownership-transfer", authMiddleware(http.HandlerFunc(api.GetGroupOwnershipTransfer)))
+	mux.Handle("POST /groups/{id}/ownership-transfer", authMiddleware(http.HandlerFunc(api.OfferGroupOwnership)))
+	mux.Handle("POST /groups/{id}/ownership-transfer/{transferId}/{action}", authMiddleware(http.HandlerFunc(api.RespondToOwnershipTransfer)))
 
 	// Group invitation routes
 	mux.Handle("POST /groups/{id}/invitations", authMiddleware(http.HandlerFunc(api.InviteToGroup)))
 	mux.Handle("GET /groups/{id}/invitations/status", authMiddleware(http.HandlerFunc(api.GetInvitationStatus)))
 	mux.Handle("POST /groups/{id}/invitations/{invitationId}/{action}", authMiddleware(http.HandlerFunc(api.HandleInvitation)))
+	mux.Handle("GET /groups/{id}/invite-links", authMiddleware(http.HandlerFunc(api.GetGroupInviteLinks)))
+	mux.Handle("POST /groups/{id}/invite-links", authMiddleware(http.HandlerFunc(api.CreateGroupInviteLink)))
+	mux.Handle("DELETE /groups/{id}/invite-links/{linkId}", authMiddleware(http.HandlerFunc(api.RevokeGroupInviteLink)))
+	mux.Handle("POST /invite-links/{token}/redeem", authMiddleware(http.HandlerFunc(api.RedeemGroupInviteLink)))
+	mux.Handle("GET /groups/{id}/audit-log", authMiddleware(http.HandlerFunc(api.GetGroupAuditLog)))
 
 	// Group join request routes
 	mux.Handle("GET /groups/{id}/join-requests", authMiddleware(http.HandlerFunc(api.GetGroupRequests)))
@@ -138,8 +185,18 @@ func main() {
 	// Group posts and comments
 	mux.Handle("GET /groups/{id}/posts", authMiddleware(http.HandlerFunc(api.GetGroupPost)))
 	mux.Handle("POST /groups/{id}/posts", authMiddleware(http.HandlerFunc(api.CreateGroupPost)))
+	mux.Handle("PUT /groups/{id}/posts/{postId}", authMiddleware(http.HandlerFunc(api.UpdateGroupPost)))
+	mux.Handle("DELETE /groups/{id}/posts/{postId}", authMiddleware(http.HandlerFunc(api.DeleteGroupPost)))
+	mux.Handle("POST /groups/{id}/posts/{postId}/pin", authMiddleware(http.HandlerFunc(api.PinGroupPost)))
+	mux.Handle("DELETE /groups/{id}/posts/{postId}/pin", authMiddleware(http.HandlerFunc(api.UnpinGroupPost)))
+	mux.Handle("POST /groups/{id}/posts/{postId}/announce", authMiddleware(http.HandlerFunc(api.AnnounceGroupPost)))
+	mux.Handle("GET /groups/{id}/post-queue", authMiddleware(http.HandlerFunc(api.GetGroupPostQueue)))
+	mux.Handle("POST /groups/{id}/post-queue/{postId}/{action}", authMiddleware(http.HandlerFunc(api.ReviewGroupPost)))
 	mux.Handle("GET /groups/{id}/posts/{postId}/comments", authMiddleware(http.HandlerFunc(api.GetGroupPostComments)))
 	mux.Handle("POST /groups/{id}/posts/{postId}/comments", authMiddleware(http.HandlerFunc(api.CreateGroupPostComment)))
+	mux.Handle("GET /groups/{id}/posts/{postId}/comments/{commentId}/replies", authMiddleware(http.HandlerFunc(api.GetGroupCommentReplies)))
+	mux.Handle("PUT /groups/{id}/posts/{postId}/comments/{commentId}", authMiddleware(http.HandlerFunc(api.UpdateGroupPostComment)))
+	mux.Handle("DELETE /groups/{id}/posts/{postId}/comments/{commentId}", authMiddleware(http.HandlerFunc(api.DeleteGroupPostComment)))
 
 	mux.Handle("POST /follow", authMiddleware(http.HandlerFunc(api.FollowUser)))
 	mux.Handle("POST /unfollow", authMiddleware(http.HandlerFunc(api.UnfollowUser)))
@@ -147,12 +204,41 @@ func main() {
 	mux.Handle("POST /user/follow-status", authMiddleware(http.HandlerFunc(api.FollowStatus)))
 
 	mux.Handle("GET /follower/{userID}", authMiddleware(http.HandlerFunc(api.GetFollowers)))
+	mux.Handle("GET /following/{userID}", authMiddleware(http.HandlerFunc(api.GetFollowing)))
+	mux.Handle("GET /follow/suggestions", authMiddleware(http.HandlerFunc(api.GetFollowSuggestions)))
+	mux.Handle("GET /follow/requests", authMiddleware(http.HandlerFunc(api.GetFollowRequests)))
+	mux.Handle("DELETE /follow/requests/{userID}", authMiddleware(http.HandlerFunc(api.CancelFollowRequest)))
+	mux.Handle("DELETE /followers/{userID}", authMiddleware(http.HandlerFunc(api.RemoveFollower)))
+	mux.Handle("POST /followers/remove", authMiddleware(http.HandlerFunc(api.RemoveFollowers)))
+
+	mux.Handle("GET /user/blocked", authMiddleware(http.HandlerFunc(api.GetBlockedUsers)))
+	mux.Handle("POST /user/{userID}/block", authMiddleware(http.HandlerFunc(api.BlockUser)))
+	mux.Handle("DELETE /user/{userID}/block", authMiddleware(http.HandlerFunc(api.UnblockUser)))
+
+	mux.Handle("GET /audience-lists", authMiddleware(http.HandlerFunc(api.GetAudienceLists)))
+	mux.Handle("POST /audience-lists", authMiddleware(http.HandlerFunc(api.CreateAudienceList)))
+	mux.Handle("GET /audience-lists/{id}", authMiddleware(http.HandlerFunc(api.GetAudienceList)))
+	mux.Handle("PUT /audience-lists/{id}", authMiddleware(http.HandlerFunc(api.RenameAudienceList)))
+	mux.Handle("DELETE /audience-lists/{id}", authMiddleware(http.HandlerFunc(api.DeleteAudienceList)))
+	mux.Handle("POST /audience-lists/{id}/members", authMiddleware(http.HandlerFunc(api.AddAudienceListMembers)))
+	mux.Handle("DELETE /audience-lists/{id}/members/{userId}", authMiddleware(http.HandlerFunc(api.RemoveAudienceListMember)))
+
+	mux.Handle("GET /mutes", authMiddleware(http.HandlerFunc(api.GetMutes)))
+	mux.Handle("POST /user/{userID}/mute", authMiddleware(http.HandlerFunc(api.MuteUser)))
+	mux.Handle("DELETE /user/{userID}/mute", authMiddleware(http.HandlerFunc(api.UnmuteUser)))
+	mux.Handle("POST /groups/{id}/mute", authMiddleware(http.HandlerFunc(api.MuteGroup)))
+	mux.Handle("DELETE /groups/{id}/mute", authMiddleware(http.HandlerFunc(api.UnmuteGroup)))
+
+	mux.Handle("GET /search", authMiddleware(http.HandlerFunc(api.Search)))
+	mux.Handle("GET /hashtags/trending", authMiddleware(http.HandlerFunc(api.GetTrendingHashtags)))
+	mux.Handle("GET /hashtags/{tag}/posts", authMiddleware(http.HandlerFunc(api.GetHashtagPosts)))
 
 	mux.Handle("GET /contact/{userID}", authMiddleware(http.HandlerFunc(api.GetContact)))
 	mux.Handle("GET /messages/{userId}/{contactId}", authMiddleware(http.HandlerFunc(api.GetMessages)))
 
 	mux.Handle("GET /user/{userID}", authMiddleware(http.HandlerFunc(api.UserProfile)))
 	mux.Handle("POST /updateProfile", authMiddleware(http.HandlerFunc(api.UpdateProfile)))
+	mux.Handle("PATCH /profile", authMiddleware(http.HandlerFunc(api.PatchProfile)))
 	mux.Handle("POST /user/getPosts", authMiddleware(http.HandlerFunc(api.GetMyPosts)))
 
 	mux.Handle("/ws", authMiddleware(http.HandlerFunc(api.WebSocketHandler)))
@@ -160,7 +246,14 @@ func main() {
 	mux.Handle("GET /notifications", authMiddleware(http.HandlerFunc(api.GetNotifications)))
 	mux.Handle("POST /notifications/{id}/read", authMiddleware(http.HandlerFunc(api.MarkNotificationAsRead)))
 
+	// Media uploads
+	mux.Handle("POST /chats/{id}/media", authMiddleware(http.HandlerFunc(api.UploadChatMedia)))
+	mux.Handle("POST /user/avatar", authMiddleware(http.HandlerFunc(api.UploadAvatar)))
+
+	// Media is served without authMiddleware so signed URLs work without a
+	// cookie, the handlers check the session or signature themselves
 	mux.Handle("GET /uploads/group_posts/{filename}", http.HandlerFunc(api.ServeGroupPostMedia))
+	mux.Handle("GET /uploads/{category}/{filename}", http.HandlerFunc(api.ServeMedia))
 
 	mux.Handle("POST /chat/direct", http.HandlerFunc(api.CreateOrGetDirectChat))
 	mux.Handle("GET /chats", http.HandlerFunc(api.GetUserChats))
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// AudienceList is a named group of users a private post can be shared with
+type AudienceList struct {
+	ID          int                  `json:"id"`
+	OwnerID     int                  `json:"owner_id"`
+	Name        string               `json:"name"`
+	MemberCount int                  `json:"member_count"`
+	Members     []AudienceListMember `json:"members,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+}
+
+type AudienceListMember struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Avatar    string    `json:"avatar"`
+	AddedAt   time.Time `json:"added_at"`
+}
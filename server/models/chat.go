@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"social-network/pkg/entities"
 )
 
 // ChatMessage represents a message in a chat
@@ -13,10 +15,14 @@ type ChatMessage struct {
 	Content     string    `json:"content"`
 	Status      string    `json:"status"`      // Added Status to match the schema
 	MessageType string    `json:"messageType"` // Added MessageType to match the schema
+	MediaID     int       `json:"mediaId,omitempty"`
+	MediaURL    string    `json:"mediaUrl,omitempty"`
 	CreatedAt   time.Time `json:"createdAt"`
 	// Additional fields for frontend
 	SenderName   string `json:"senderName,omitempty"`
 	SenderAvatar string `json:"senderAvatar,omitempty"`
+	// Entities are the hashtags and mentions in Content
+	Entities []entities.Entity `json:"entities,omitempty"`
 }
 
 // GroupMessage represents a message in a group chat
@@ -26,8 +32,11 @@ type GroupMessage struct {
 	UserID    int       `json:"userId"`
 	Content   string    `json:"content"`
 	Media     string    `json:"media,omitempty"`
+	MediaID   int       `json:"mediaId,omitempty"`
 	CreatedAt time.Time `json:"createdAt"`
 	// Additional fields for frontend
 	UserName   string `json:"userName,omitempty"`
 	UserAvatar string `json:"userAvatar,omitempty"`
+	// Entities are the hashtags and mentions in Content
+	Entities []entities.Entity `json:"entities,omitempty"`
 }
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"social-network/pkg/entities"
+)
 
 type Comment struct {
 	ID           uint      `json:"id,omitempty"`
@@ -11,4 +15,12 @@ type Comment struct {
 	CreatedAt    time.Time `json:"created_at,omitempty"`
 	AuthorName   string    `json:"author_name,omitempty"`
 	AuthorAvatar string    `json:"avatar,omitempty"`
+	// ParentCommentID is set on replies
+	ParentCommentID *uint      `json:"parent_comment_id,omitempty"`
+	ReplyCount      int        `json:"reply_count"`
+	Replies         []Comment  `json:"replies,omitempty"`
+	EditedAt        *time.Time `json:"edited_at,omitempty"`
+	Deleted         bool       `json:"deleted,omitempty"` // Tombstone of a deleted comment that has replies
+	// Entities are the hashtags and mentions in Content
+	Entities []entities.Entity `json:"entities,omitempty"`
 }
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"social-network/pkg/entities"
+)
 
 type Group struct {
 	ID              int       `json:"id"`
@@ -49,24 +53,42 @@ type GroupLeave struct {
 }
 
 type GroupPost struct {
-	ID        int                `json:"id"`
-	GroupID   int                `json:"group_id"`
-	AuthorID  int                `json:"author_id"`
-	Author    string             `json:"author"`
-	Title     string             `json:"title"`
-	Content   string             `json:"content"`
-	Media     string             `json:"media,omitempty"`
-	CreatedAt time.Time          `json:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at"`
-	Comments  []GroupPostComment `json:"comments,omitempty"`
+	ID           int                `json:"id"`
+	GroupID      int                `json:"group_id"`
+	AuthorID     int                `json:"author_id"`
+	Author       string             `json:"author"`
+	Title        string             `json:"title"`
+	Content      string             `json:"content"`
+	Media        string             `json:"media,omitempty"`
+	MediaURL     string             `json:"media_url,omitempty"`
+	Thumbnails   map[string]string  `json:"thumbnails,omitempty"`
+	Status       string             `json:"status"`                  // published, or pending or rejected for its author and moderators
+	Reason       string             `json:"review_reason,omitempty"` // What the moderator who reviewed it said
+	Pinned       bool               `json:"pinned"`
+	PinnedUntil  *time.Time         `json:"pinned_until,omitempty"`
+	Announcement bool               `json:"is_announcement"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+	Comments     []GroupPostComment `json:"comments,omitempty"`
+	Entities     []entities.Entity  `json:"entities,omitempty"` // Hashtags and mentions in Content
 }
 
 type GroupPostComment struct {
-	ID        int    `json:"id"`
-	PostID    int    `json:"post_id"`
-	AuthorID  int    `json:"author_id"`
-	Author    string `json:"author"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	ID           int    `json:"id"`
+	PostID       int    `json:"post_id"`
+	AuthorID     int    `json:"author_id"`
+	Author       string `json:"author"`
+	AuthorAvatar string `json:"author_avatar,omitempty"`
+	Content      string `json:"content"`
+	Media        string `json:"media,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+	// ParentCommentID is set on replies
+	ParentCommentID *int               `json:"parent_comment_id,omitempty"`
+	ReplyCount      int                `json:"reply_count"`
+	Replies         []GroupPostComment `json:"replies,omitempty"`
+	EditedAt        *string            `json:"edited_at,omitempty"`
+	Deleted         bool               `json:"deleted,omitempty"` // Tombstone of a deleted comment that has replies
+	// Entities are the hashtags and mentions in Content
+	Entities []entities.Entity `json:"entities,omitempty"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type Media struct {
+	ID         int               `json:"id"`
+	OwnerID    int               `json:"owner_id"`
+	Category   string            `json:"category"`
+	Filename   string            `json:"filename"`
+	MimeType   string            `json:"mime_type"`
+	Size       int64             `json:"size"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	URL        string            `json:"url"`
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
@@ -1,19 +1,26 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"social-network/pkg/entities"
+)
 
 type Post struct {
-	ID            int       `json:"id"`
-	Title         string    `json:"title"`
-	Content       string    `json:"content"`
-	Media         string    `json:"media"`
-	Privacy       int       `json:"privacy"`                 // 1: public, 2: almost-private (followers), 3: private
-	SelectedUsers []int     `json:"selectedUsers,omitempty"` // Only used when Privacy = 3
-	Author        int       `json:"author"`
-	AuthorName    string    `json:"authorName"`
-	AuthorAvatar  string    `json:"authorAvatar"`
-	CreatedAt     time.Time `json:"created_at"`
-	GroupID       int       `json:"group_id,omitempty"`
+	ID            int               `json:"id"`
+	Title         string            `json:"title"`
+	Content       string            `json:"content"`
+	Media         string            `json:"media"`
+	Thumbnails    map[string]string `json:"thumbnails,omitempty"`
+	Privacy       int               `json:"privacy"`                  // A policy.Privacy: 0 public, 1 almost private (followers), 2 private
+	SelectedUsers []int             `json:"selectedUsers,omitempty"`  // Only used when Privacy = 2
+	AudienceList  *int              `json:"audienceListId,omitempty"` // Only used when Privacy = 2
+	Author        int               `json:"author"`
+	AuthorName    string            `json:"authorName"`
+	AuthorAvatar  string            `json:"authorAvatar"`
+	CreatedAt     time.Time         `json:"created_at"`
+	GroupID       int               `json:"group_id,omitempty"`
+	Entities      []entities.Entity `json:"entities,omitempty"` // Hashtags and mentions in Content
 }
 
 type PostPrivateView struct {
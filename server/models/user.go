@@ -6,17 +6,19 @@ import (
 )
 
 type User struct {
-	ID          uint       `json:"id,omitempty"`
-	Email       string     `json:"email,omitempty"`
-	Password    string     `json:"password,omitempty"`
-	FirstName   string     `json:"first_name,omitempty"`
-	LastName    string     `json:"last_name,omitempty"`
-	DateOfBirth *time.Time `json:"date_of_birth,omitempty"`
-	Avatar      string     `json:"avatar,omitempty"`
-	Username    string     `json:"username,omitempty"`
-	AboutMe     string     `json:"about_me,omitempty"`
-	IsPrivate   bool       `json:"is_private,omitempty"`
-	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	ID               uint              `json:"id,omitempty"`
+	Email            string            `json:"email,omitempty"`
+	Password         string            `json:"password,omitempty"`
+	FirstName        string            `json:"first_name,omitempty"`
+	LastName         string            `json:"last_name,omitempty"`
+	DateOfBirth      *time.Time        `json:"date_of_birth,omitempty"`
+	Avatar           string            `json:"avatar,omitempty"`
+	AvatarThumbnails map[string]string `json:"avatar_thumbnails,omitempty"`
+	CoverImage       string            `json:"cover_image,omitempty"`
+	Username         string            `json:"username,omitempty"`
+	AboutMe          string            `json:"about_me,omitempty"`
+	IsPrivate        bool              `json:"is_private,omitempty"`
+	CreatedAt        *time.Time        `json:"created_at,omitempty"`
 }
 
 type UserResponse struct {
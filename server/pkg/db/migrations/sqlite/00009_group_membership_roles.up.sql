@@ -20,20 +20,32 @@ CREATE TABLE IF NOT EXISTS group_members_temp (
     PRIMARY KEY (group_id, user_id)
 );
 
--- Copy existing data if any
-INSERT OR IGNORE INTO group_members_temp (group_id, user_id, role)
+-- The first group_members table had neither roles nor join dates. On later
+-- runs the columns exist and these fail as duplicates, which is skipped.
+ALTER TABLE group_members ADD COLUMN role TEXT;
+ALTER TABLE group_members ADD COLUMN joined_at DATETIME;
+
+-- Copy existing data if any, keeping the roles and join dates the table had
+-- so restarting doesn't demote anyone
+INSERT OR IGNORE INTO group_members_temp (group_id, user_id, role, joined_at)
 SELECT 
     gm.group_id,
     gm.user_id,
     CASE 
         WHEN g.creator_id = gm.user_id THEN 'creator'
+        WHEN gm.role IN ('moderator', 'admin') THEN gm.role
         ELSE 'member'  -- Default role for all existing members
-    END
+    END,
+    COALESCE(gm.joined_at, CURRENT_TIMESTAMP)
 FROM group_members gm
 JOIN groups g ON g.id = gm.group_id
 WHERE EXISTS (SELECT 1 FROM groups WHERE id = gm.group_id)
 AND EXISTS (SELECT 1 FROM users WHERE id = gm.user_id);
 
+-- The trigger 00029 puts on users reads group_members, renaming a table
+-- fails while it is there. It is created again when 00029 runs.
+DROP TRIGGER IF EXISTS groups_hand_over_on_user_delete;
+
 -- Drop old table and rename new one
 DROP TABLE IF EXISTS group_members;
 ALTER TABLE group_members_temp RENAME TO group_members;
@@ -1,22 +1,18 @@
--- Add status column to group_members if it doesn't exist
-SELECT CASE 
-    WHEN COUNT(*) = 0 THEN
-        'ALTER TABLE group_members ADD COLUMN status TEXT NOT NULL DEFAULT "active"'
-    ELSE
-        'SELECT 1'
-END as sql_to_execute
-FROM pragma_table_info('group_members')
-WHERE name = 'status';
+-- Bans and mutes of users in groups. It is a table of its own because
+-- group_members is rebuilt on every start by 00009. Members without a row are
+-- active, rows whose expires_at passed no longer apply and are cleared lazily.
+CREATE TABLE IF NOT EXISTS group_member_status (
+    group_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('muted', 'banned')),
+    reason TEXT NOT NULL DEFAULT '',
+    actor_id INTEGER,
+    expires_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id),
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
 
--- Add check constraint for status
-SELECT CASE 
-    WHEN NOT EXISTS (
-        SELECT 1 FROM sqlite_master 
-        WHERE type = 'table' 
-        AND name = 'group_members' 
-        AND sql LIKE '%CHECK (status IN ("active", "inactive", "banned"))%'
-    ) THEN
-        'ALTER TABLE group_members ADD CONSTRAINT valid_status CHECK (status IN ("active", "inactive", "banned"))'
-    ELSE
-        'SELECT 1'
-END as sql_to_execute; 
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_group_member_status_status ON group_member_status(group_id, status);
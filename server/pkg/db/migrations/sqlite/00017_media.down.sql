@@ -0,0 +1,4 @@
+-- The media_id columns reference media(id), which SQLite won't drop.
+-- They are removed along with their tables by the earlier down migrations.
+DROP INDEX IF EXISTS idx_media_owner_id;
+DROP TABLE IF EXISTS media;
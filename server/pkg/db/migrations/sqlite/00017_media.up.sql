@@ -0,0 +1,22 @@
+-- Uploaded files, referenced by the rows that use them instead of storing raw data
+CREATE TABLE IF NOT EXISTS media (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER NOT NULL,
+    category TEXT NOT NULL CHECK (category IN ('posts', 'comments', 'group_posts', 'chat', 'avatars')),
+    filename TEXT NOT NULL UNIQUE,
+    mime_type TEXT NOT NULL CHECK (mime_type IN ('image/jpeg', 'image/png', 'image/gif')),
+    size INTEGER NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_owner_id ON media(owner_id);
+
+-- Reference the media record from everything that can carry an attachment
+ALTER TABLE posts ADD COLUMN media_id INTEGER REFERENCES media(id) ON DELETE SET NULL;
+ALTER TABLE comments ADD COLUMN media_id INTEGER REFERENCES media(id) ON DELETE SET NULL;
+ALTER TABLE group_posts ADD COLUMN media_id INTEGER REFERENCES media(id) ON DELETE SET NULL;
+ALTER TABLE group_post_comments ADD COLUMN media_id INTEGER REFERENCES media(id) ON DELETE SET NULL;
+ALTER TABLE chat_messages ADD COLUMN media_id INTEGER REFERENCES media(id) ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+-- The parent_comment_id columns are removed along with their tables by the
+-- earlier down migrations.
+DROP INDEX IF EXISTS idx_group_post_comments_parent_comment_id;
+DROP INDEX IF EXISTS idx_comments_parent_comment_id;
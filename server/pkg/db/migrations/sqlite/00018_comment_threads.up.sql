@@ -0,0 +1,6 @@
+-- Replies point at the comment they answer, top level comments have no parent
+ALTER TABLE comments ADD COLUMN parent_comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE;
+ALTER TABLE group_post_comments ADD COLUMN parent_comment_id INTEGER REFERENCES group_post_comments(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_comment_id ON comments(parent_comment_id);
+CREATE INDEX IF NOT EXISTS idx_group_post_comments_parent_comment_id ON group_post_comments(parent_comment_id);
@@ -0,0 +1,2 @@
+-- The edited_at and deleted_at columns are removed along with their tables by
+-- the earlier down migrations.
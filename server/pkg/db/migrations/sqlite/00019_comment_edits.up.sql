@@ -0,0 +1,6 @@
+-- Edited comments record when they last changed. Deleted comments that still
+-- have replies are kept as tombstones, marked by deleted_at.
+ALTER TABLE comments ADD COLUMN edited_at DATETIME;
+ALTER TABLE comments ADD COLUMN deleted_at DATETIME;
+ALTER TABLE group_post_comments ADD COLUMN edited_at DATETIME;
+ALTER TABLE group_post_comments ADD COLUMN deleted_at DATETIME;
@@ -0,0 +1,16 @@
+-- A mute hides a user's posts or quiets a group for the muting user only.
+-- Each row targets either a user or a group, expires_at NULL means forever.
+CREATE TABLE IF NOT EXISTS user_mutes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    muted_user_id INTEGER,
+    muted_group_id INTEGER,
+    expires_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (muted_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (muted_group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    UNIQUE(user_id, muted_user_id),
+    UNIQUE(user_id, muted_group_id),
+    CHECK ((muted_user_id IS NULL) != (muted_group_id IS NULL))
+);
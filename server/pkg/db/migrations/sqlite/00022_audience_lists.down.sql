@@ -0,0 +1,5 @@
+-- The audience_list_id column of posts is removed along with its table by
+-- the earlier down migrations.
+DROP INDEX IF EXISTS idx_audience_list_members_user_id;
+DROP TABLE IF EXISTS audience_list_members;
+DROP TABLE IF EXISTS audience_lists;
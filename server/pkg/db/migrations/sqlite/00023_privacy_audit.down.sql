@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_privacy_audit_log_user_id;
+DROP TABLE IF EXISTS privacy_audit_log;
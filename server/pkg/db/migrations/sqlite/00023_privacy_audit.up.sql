@@ -0,0 +1,12 @@
+-- Record of profile privacy changes and of what they did to followers
+CREATE TABLE IF NOT EXISTS privacy_audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('went_public', 'went_private', 'removed_followers')),
+    affected_count INTEGER NOT NULL DEFAULT 0,
+    affected_user_ids TEXT NOT NULL DEFAULT '[]', -- JSON array of the users the action changed
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_privacy_audit_log_user_id ON privacy_audit_log(user_id);
@@ -0,0 +1,3 @@
+-- The cover_image column is removed along with the users table by the first
+-- down migration.
+DROP TABLE IF EXISTS user_field_visibility;
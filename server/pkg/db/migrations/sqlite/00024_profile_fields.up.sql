@@ -0,0 +1,11 @@
+-- A wide image shown at the top of a profile, stored like avatars
+ALTER TABLE users ADD COLUMN cover_image TEXT;
+
+-- Who can see each optional profile field. Fields without a row are public.
+CREATE TABLE IF NOT EXISTS user_field_visibility (
+    user_id INTEGER NOT NULL,
+    field TEXT NOT NULL CHECK (field IN ('email', 'date_of_birth', 'about_me')),
+    audience TEXT NOT NULL CHECK (audience IN ('public', 'followers', 'only_me')),
+    PRIMARY KEY (user_id, field),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS content_mentions;
+DROP TABLE IF EXISTS content_hashtags;
@@ -0,0 +1,27 @@
+-- Hashtags and mentions parsed out of posts, comments, group posts and chat
+-- messages. content_type and content_id point at the row they were found in.
+CREATE TABLE IF NOT EXISTS content_hashtags (
+    content_type TEXT NOT NULL CHECK (content_type IN ('post', 'comment', 'group_post', 'group_comment', 'chat_message')),
+    content_id INTEGER NOT NULL,
+    tag TEXT NOT NULL, -- Lowercased, without the #
+    author_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (content_type, content_id, tag),
+    FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_hashtags_tag ON content_hashtags(tag, created_at);
+CREATE INDEX IF NOT EXISTS idx_content_hashtags_created_at ON content_hashtags(created_at);
+
+CREATE TABLE IF NOT EXISTS content_mentions (
+    content_type TEXT NOT NULL CHECK (content_type IN ('post', 'comment', 'group_post', 'group_comment', 'chat_message')),
+    content_id INTEGER NOT NULL,
+    mentioned_user_id INTEGER NOT NULL,
+    author_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (content_type, content_id, mentioned_user_id),
+    FOREIGN KEY (mentioned_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_mentions_user ON content_mentions(mentioned_user_id, created_at);
@@ -0,0 +1,118 @@
+-- Full-text search over users, posts, group posts, groups and events.
+-- Each index is an external content FTS5 table kept in sync with its table by
+-- triggers. This needs a driver built with FTS5 (go build -tags sqlite_fts5),
+-- without it the migration is skipped and search is unavailable.
+
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    username, first_name, last_name, about_me,
+    content='users',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2',
+    prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts (rowid, username, first_name, last_name, about_me) VALUES (new.id, new.username, new.first_name, new.last_name, new.about_me);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+    INSERT INTO users_fts (users_fts, rowid, username, first_name, last_name, about_me) VALUES ('delete', old.id, old.username, old.first_name, old.last_name, old.about_me);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF username, first_name, last_name, about_me ON users BEGIN
+    INSERT INTO users_fts (users_fts, rowid, username, first_name, last_name, about_me) VALUES ('delete', old.id, old.username, old.first_name, old.last_name, old.about_me);
+    INSERT INTO users_fts (rowid, username, first_name, last_name, about_me) VALUES (new.id, new.username, new.first_name, new.last_name, new.about_me);
+END;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+    title, content,
+    content='posts',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2',
+    prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+    INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+    INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+    INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+    INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS group_posts_fts USING fts5(
+    title, content,
+    content='group_posts',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2',
+    prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS group_posts_fts_insert AFTER INSERT ON group_posts BEGIN
+    INSERT INTO group_posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS group_posts_fts_delete AFTER DELETE ON group_posts BEGIN
+    INSERT INTO group_posts_fts (group_posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS group_posts_fts_update AFTER UPDATE OF title, content ON group_posts BEGIN
+    INSERT INTO group_posts_fts (group_posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+    INSERT INTO group_posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS groups_fts USING fts5(
+    title, description,
+    content='groups',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2',
+    prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS groups_fts_insert AFTER INSERT ON groups BEGIN
+    INSERT INTO groups_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS groups_fts_delete AFTER DELETE ON groups BEGIN
+    INSERT INTO groups_fts (groups_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS groups_fts_update AFTER UPDATE OF title, description ON groups BEGIN
+    INSERT INTO groups_fts (groups_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+    INSERT INTO groups_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS group_events_fts USING fts5(
+    title, description,
+    content='group_events',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2',
+    prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS group_events_fts_insert AFTER INSERT ON group_events BEGIN
+    INSERT INTO group_events_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS group_events_fts_delete AFTER DELETE ON group_events BEGIN
+    INSERT INTO group_events_fts (group_events_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS group_events_fts_update AFTER UPDATE OF title, description ON group_events BEGIN
+    INSERT INTO group_events_fts (group_events_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+    INSERT INTO group_events_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+-- Rebuilding from the content tables picks up rows written before the
+-- triggers existed, and is cheap enough to do on every start
+
+INSERT INTO users_fts (users_fts) VALUES ('rebuild');
+INSERT INTO posts_fts (posts_fts) VALUES ('rebuild');
+INSERT INTO group_posts_fts (group_posts_fts) VALUES ('rebuild');
+INSERT INTO groups_fts (groups_fts) VALUES ('rebuild');
+INSERT INTO group_events_fts (group_events_fts) VALUES ('rebuild');
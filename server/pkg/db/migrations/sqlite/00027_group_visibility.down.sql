@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_groups_visibility;
+ALTER TABLE groups DROP COLUMN visibility;
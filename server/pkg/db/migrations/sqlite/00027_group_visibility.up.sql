@@ -0,0 +1,7 @@
+-- Who can find, read and join a group: public groups are open to everyone,
+-- private ones need an approved request and secret ones an invitation.
+-- Existing groups keep working like before, as private groups.
+ALTER TABLE groups ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private'
+    CHECK (visibility IN ('public', 'private', 'secret'));
+
+CREATE INDEX IF NOT EXISTS idx_groups_visibility ON groups(visibility);
@@ -0,0 +1,17 @@
+-- What a group changed about the default capabilities of its roles. Only the
+-- differences are stored, roles without rows keep the defaults and the
+-- creator can always do everything.
+CREATE TABLE IF NOT EXISTS group_role_permissions (
+    group_id INTEGER NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('member', 'moderator', 'admin')),
+    capability TEXT NOT NULL CHECK (capability IN (
+        'post', 'comment', 'create_event', 'invite', 'approve_requests',
+        'remove_member', 'edit_group', 'manage_roles', 'pin_post', 'delete_content'
+    )),
+    allowed BOOLEAN NOT NULL,
+    updated_by INTEGER,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, role, capability),
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (updated_by) REFERENCES users(id) ON DELETE SET NULL
+);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS groups_hand_over_on_user_delete;
+DROP INDEX IF EXISTS idx_group_ownership_transfers_group_id;
+DROP TABLE IF EXISTS group_ownership_transfers;
@@ -0,0 +1,42 @@
+-- Offers from a group's creator to hand the group over to one of its
+-- members, who has to accept before the roles change
+CREATE TABLE IF NOT EXISTS group_ownership_transfers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER NOT NULL,
+    from_user_id INTEGER NOT NULL,
+    to_user_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled')),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    responded_at DATETIME,
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (from_user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (to_user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_ownership_transfers_group_id ON group_ownership_transfers(group_id);
+
+-- When a creator deletes their account their groups go to the oldest admin,
+-- or the oldest moderator or member when there is no admin. group_members
+-- can't carry a trigger since it is rebuilt by 00009, users is never rebuilt.
+CREATE TRIGGER IF NOT EXISTS groups_hand_over_on_user_delete AFTER DELETE ON users
+BEGIN
+    UPDATE groups SET creator_id = (
+        SELECT gm.user_id FROM group_members gm
+        WHERE gm.group_id = groups.id AND gm.user_id != old.id
+        ORDER BY CASE gm.role WHEN 'admin' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END,
+            gm.joined_at, gm.user_id
+        LIMIT 1
+    )
+    WHERE creator_id = old.id
+    AND EXISTS (
+        SELECT 1 FROM group_members gm
+        WHERE gm.group_id = groups.id AND gm.user_id != old.id
+    );
+
+    DELETE FROM group_members WHERE user_id = old.id;
+
+    UPDATE group_members SET role = 'creator'
+    WHERE role != 'creator'
+    AND user_id = (SELECT g.creator_id FROM groups g WHERE g.id = group_members.group_id);
+END;
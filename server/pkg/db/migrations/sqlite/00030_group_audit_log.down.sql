@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_group_audit_log_group_id;
+DROP TABLE IF EXISTS group_audit_log;
@@ -0,0 +1,18 @@
+-- What happened to a group's membership and settings, and who did it. The
+-- invite link is set for entries about a link or a join through one.
+CREATE TABLE IF NOT EXISTS group_audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER NOT NULL,
+    actor_id INTEGER,
+    action TEXT NOT NULL,
+    target_user_id INTEGER,
+    invite_link_id INTEGER,
+    details TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (target_user_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (invite_link_id) REFERENCES group_invite_links(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_audit_log_group_id ON group_audit_log(group_id, created_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_group_posts_status;
+ALTER TABLE group_posts DROP COLUMN review_reason;
+ALTER TABLE group_posts DROP COLUMN reviewed_at;
+ALTER TABLE group_posts DROP COLUMN reviewed_by;
+ALTER TABLE group_posts DROP COLUMN status;
+ALTER TABLE groups DROP COLUMN post_approval_days;
+ALTER TABLE groups DROP COLUMN post_approval;
@@ -0,0 +1,16 @@
+-- Whose posts wait for a moderator before the group sees them: nobody's,
+-- the members who joined less than post_approval_days ago, or everyone's.
+ALTER TABLE groups ADD COLUMN post_approval TEXT NOT NULL DEFAULT 'off'
+    CHECK (post_approval IN ('off', 'new_members', 'everyone'));
+ALTER TABLE groups ADD COLUMN post_approval_days INTEGER NOT NULL DEFAULT 7
+    CHECK (post_approval_days > 0);
+
+-- Pending and rejected posts are only shown to their author and to
+-- moderators. Posts from before approval existed are published.
+ALTER TABLE group_posts ADD COLUMN status TEXT NOT NULL DEFAULT 'published'
+    CHECK (status IN ('published', 'pending', 'rejected'));
+ALTER TABLE group_posts ADD COLUMN reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE group_posts ADD COLUMN reviewed_at DATETIME;
+ALTER TABLE group_posts ADD COLUMN review_reason TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_group_posts_status ON group_posts(group_id, status);
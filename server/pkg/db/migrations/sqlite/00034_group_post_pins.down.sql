@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_group_posts_pinned;
+ALTER TABLE group_posts DROP COLUMN announced_at;
+ALTER TABLE group_posts DROP COLUMN is_announcement;
+ALTER TABLE group_posts DROP COLUMN pinned_by;
+ALTER TABLE group_posts DROP COLUMN pinned_until;
+ALTER TABLE group_posts DROP COLUMN pinned_at;
+ALTER TABLE groups DROP COLUMN max_pinned_posts;
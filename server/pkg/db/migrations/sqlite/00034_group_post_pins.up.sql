@@ -0,0 +1,14 @@
+-- Posts moderators keep at the top of a group, until pinned_until when it is
+-- set, and how many a group keeps pinned at once
+ALTER TABLE groups ADD COLUMN max_pinned_posts INTEGER NOT NULL DEFAULT 3
+    CHECK (max_pinned_posts > 0);
+
+ALTER TABLE group_posts ADD COLUMN pinned_at DATETIME;
+ALTER TABLE group_posts ADD COLUMN pinned_until DATETIME;
+ALTER TABLE group_posts ADD COLUMN pinned_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+
+-- Announcements were sent to every member when they were made
+ALTER TABLE group_posts ADD COLUMN is_announcement BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE group_posts ADD COLUMN announced_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_group_posts_pinned ON group_posts(group_id, pinned_at);
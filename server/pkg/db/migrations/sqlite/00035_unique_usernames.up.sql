@@ -0,0 +1,7 @@
+-- Usernames are how users log in and are mentioned, no two accounts share
+-- one. Accounts that took a name already in use before the index keep it
+-- with their ID appended, the oldest account keeps the name itself.
+UPDATE users SET username = username || '_' || id
+WHERE EXISTS (SELECT 1 FROM users older WHERE older.username = users.username AND older.id < users.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username);
@@ -46,15 +46,10 @@ func RunMigrations() error {
         }
 
         // Split the content into individual statements
-        statements := strings.Split(string(content), ";")
+        statements := splitStatements(string(content))
 
         // Execute each statement
         for _, stmt := range statements {
-            stmt = strings.TrimSpace(stmt)
-            if stmt == "" {
-                continue
-            }
-
             _, err = DB.Exec(stmt)
             if err != nil {
                 // Check if error is about table/index already existing
@@ -62,6 +57,17 @@ func RunMigrations() error {
                     log.Printf("Object already exists in %s, continuing...", fileName)
                     continue
                 }
+                // Same for columns added with ALTER TABLE on a previous run
+                if strings.Contains(err.Error(), "duplicate column name") {
+                    log.Printf("Column already exists in %s, continuing...", fileName)
+                    continue
+                }
+                // Optional SQLite modules, like FTS5 for search, depend on how
+                // the driver was built. Skip the rest of the file without them.
+                if strings.Contains(err.Error(), "no such module") {
+                    log.Printf("Skipping %s, %v", fileName, err)
+                    break
+                }
                 return fmt.Errorf("failed to execute migration %s: %v", fileName, err)
             }
         }
@@ -71,6 +77,44 @@ func RunMigrations() error {
     return nil
 }
 
+// splitStatements splits a migration on ";". The body of a trigger is kept
+// whole, its statements end with ";" too.
+func splitStatements(content string) []string {
+    var statements []string
+    var current string
+    for _, part := range strings.Split(content, ";") {
+        if current != "" {
+            current += ";"
+        }
+        current += part
+
+        stmt := strings.TrimSpace(current)
+        upper := strings.ToUpper(withoutComments(stmt))
+        if strings.HasPrefix(upper, "CREATE TRIGGER") && !strings.HasSuffix(upper, "END") {
+            continue
+        }
+        if stmt != "" {
+            statements = append(statements, stmt)
+        }
+        current = ""
+    }
+    if stmt := strings.TrimSpace(current); stmt != "" {
+        statements = append(statements, stmt)
+    }
+    return statements
+}
+
+// withoutComments drops the "--" comment lines of a statement
+func withoutComments(stmt string) string {
+    var lines []string
+    for _, line := range strings.Split(stmt, "\n") {
+        if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+            lines = append(lines, line)
+        }
+    }
+    return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
 // RollbackMigrations executes all down migrations in reverse order
 func RollbackMigrations() error {
     // Get the current working directory
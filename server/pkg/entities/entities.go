@@ -0,0 +1,139 @@
+package entities
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Type tells hashtags and mentions apart
+type Type string
+
+const (
+	TypeHashtag Type = "hashtag"
+	TypeMention Type = "mention"
+)
+
+// Limits of what is recognized as a hashtag or a username
+const (
+	MaxHashtagLength  = 50
+	MaxUsernameLength = 30
+)
+
+// Entity is a hashtag or mention found in a text. Start and End are offsets
+// in UTF-16 code units, the way JavaScript indexes strings, so clients can
+// slice the text they received to render links.
+type Entity struct {
+	Type  Type   `json:"type"`
+	Text  string `json:"text"` // The tag or username, without the # or @
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// UserID is the mentioned user, mentions of unknown usernames are dropped
+	// before they are sent to clients
+	UserID int `json:"userId,omitempty"`
+}
+
+var (
+	// The marker has to start the text or follow something other than a word
+	// character, which keeps email addresses and URL fragments out
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/#])#([\p{L}\p{N}_]+)`)
+	mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@/.])@([\p{L}\p{N}_.\-]+)`)
+	// usernamePattern is the part of a mention after the @
+	usernamePattern = regexp.MustCompile(`^[\p{L}\p{N}_.\-]+$`)
+)
+
+// ValidUsername reports whether a username can be mentioned in full: it
+// only has the characters a mention is made of, doesn't end with the
+// punctuation a mention drops and isn't too long
+func ValidUsername(name string) bool {
+	return usernamePattern.MatchString(name) &&
+		!strings.HasSuffix(name, ".") && !strings.HasSuffix(name, "-") &&
+		utf8.RuneCountInString(name) <= MaxUsernameLength
+}
+
+// Parse finds the hashtags and mentions in a text, in the order they appear
+func Parse(text string) []Entity {
+	var found []Entity
+
+	for _, match := range hashtagPattern.FindAllStringSubmatchIndex(text, -1) {
+		tag := text[match[2]:match[3]]
+		// Numbers alone, like issue references, aren't tags
+		if !strings.ContainsFunc(tag, unicode.IsLetter) || utf8.RuneCountInString(tag) > MaxHashtagLength {
+			continue
+		}
+		found = append(found, entityAt(text, TypeHashtag, match[2]-1, match[3]))
+	}
+
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		// A mention at the end of a sentence doesn't own the full stop
+		end := match[3]
+		for end > match[2] && strings.ContainsRune(".-", rune(text[end-1])) {
+			end--
+		}
+		if end == match[2] || utf8.RuneCountInString(text[match[2]:end]) > MaxUsernameLength {
+			continue
+		}
+		found = append(found, entityAt(text, TypeMention, match[2]-1, end))
+	}
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Start < found[j].Start })
+	return found
+}
+
+// entityAt builds the entity covering text[start:end], where the first byte
+// is its # or @ marker
+func entityAt(text string, kind Type, start, end int) Entity {
+	return Entity{
+		Type:  kind,
+		Text:  text[start+1 : end],
+		Start: utf16Len(text[:start]),
+		End:   utf16Len(text[:end]),
+	}
+}
+
+// utf16Len counts the UTF-16 code units of s, runes above the BMP take two
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r > 0xFFFF {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// NormalizeHashtag is the form hashtags are indexed and looked up by
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// Hashtags returns the distinct normalized hashtags among found
+func Hashtags(found []Entity) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, e := range found {
+		tag := NormalizeHashtag(e.Text)
+		if e.Type == TypeHashtag && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Usernames returns the distinct usernames mentioned among found
+func Usernames(found []Entity) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, e := range found {
+		if e.Type == TypeMention && !seen[e.Text] {
+			seen[e.Text] = true
+			names = append(names, e.Text)
+		}
+	}
+	return names
+}
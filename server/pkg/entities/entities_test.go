@@ -0,0 +1,105 @@
+package entities
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func hashtag(text string, start, end int) Entity {
+	return Entity{Type: TypeHashtag, Text: text, Start: start, End: end}
+}
+
+func mention(text string, start, end int) Entity {
+	return Entity{Type: TypeMention, Text: text, Start: start, End: end}
+}
+
+func TestParse(t *testing.T) {
+	longTag := strings.Repeat("a", MaxHashtagLength)
+	longName := strings.Repeat("b", MaxUsernameLength)
+
+	tests := []struct {
+		name string
+		text string
+		want []Entity
+	}{
+		{"empty", "", nil},
+		{"plain text", "nothing to see here", nil},
+		{"hashtag and mention", "hi @alice, see #golang", []Entity{mention("alice", 3, 9), hashtag("golang", 15, 22)}},
+		{"at the start", "#go @bob", []Entity{hashtag("go", 0, 3), mention("bob", 4, 8)}},
+		// The emoji is two UTF-16 code units, one rune and four bytes
+		{"after an emoji", "😀 #tag", []Entity{hashtag("tag", 3, 7)}},
+		{"after an accent", "é #tag", []Entity{hashtag("tag", 2, 6)}},
+		{"non-latin tag", "#café #日本", []Entity{hashtag("café", 0, 5), hashtag("日本", 6, 9)}},
+		{"email address", "mail a@b.com please", nil},
+		{"numbers only", "fixed in #123", nil},
+		{"numbers and letters", "#2024goals", []Entity{hashtag("2024goals", 0, 10)}},
+		{"end of sentence", "thanks @user.", []Entity{mention("user", 7, 12)}},
+		{"trailing dash", "ask @user-", []Entity{mention("user", 4, 9)}},
+		{"dots inside", "cc @first.last.", []Entity{mention("first.last", 3, 14)}},
+		{"marker only", "# and @ alone", nil},
+		{"stuck tags", "#a#b", []Entity{hashtag("a", 0, 2)}},
+		{"stuck mentions", "@a@b", []Entity{mention("a", 0, 2)}},
+		{"url fragment", "see example.com/page#section and x/@y", nil},
+		{"html entity", "fish &#38; chips", nil},
+		{"longest tag", "#" + longTag, []Entity{hashtag(longTag, 0, MaxHashtagLength+1)}},
+		{"tag too long", "#" + longTag + "a", nil},
+		{"longest username", "@" + longName, []Entity{mention(longName, 0, MaxUsernameLength+1)}},
+		{"username too long", "@" + longName + "b", nil},
+		{"ordered by position", "@z #y @x", []Entity{mention("z", 0, 2), hashtag("y", 3, 5), mention("x", 6, 8)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashtagsAndUsernames(t *testing.T) {
+	found := Parse("#Go #go @ann #GO @Ann @ann #rust")
+
+	if got, want := Hashtags(found), []string{"go", "rust"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Hashtags = %v, want %v", got, want)
+	}
+	// Usernames are case sensitive
+	if got, want := Usernames(found), []string{"ann", "Ann"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Usernames = %v, want %v", got, want)
+	}
+	if got := NormalizeHashtag("#GoLang"); got != "golang" {
+		t.Errorf("NormalizeHashtag = %q, want golang", got)
+	}
+}
+
+func TestValidUsername(t *testing.T) {
+	tests := map[string]bool{
+		"alice":                      true,
+		"al.ice-2":                   true,
+		"émile_ß":                    true,
+		"日本":                         true,
+		"":                           false,
+		"two words":                  false,
+		"alice.":                     false,
+		"alice-":                     false,
+		"a@b":                        false,
+		"#tag":                       false,
+		strings.Repeat("x", 30):      true,
+		strings.Repeat("x", 31):      false,
+		strings.Repeat("é", 30):      true,
+		"name\n":                     false,
+		"<script>":                   false,
+		"trailing_underscore_is_ok_": true,
+	}
+	for name, want := range tests {
+		if got := ValidUsername(name); got != want {
+			t.Errorf("ValidUsername(%q) = %v, want %v", name, got, want)
+		}
+		// A valid username is mentioned in full
+		if want {
+			if found := Parse("@" + name); len(found) != 1 || found[0].Text != name {
+				t.Errorf("Parse(@%s) = %+v, want the whole username", name, found)
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+
+	"social-network/pkg/storage"
+
+	"github.com/gofrs/uuid"
+)
+
+// Category groups uploads by the feature they belong to. It doubles as the
+// key prefix in the store.
+type Category string
+
+const (
+	CategoryPost      Category = "posts"
+	CategoryComment   Category = "comments"
+	CategoryGroupPost Category = "group_posts"
+	CategoryChat      Category = "chat"
+	CategoryAvatar    Category = "avatars"
+)
+
+const (
+	// MaxFileSize is the largest upload accepted, in bytes
+	MaxFileSize = 10 << 20
+	// MaxDimension is the largest width or height accepted, in pixels
+	MaxDimension = 8000
+	// MaxPixels caps width*height so small files can't decode into huge bitmaps
+	MaxPixels = 40_000_000
+)
+
+// UploadDir is the directory the default local store writes to
+const UploadDir = "./uploads"
+
+// urlPrefix is the path the application serves uploads under
+const urlPrefix = "/uploads"
+
+// Store holds the uploaded files. It starts out as the local store in
+// UploadDir, Configure picks the backend from the environment.
+var Store storage.BlobStore
+
+var (
+	ErrTooLarge        = errors.New("file exceeds the maximum upload size")
+	ErrUnsupportedType = errors.New("only JPEG, PNG and GIF images are allowed")
+	ErrInvalidImage    = errors.New("file is not a valid image")
+	ErrDimensions      = errors.New("image dimensions are too large")
+	ErrUnknownCategory = errors.New("unknown media category")
+)
+
+// allowedTypes maps the sniffed content type to the decoder format name and
+// the extension used for stored files
+var allowedTypes = map[string]struct {
+	format string
+	ext    string
+}{
+	"image/jpeg": {"jpeg", ".jpg"},
+	"image/png":  {"png", ".png"},
+	"image/gif":  {"gif", ".gif"},
+}
+
+var categories = map[Category]bool{
+	CategoryPost:      true,
+	CategoryComment:   true,
+	CategoryGroupPost: true,
+	CategoryChat:      true,
+	CategoryAvatar:    true,
+}
+
+// File describes an upload that passed validation and was written to the store
+type File struct {
+	Name     string
+	Category Category
+	MimeType string
+	Size     int64
+	Width    int
+	Height   int
+}
+
+// ParseCategory returns the category with the given name if it is known
+func ParseCategory(name string) (Category, error) {
+	c := Category(name)
+	if !categories[c] {
+		return "", ErrUnknownCategory
+	}
+	return c, nil
+}
+
+// Configure sets Store from MEDIA_STORAGE ("local" or "s3")
+func Configure() error {
+	store, err := OpenStore(os.Getenv("MEDIA_STORAGE"))
+	if err != nil {
+		return err
+	}
+	Store = store
+	return nil
+}
+
+// OpenStore creates a store of the given kind for uploads, see storage.Open
+// for the settings each kind reads
+func OpenStore(kind string) (storage.BlobStore, error) {
+	return storage.Open(kind, urlSigner, urlPrefix)
+}
+
+// URL returns the path the file is served under
+func URL(category Category, name string) string {
+	return urlPrefix + "/" + string(category) + "/" + name
+}
+
+// Key returns the store key of a file. The name is reduced to its base so
+// callers can't escape the category.
+func Key(category Category, name string) string {
+	return string(category) + "/" + path.Base(name)
+}
+
+// Validate sniffs the content of data and checks it against the image
+// whitelist and size limits. It returns the detected content type and the
+// image dimensions. The pixel data itself is decoded when the upload is
+// processed.
+func Validate(data []byte) (string, image.Config, error) {
+	if len(data) > MaxFileSize {
+		return "", image.Config{}, ErrTooLarge
+	}
+
+	mimeType := http.DetectContentType(data)
+	allowed, ok := allowedTypes[mimeType]
+	if !ok {
+		return "", image.Config{}, ErrUnsupportedType
+	}
+
+	// The decoder has to agree with the sniffed type, otherwise the file is
+	// only pretending to be an image
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != allowed.format {
+		return "", image.Config{}, ErrInvalidImage
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 ||
+		cfg.Width > MaxDimension || cfg.Height > MaxDimension ||
+		cfg.Width*cfg.Height > MaxPixels {
+		return "", image.Config{}, ErrDimensions
+	}
+
+	return mimeType, cfg, nil
+}
+
+// Save validates the upload read from r, strips its metadata and stores it
+// under a generated name in its category, together with its thumbnails and,
+// for GIFs, the poster frame
+func Save(category Category, r io.Reader) (*File, error) {
+	if !categories[category] {
+		return nil, ErrUnknownCategory
+	}
+
+	// Read one byte past the limit so oversized uploads can be detected
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	mimeType, _, err := Validate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate file name: %w", err)
+	}
+	name := id.String() + allowedTypes[mimeType].ext
+
+	stripped, size, variants, err := process(name, mimeType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := put(category, name, mimeType, stripped); err != nil {
+		return nil, err
+	}
+	for _, v := range variants {
+		if err := put(category, v.name, "", v.data); err != nil {
+			Remove(category, name)
+			return nil, err
+		}
+	}
+
+	return &File{
+		Name:     name,
+		Category: category,
+		MimeType: mimeType,
+		Size:     int64(len(stripped)),
+		Width:    size.X,
+		Height:   size.Y,
+	}, nil
+}
+
+// put writes a file to the store. Variants pass an empty content type and
+// get the one matching their extension.
+func put(category Category, name, contentType string, data []byte) error {
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if err := Store.Put(Key(category, name), bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return fmt.Errorf("failed to store %s: %w", name, err)
+	}
+	return nil
+}
+
+// Remove deletes a stored file and its variants. Missing files are not an
+// error.
+func Remove(category Category, name string) error {
+	var firstErr error
+	for _, n := range append([]string{name}, Variants(name)...) {
+		if err := Store.Delete(Key(category, n)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,420 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"social-network/pkg/storage"
+)
+
+// solid returns a w x h image whose left half is red and right half blue, so
+// rotations can be told apart
+func solid(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func pngFixture(t *testing.T, w, h int) []byte {
+	t.Helper()
+	data, err := encodePNG(solid(w, h))
+	if err != nil {
+		t.Fatalf("encoding PNG fixture: %v", err)
+	}
+	return data
+}
+
+func jpegFixture(t *testing.T, w, h int) []byte {
+	t.Helper()
+	data, err := encodeJPEG(90)(solid(w, h))
+	if err != nil {
+		t.Fatalf("encoding JPEG fixture: %v", err)
+	}
+	return data
+}
+
+// pngChunk builds a PNG chunk with its length and CRC
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// withPNGSize rewrites the IHDR of a PNG to claim other dimensions, the
+// pixel data is left alone since only the header is read when validating
+func withPNGSize(data []byte, w, h int) []byte {
+	out := append([]byte(nil), data...)
+	ihdr := out[8:]
+	binary.BigEndian.PutUint32(ihdr[8:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[12:], uint32(h))
+	length := int(binary.BigEndian.Uint32(ihdr))
+	binary.BigEndian.PutUint32(ihdr[8+length:], crc32.ChecksumIEEE(ihdr[4:8+length]))
+	return out
+}
+
+// exifSegment builds an APP1 segment with a little endian TIFF header whose
+// first IFD holds the orientation tag and a pointer to a GPS IFD
+func exifSegment(orientation uint16) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8) // IFD0 right after the header
+
+	tiff = le.AppendUint16(tiff, 2) // two entries
+	// Orientation, SHORT, count 1
+	tiff = le.AppendUint16(tiff, 0x0112)
+	tiff = le.AppendUint16(tiff, 3)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint16(tiff, orientation)
+	tiff = le.AppendUint16(tiff, 0)
+	// GPS IFD pointer, LONG, count 1
+	tiff = le.AppendUint16(tiff, 0x8825)
+	tiff = le.AppendUint16(tiff, 4)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, 8+2+2*12+4)
+	tiff = le.AppendUint32(tiff, 0) // no next IFD
+
+	// GPS IFD with the latitude reference
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 0x0001)
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = le.AppendUint32(tiff, 2)
+	tiff = append(tiff, 'N', 0, 0, 0)
+	tiff = le.AppendUint32(tiff, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// withSegments inserts segments right after the SOI marker of a JPEG
+func withSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte(nil), data[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+// jpegMarkers lists the markers of the segments before the image data
+func jpegMarkers(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var markers []byte
+	for pos := 2; ; {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			t.Fatalf("malformed JPEG at offset %d", pos)
+		}
+		marker := data[pos+1]
+		markers = append(markers, marker)
+		if marker == 0xDA {
+			return markers
+		}
+		pos += 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	pngData := pngFixture(t, 20, 10)
+	jpegData := jpegFixture(t, 20, 10)
+
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, solid(20, 10), nil); err != nil {
+		t.Fatalf("encoding GIF fixture: %v", err)
+	}
+
+	// JPEG magic bytes in front of a PNG sniff as JPEG, but don't decode as one
+	disguised := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, pngData...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantType string
+		wantErr  error
+	}{
+		{"jpeg", jpegData, "image/jpeg", nil},
+		{"png", pngData, "image/png", nil},
+		{"gif", gifData.Bytes(), "image/gif", nil},
+		{"png disguised as jpeg", disguised, "", ErrInvalidImage},
+		{"text", []byte("just some text, not an image"), "", ErrUnsupportedType},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "", ErrUnsupportedType},
+		{"truncated png", pngData[:30], "", ErrInvalidImage},
+		{"too wide", withPNGSize(pngData, MaxDimension+1, 10), "", ErrDimensions},
+		{"too tall", withPNGSize(pngData, 10, MaxDimension+1), "", ErrDimensions},
+		{"too many pixels", withPNGSize(pngData, 7000, 7000), "", ErrDimensions},
+		{"at the limits", withPNGSize(pngData, MaxDimension, MaxPixels/MaxDimension), "image/png", nil},
+		{"too large", append(pngData, make([]byte, MaxFileSize)...), "", ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mimeType, _, err := Validate(tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate error = %v, want %v", err, tt.wantErr)
+			}
+			if mimeType != tt.wantType {
+				t.Errorf("Validate type = %q, want %q", mimeType, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestStripJPEG(t *testing.T) {
+	comment := []byte{0xFF, 0xFE, 0x00, 0x07, 'h', 'e', 'l', 'l', 'o'}
+	data := withSegments(jpegFixture(t, 16, 16), exifSegment(1), comment)
+
+	stripped, err := stripJPEG(data)
+	if err != nil {
+		t.Fatalf("stripJPEG: %v", err)
+	}
+	for _, marker := range jpegMarkers(t, stripped) {
+		if marker == 0xE1 || marker == 0xFE {
+			t.Errorf("marker %X survived stripping", marker)
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped JPEG doesn't decode: %v", err)
+	}
+	if len(stripped) != len(data)-len(exifSegment(1))-len(comment) {
+		t.Errorf("stripJPEG removed %d bytes, want only the metadata segments", len(data)-len(stripped))
+	}
+
+	if _, err := stripJPEG([]byte("not a jpeg")); err != ErrInvalidImage {
+		t.Errorf("stripJPEG of garbage = %v, want ErrInvalidImage", err)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	data := pngFixture(t, 8, 8)
+	// Insert a text chunk after the signature and IHDR
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(data[8:]))
+	text := pngChunk("tEXt", []byte("Comment\x00secret"))
+	tagged := append(append(append([]byte(nil), data[:ihdrEnd]...), text...), data[ihdrEnd:]...)
+
+	stripped, err := stripPNG(tagged)
+	if err != nil {
+		t.Fatalf("stripPNG: %v", err)
+	}
+	if !bytes.Equal(stripped, data) {
+		t.Error("stripPNG didn't restore the PNG without its text chunk")
+	}
+	if bytes.Contains(stripped, []byte("secret")) {
+		t.Error("text chunk survived stripping")
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := jpegFixture(t, 8, 8)
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		if got := jpegOrientation(withSegments(plain, exifSegment(orientation))); got != int(orientation) {
+			t.Errorf("jpegOrientation = %d, want %d", got, orientation)
+		}
+	}
+	if got := jpegOrientation(plain); got != 1 {
+		t.Errorf("jpegOrientation without EXIF = %d, want 1", got)
+	}
+	if got := jpegOrientation(withSegments(plain, exifSegment(9))); got != 1 {
+		t.Errorf("jpegOrientation with an invalid tag = %d, want 1", got)
+	}
+}
+
+func TestProcessRotatesAndStripsJPEG(t *testing.T) {
+	// Orientation 6 means the camera was turned, the stored pixels are 40x20
+	// and the picture is upright at 20x40
+	data := withSegments(jpegFixture(t, 40, 20), exifSegment(6))
+
+	out, size, variants, err := process("photo.jpg", "image/jpeg", data)
+	if err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if size != (image.Point{20, 40}) {
+		t.Errorf("size = %v, want 20x40", size)
+	}
+	for _, marker := range jpegMarkers(t, out) {
+		if marker == 0xE1 {
+			t.Fatal("APP1 survived processing")
+		}
+	}
+	if bytes.Contains(out, []byte("Exif")) {
+		t.Error("EXIF data survived processing")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("processed JPEG doesn't decode: %v", err)
+	}
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 {
+		t.Errorf("processed JPEG is %v, want 20x40", img.Bounds().Size())
+	}
+	// Rotated clockwise, the red left half ends up on top
+	if r, _, b, _ := img.At(10, 5).RGBA(); r < b {
+		t.Error("top of the rotated image isn't red")
+	}
+	if r, _, b, _ := img.At(10, 35).RGBA(); b < r {
+		t.Error("bottom of the rotated image isn't blue")
+	}
+
+	if len(variants) != len(ThumbnailSizes) {
+		t.Errorf("got %d variants, want a thumbnail per size", len(variants))
+	}
+}
+
+func TestProcessThumbnails(t *testing.T) {
+	_, _, variants, err := process("wide.png", "image/png", pngFixture(t, 2000, 1000))
+	if err != nil {
+		t.Fatalf("process: %v", err)
+	}
+
+	want := map[string]image.Point{
+		"wide_1200.png": {1200, 600},
+		"wide_600.png":  {600, 300},
+		"wide_150.png":  {150, 75},
+	}
+	if len(variants) != len(want) {
+		t.Fatalf("got %d variants, want %d", len(variants), len(want))
+	}
+	for _, v := range variants {
+		size, ok := want[v.name]
+		if !ok {
+			t.Errorf("unexpected variant %s", v.name)
+			continue
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(v.data))
+		if err != nil {
+			t.Errorf("%s doesn't decode: %v", v.name, err)
+			continue
+		}
+		if cfg.Width != size.X || cfg.Height != size.Y {
+			t.Errorf("%s is %dx%d, want %v", v.name, cfg.Width, cfg.Height, size)
+		}
+	}
+
+	// Small images aren't scaled up
+	_, _, variants, err = process("small.png", "image/png", pngFixture(t, 100, 50))
+	if err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	for _, v := range variants {
+		cfg, _ := png.DecodeConfig(bytes.NewReader(v.data))
+		if cfg.Width != 100 || cfg.Height != 50 {
+			t.Errorf("%s is %dx%d, want the original 100x50", v.name, cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestSaveAnimatedGIF(t *testing.T) {
+	Store = storage.NewLocal(t.TempDir(), urlPrefix, urlSigner)
+
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 30, 20), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i)
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var data bytes.Buffer
+	if err := gif.EncodeAll(&data, anim); err != nil {
+		t.Fatalf("encoding GIF fixture: %v", err)
+	}
+
+	file, err := Save(CategoryPost, &data)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if file.MimeType != "image/gif" || !strings.HasSuffix(file.Name, ".gif") || file.Width != 30 || file.Height != 20 {
+		t.Errorf("Save = %+v, want a 30x20 GIF", file)
+	}
+
+	body, _, err := Store.Get(Key(CategoryPost, file.Name))
+	if err != nil {
+		t.Fatalf("reading stored GIF: %v", err)
+	}
+	stored, err := gif.DecodeAll(body)
+	body.Close()
+	if err != nil || len(stored.Image) != 2 {
+		t.Errorf("stored GIF lost its frames: %v", err)
+	}
+
+	body, _, err = Store.Get(Key(CategoryPost, PosterName(file.Name)))
+	if err != nil {
+		t.Fatalf("reading poster: %v", err)
+	}
+	poster, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("reading poster: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(poster))
+	if err != nil {
+		t.Fatalf("poster isn't a PNG: %v", err)
+	}
+	if img.Bounds().Dx() != 30 || img.Bounds().Dy() != 20 {
+		t.Errorf("poster is %v, want 30x20", img.Bounds().Size())
+	}
+	if r, g, b, _ := img.At(0, 0).RGBA(); r != 0 || g != 0 || b != 0 {
+		t.Error("poster isn't the first frame")
+	}
+
+	for _, name := range Variants(file.Name) {
+		if _, err := Store.Stat(Key(CategoryPost, name)); err != nil {
+			t.Errorf("variant %s wasn't stored: %v", name, err)
+		}
+		if strings.HasSuffix(name, ".gif") {
+			t.Errorf("variant %s should be a PNG", name)
+		}
+	}
+
+	if err := Remove(CategoryPost, file.Name); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	keys, err := Store.List(string(CategoryPost) + "/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("files left after Remove: %v", keys)
+	}
+}
+
+func TestSaveRejectsUnknownCategory(t *testing.T) {
+	if _, err := Save(Category("secrets"), bytes.NewReader(pngFixture(t, 4, 4))); err != ErrUnknownCategory {
+		t.Errorf("Save = %v, want ErrUnknownCategory", err)
+	}
+}
+
+func TestOriginalStem(t *testing.T) {
+	tests := map[string]string{
+		"abc.png":        "abc",
+		"abc_150.png":    "abc",
+		"abc_1200.jpg":   "abc",
+		"abc_poster.png": "abc",
+		"abc_151.png":    "abc_151",
+		"a_b.gif":        "a_b",
+	}
+	for name, want := range tests {
+		if got := OriginalStem(name); got != want {
+			t.Errorf("OriginalStem(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ThumbnailSizes are the bounding boxes, in pixels, thumbnails are rendered
+// for. Images are never scaled up, smaller ones are stored at their own size.
+var ThumbnailSizes = []int{150, 600, 1200}
+
+const (
+	// originalQuality is used when a JPEG has to be re-encoded to auto-orient it
+	originalQuality = 92
+	// thumbnailQuality is used for JPEG thumbnails
+	thumbnailQuality = 80
+)
+
+// variant is a file derived from an upload and stored next to it
+type variant struct {
+	name string
+	data []byte
+}
+
+// ThumbnailName returns the name of the thumbnail for a stored file. GIF
+// thumbnails are rendered from the poster frame, so they are PNGs.
+func ThumbnailName(name string, size int) string {
+	ext := filepath.Ext(name)
+	if ext == ".gif" {
+		ext = ".png"
+	}
+	return stem(name) + "_" + strconv.Itoa(size) + ext
+}
+
+// PosterName returns the name of the still first frame stored for a GIF
+func PosterName(name string) string {
+	return stem(name) + "_poster.png"
+}
+
+// HasPoster reports whether a poster frame is stored for the file
+func HasPoster(name string) bool {
+	return filepath.Ext(name) == ".gif"
+}
+
+// Variants returns the names of all files derived from a stored file
+func Variants(name string) []string {
+	names := make([]string, 0, len(ThumbnailSizes)+1)
+	for _, size := range ThumbnailSizes {
+		names = append(names, ThumbnailName(name, size))
+	}
+	if HasPoster(name) {
+		names = append(names, PosterName(name))
+	}
+	return names
+}
+
+// OriginalStem returns the name without extension shared by a stored file
+// and all of its variants
+func OriginalStem(name string) string {
+	s := stem(name)
+	i := strings.LastIndex(s, "_")
+	if i < 0 {
+		return s
+	}
+	suffix := s[i+1:]
+	if suffix == "poster" {
+		return s[:i]
+	}
+	for _, size := range ThumbnailSizes {
+		if suffix == strconv.Itoa(size) {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func stem(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// process strips metadata from a validated upload, applies the EXIF
+// orientation and renders the thumbnails and poster frame. It returns the
+// bytes to store for the original, its final size and the variants.
+func process(name, mimeType string, data []byte) ([]byte, image.Point, []variant, error) {
+	var out []byte
+	var still image.Image
+	var variants []variant
+	encode := encodePNG
+
+	switch mimeType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, image.Point{}, nil, ErrInvalidImage
+		}
+		encode = encodeJPEG(thumbnailQuality)
+
+		// Upright images only lose their metadata segments, the rest are
+		// re-encoded since rotating drops the tag along with everything else
+		if orientation := jpegOrientation(data); orientation > 1 {
+			img = orient(img, orientation)
+			if out, err = encodeJPEG(originalQuality)(img); err != nil {
+				return nil, image.Point{}, nil, err
+			}
+		} else if out, err = stripJPEG(data); err != nil {
+			return nil, image.Point{}, nil, err
+		}
+		still = img
+
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, image.Point{}, nil, ErrInvalidImage
+		}
+		if out, err = stripPNG(data); err != nil {
+			return nil, image.Point{}, nil, err
+		}
+		still = img
+
+	case "image/gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(g.Image) == 0 {
+			return nil, image.Point{}, nil, ErrInvalidImage
+		}
+
+		// Re-encoding keeps every frame and the timing but drops comment and
+		// application extensions other than the loop count
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			return nil, image.Point{}, nil, ErrInvalidImage
+		}
+		out = buf.Bytes()
+
+		still = posterFrame(g)
+		poster, err := encodePNG(still)
+		if err != nil {
+			return nil, image.Point{}, nil, err
+		}
+		variants = append(variants, variant{PosterName(name), poster})
+
+	default:
+		return nil, image.Point{}, nil, ErrUnsupportedType
+	}
+
+	thumbnails, err := renderThumbnails(name, still, encode)
+	if err != nil {
+		return nil, image.Point{}, nil, err
+	}
+	variants = append(variants, thumbnails...)
+
+	return out, still.Bounds().Size(), variants, nil
+}
+
+// renderThumbnails scales the image down to each thumbnail size. The sizes
+// are rendered largest first, each from the previous one.
+func renderThumbnails(name string, img image.Image, encode func(image.Image) ([]byte, error)) ([]variant, error) {
+	sizes := append([]int(nil), ThumbnailSizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	current := toRGBA(img)
+	variants := make([]variant, 0, len(sizes))
+	for _, size := range sizes {
+		current = fit(current, size)
+		data, err := encode(current)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant{ThumbnailName(name, size), data})
+	}
+	return variants, nil
+}
+
+// fit scales an image down so neither side exceeds size, keeping the aspect
+// ratio
+func fit(img *image.RGBA, size int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	dw, dh := size, size
+	if w > h {
+		dh = max(1, h*size/w)
+	} else {
+		dw = max(1, w*size/h)
+	}
+	return resize(img, dw, dh)
+}
+
+// resize scales an image down with a box filter, every destination pixel is
+// the average of the source pixels it covers
+func resize(src *image.RGBA, dw, dh int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*sh/dh, (y+1)*sh/dh
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*sw/dw, (x+1)*sw/dw
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, b, a uint64
+			for sy := sy0; sy < sy1; sy++ {
+				i := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					i += 4
+				}
+			}
+
+			n := uint64((sx1 - sx0) * (sy1 - sy0))
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// posterFrame renders the first frame of a GIF onto the full canvas
+func posterFrame(g *gif.GIF) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	frame := g.Image[0]
+	draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+	return canvas
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeJPEG(quality int) func(image.Image) ([]byte, error) {
+	return func(img image.Image) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// stripJPEG removes the segments that carry metadata (EXIF, XMP, IPTC and
+// comments) without touching the image data. JFIF, ICC and Adobe segments are
+// kept since they affect how the image is decoded.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrInvalidImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, ErrInvalidImage
+		}
+		marker := data[pos+1]
+		// Fill bytes before a marker
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		// Everything from the start of scan on is image data
+		if marker == 0xDA {
+			out.Write(data[pos:])
+			return out.Bytes(), nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrInvalidImage
+		}
+		switch marker {
+		case 0xE1, 0xED, 0xFE: // APP1 (EXIF, XMP), APP13 (IPTC), COM
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+}
+
+// pngMetadataChunks are the ancillary chunks that only carry metadata
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// stripPNG removes the text, EXIF and timestamp chunks from a PNG
+func stripPNG(data []byte) ([]byte, error) {
+	const signatureLen = 8
+	if len(data) < signatureLen {
+		return nil, ErrInvalidImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:signatureLen])
+
+	for pos := signatureLen; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, ErrInvalidImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length // length, type, data and CRC
+		if length < 0 || end > len(data) {
+			return nil, ErrInvalidImage
+		}
+		if !pngMetadataChunks[string(data[pos+4:pos+8])] {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,51 @@
+package media
+
+import (
+	"crypto/rand"
+	"log"
+	"os"
+	"time"
+
+	"social-network/pkg/storage"
+)
+
+// SignedURLTTL is the minimum lifetime of a signed URL
+const SignedURLTTL = time.Hour
+
+// urlSigner authenticates the signed URLs of files served by the
+// application. MEDIA_SIGNING_KEY keeps links valid across restarts, without
+// it a random key is used for the process lifetime.
+var urlSigner *storage.Signer
+
+func init() {
+	if key := os.Getenv("MEDIA_SIGNING_KEY"); key != "" {
+		urlSigner = storage.NewSigner([]byte(key))
+	} else {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Failed to generate media signing key: %v", err)
+		}
+		urlSigner = storage.NewSigner(key)
+	}
+
+	Store = storage.NewLocal(UploadDir, urlPrefix, urlSigner)
+}
+
+// SignedURL returns a URL the file can be fetched from without a session.
+// Depending on the store it points at the application or straight at the
+// storage service.
+func SignedURL(category Category, name string) string {
+	signed, err := Store.SignedURL(Key(category, name), SignedURLTTL)
+	if err != nil {
+		log.Printf("Failed to sign URL for %s/%s: %v", category, name, err)
+		return URL(category, name)
+	}
+	return signed
+}
+
+// VerifySignature checks the exp and sig query values of a signed URL served
+// by the application. It returns the expiry time when the signature is valid
+// and not expired.
+func VerifySignature(path, exp, sig string) (time.Time, bool) {
+	return urlSigner.Verify(path, exp, sig)
+}
@@ -0,0 +1,103 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// GroupVisibility is who can find a group, read it and join it
+type GroupVisibility string
+
+const (
+	// GroupPublic groups are listed, readable by everyone and joined
+	// instantly
+	GroupPublic GroupVisibility = "public"
+	// GroupPrivate groups are listed but only members read them, joining
+	// needs an approved request
+	GroupPrivate GroupVisibility = "private"
+	// GroupSecret groups are only known to their members and the users they
+	// invited
+	GroupSecret GroupVisibility = "secret"
+)
+
+// ErrInvalidGroupVisibility is returned for unknown group visibilities
+var ErrInvalidGroupVisibility = errors.New("invalid group visibility")
+
+// ParseGroupVisibility checks a stored or submitted group visibility
+func ParseGroupVisibility(value string) (GroupVisibility, error) {
+	switch visibility := GroupVisibility(value); visibility {
+	case GroupPublic, GroupPrivate, GroupSecret:
+		return visibility, nil
+	}
+	return GroupPrivate, fmt.Errorf("%w: %q", ErrInvalidGroupVisibility, value)
+}
+
+// GroupAccess is how a user relates to a group
+type GroupAccess struct {
+	Visibility GroupVisibility
+	Member     bool
+	Invited    bool // Has a pending invitation
+}
+
+// CanFindGroup reports whether a group shows up for a user, in listings and
+// search, and whether its name and description can be opened
+func CanFindGroup(access GroupAccess) bool {
+	return access.Visibility != GroupSecret || access.Member || access.Invited
+}
+
+// CanReadGroup reports whether a user reads a group's posts, comments,
+// events and members
+func CanReadGroup(access GroupAccess) bool {
+	return access.Member || access.Visibility == GroupPublic
+}
+
+// CanJoinGroup reports whether a user becomes a member without anyone's
+// approval
+func CanJoinGroup(access GroupAccess) bool {
+	return !access.Member && access.Visibility == GroupPublic
+}
+
+// LoadGroupAccess looks up how userID relates to groupID. It returns
+// sql.ErrNoRows when the group doesn't exist.
+func LoadGroupAccess(q Querier, groupID, userID int) (GroupAccess, error) {
+	var access GroupAccess
+	var visibility string
+	err := q.QueryRow(`
+		SELECT g.visibility,
+			EXISTS(SELECT 1 FROM group_members gm WHERE gm.group_id = g.id AND gm.user_id = ?),
+			EXISTS(SELECT 1 FROM group_invitations gi
+				WHERE gi.group_id = g.id AND gi.invitee_id = ?
+				AND gi.type = 'invitation' AND gi.status = 'pending')
+		FROM groups g
+		WHERE g.id = ?`, userID, userID, groupID).Scan(&visibility, &access.Member, &access.Invited)
+	if err != nil {
+		return access, err
+	}
+	access.Visibility, err = ParseGroupVisibility(visibility)
+	return access, err
+}
+
+// FindableGroupsCondition returns the SQL condition, and its arguments, that
+// keeps the rows of the groups table aliased as groups that userID can find.
+// It is CanFindGroup written as SQL.
+func FindableGroupsCondition(groups string, userID int) (string, []interface{}) {
+	condition := fmt.Sprintf(`(
+		%[1]s.visibility != '%[2]s'
+		OR EXISTS(SELECT 1 FROM group_members fgm WHERE fgm.group_id = %[1]s.id AND fgm.user_id = ?)
+		OR EXISTS(SELECT 1 FROM group_invitations fgi
+			WHERE fgi.group_id = %[1]s.id AND fgi.invitee_id = ?
+			AND fgi.type = 'invitation' AND fgi.status = 'pending')
+	)`, groups, GroupSecret)
+	return condition, []interface{}{userID, userID}
+}
+
+// ReadableGroupCondition returns the SQL condition, and its arguments, that
+// keeps the rows whose group ID in column userID can read. It is
+// CanReadGroup written as SQL.
+func ReadableGroupCondition(column string, userID int) (string, []interface{}) {
+	condition := fmt.Sprintf(`(
+		%[1]s IN (SELECT rg.id FROM groups rg WHERE rg.visibility = '%[2]s')
+		OR %[1]s IN (SELECT rgm.group_id FROM group_members rgm WHERE rgm.user_id = ?)
+	)`, column, GroupPublic)
+	return condition, []interface{}{userID}
+}
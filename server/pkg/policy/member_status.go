@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MemberStatus is what moderators left a user allowed to do in a group
+type MemberStatus string
+
+const (
+	// MemberActive users take part as their role allows
+	MemberActive MemberStatus = "active"
+	// MemberMuted members read the group but can't post, comment or chat
+	MemberMuted MemberStatus = "muted"
+	// MemberBanned users were removed and can't join or be invited again
+	MemberBanned MemberStatus = "banned"
+)
+
+// activeMemberStatus is the SQL condition of the bans and mutes, aliased as
+// gms, that haven't expired
+const activeMemberStatus = "(gms.expires_at IS NULL OR gms.expires_at > CURRENT_TIMESTAMP)"
+
+// MemberRestriction is the ban or mute of a user in a group, the zero
+// ExpiresAt never expires
+type MemberRestriction struct {
+	Status    MemberStatus
+	Reason    string
+	ExpiresAt *time.Time
+}
+
+// Speaks reports whether capability adds content that muted members can't
+func (c Capability) Speaks() bool {
+	return c == CapPost || c == CapComment || c == CapCreateEvent
+}
+
+// LoadMemberRestriction returns the active ban or mute of userID in groupID.
+// Users without one are MemberActive.
+func LoadMemberRestriction(q Querier, groupID, userID int) (MemberRestriction, error) {
+	restriction := MemberRestriction{Status: MemberActive}
+	var status string
+	var expiresAt sql.NullTime
+	err := q.QueryRow(`
+		SELECT gms.status, gms.reason, gms.expires_at
+		FROM group_member_status gms
+		WHERE gms.group_id = ? AND gms.user_id = ? AND `+activeMemberStatus,
+		groupID, userID).Scan(&status, &restriction.Reason, &expiresAt)
+	if err == sql.ErrNoRows {
+		return restriction, nil
+	}
+	if err != nil {
+		return restriction, fmt.Errorf("failed to load member status: %w", err)
+	}
+	restriction.Status = MemberStatus(status)
+	if expiresAt.Valid {
+		restriction.ExpiresAt = &expiresAt.Time
+	}
+	return restriction, nil
+}
@@ -0,0 +1,56 @@
+package policy
+
+import "fmt"
+
+// activeMute is the SQL condition of the mutes, aliased as um, that haven't
+// expired
+const activeMute = "(um.expires_at IS NULL OR um.expires_at > CURRENT_TIMESTAMP)"
+
+// HasMutedUser reports whether userID has an active mute on mutedUserID
+func HasMutedUser(q Querier, userID, mutedUserID int) (bool, error) {
+	var muted bool
+	err := q.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_mutes um
+			WHERE um.user_id = ? AND um.muted_user_id = ? AND `+activeMute+`
+		)`, userID, mutedUserID).Scan(&muted)
+	if err != nil {
+		return false, fmt.Errorf("failed to load mute: %w", err)
+	}
+	return muted, nil
+}
+
+// HasMutedGroup reports whether userID has an active mute on groupID
+func HasMutedGroup(q Querier, userID, groupID int) (bool, error) {
+	var muted bool
+	err := q.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_mutes um
+			WHERE um.user_id = ? AND um.muted_group_id = ? AND `+activeMute+`
+		)`, userID, groupID).Scan(&muted)
+	if err != nil {
+		return false, fmt.Errorf("failed to load mute: %w", err)
+	}
+	return muted, nil
+}
+
+// NotMutedUserCondition returns the SQL condition, and its arguments, that
+// drops the rows whose user ID in column userID has muted
+func NotMutedUserCondition(column string, userID int) (string, []interface{}) {
+	condition := fmt.Sprintf(`NOT EXISTS(
+		SELECT 1 FROM user_mutes um
+		WHERE um.user_id = ? AND um.muted_user_id = %s AND %s
+	)`, column, activeMute)
+	return condition, []interface{}{userID}
+}
+
+// NotMutedGroupCondition returns the SQL condition, and its arguments, that
+// drops the rows whose group ID in column userID has muted. NULL IDs are
+// kept.
+func NotMutedGroupCondition(column string, userID int) (string, []interface{}) {
+	condition := fmt.Sprintf(`NOT EXISTS(
+		SELECT 1 FROM user_mutes um
+		WHERE um.user_id = ? AND um.muted_group_id = %s AND %s
+	)`, column, activeMute)
+	return condition, []interface{}{userID}
+}
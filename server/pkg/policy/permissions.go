@@ -0,0 +1,228 @@
+package policy
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// GroupRole is the role of a member in a group
+type GroupRole string
+
+const (
+	RoleMember    GroupRole = "member"
+	RoleModerator GroupRole = "moderator"
+	RoleAdmin     GroupRole = "admin"
+	RoleCreator   GroupRole = "creator"
+)
+
+// GroupRoles lists the roles from the least to the most trusted
+var GroupRoles = []GroupRole{RoleMember, RoleModerator, RoleAdmin, RoleCreator}
+
+// Capability is something a member can be allowed to do in a group
+type Capability string
+
+const (
+	CapPost            Capability = "post"
+	CapComment         Capability = "comment"
+	CapCreateEvent     Capability = "create_event"
+	CapInvite          Capability = "invite"
+	CapApproveRequests Capability = "approve_requests"
+	CapRemoveMember    Capability = "remove_member"
+	CapEditGroup       Capability = "edit_group"
+	CapManageRoles     Capability = "manage_roles"
+	CapPinPost         Capability = "pin_post"
+	CapDeleteContent   Capability = "delete_content"
+)
+
+// Capabilities lists every capability
+var Capabilities = []Capability{
+	CapPost, CapComment, CapCreateEvent, CapInvite, CapApproveRequests,
+	CapRemoveMember, CapEditGroup, CapManageRoles, CapPinPost, CapDeleteContent,
+}
+
+// DefaultCapabilities is what each role can do in groups that didn't change
+// it. The creator can always do everything.
+var DefaultCapabilities = map[GroupRole][]Capability{
+	RoleMember: {CapPost, CapComment, CapCreateEvent, CapInvite},
+	RoleModerator: {CapPost, CapComment, CapCreateEvent, CapInvite,
+		CapRemoveMember, CapPinPost, CapDeleteContent},
+	RoleAdmin: {CapPost, CapComment, CapCreateEvent, CapInvite,
+		CapRemoveMember, CapPinPost, CapDeleteContent,
+		CapApproveRequests, CapEditGroup, CapManageRoles},
+}
+
+var (
+	// ErrInvalidGroupRole is returned for unknown roles
+	ErrInvalidGroupRole = errors.New("invalid group role")
+	// ErrUnknownCapability is returned for unknown capabilities
+	ErrUnknownCapability = errors.New("unknown capability")
+)
+
+// ParseGroupRole checks a stored or submitted role
+func ParseGroupRole(value string) (GroupRole, error) {
+	for _, role := range GroupRoles {
+		if GroupRole(value) == role {
+			return role, nil
+		}
+	}
+	return RoleMember, fmt.Errorf("%w: %q", ErrInvalidGroupRole, value)
+}
+
+// ParseCapability checks a stored or submitted capability
+func ParseCapability(value string) (Capability, error) {
+	for _, capability := range Capabilities {
+		if Capability(value) == capability {
+			return capability, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownCapability, value)
+}
+
+// rank orders the roles, 0 is not a member
+func (r GroupRole) rank() int {
+	for i, role := range GroupRoles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Outranks reports whether r is trusted more than other
+func (r GroupRole) Outranks(other GroupRole) bool {
+	return r.rank() > other.rank()
+}
+
+// IsMember reports whether r is a role at all, the empty role is a
+// non-member
+func (r GroupRole) IsMember() bool {
+	return r.rank() > 0
+}
+
+// GroupPermissions are the capabilities a group granted or took away from
+// its roles, on top of DefaultCapabilities
+type GroupPermissions map[GroupRole]map[Capability]bool
+
+// IsDefault reports whether role has capability unless the group says
+// otherwise
+func IsDefault(role GroupRole, capability Capability) bool {
+	if role == RoleCreator {
+		return true
+	}
+	for _, granted := range DefaultCapabilities[role] {
+		if granted == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// CanOverride reports whether the permissions of role can be changed by a
+// member with editor's role. The creator's can't be, nobody can lock them
+// out of their group.
+func CanOverride(editor, role GroupRole) bool {
+	return role != RoleCreator && role.IsMember() && editor.Outranks(role)
+}
+
+// HasCapability decides whether a member with role can do capability in a
+// group with the given overrides. Non-members can't do anything.
+func HasCapability(role GroupRole, capability Capability, overrides GroupPermissions) bool {
+	if !role.IsMember() {
+		return false
+	}
+	if role == RoleCreator {
+		return true
+	}
+	if allowed, ok := overrides[role][capability]; ok {
+		return allowed
+	}
+	return IsDefault(role, capability)
+}
+
+// Effective lists what every role can do in a group with the given overrides
+func (overrides GroupPermissions) Effective() map[GroupRole][]Capability {
+	effective := make(map[GroupRole][]Capability)
+	for _, role := range GroupRoles {
+		effective[role] = []Capability{}
+		for _, capability := range Capabilities {
+			if HasCapability(role, capability, overrides) {
+				effective[role] = append(effective[role], capability)
+			}
+		}
+	}
+	return effective
+}
+
+// LoadGroupRole returns the role of userID in groupID, empty for
+// non-members
+func LoadGroupRole(q Querier, groupID, userID int) (GroupRole, error) {
+	var role string
+	err := q.QueryRow(`SELECT role FROM group_members WHERE group_id = ? AND user_id = ?`,
+		groupID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load group role: %w", err)
+	}
+	return ParseGroupRole(role)
+}
+
+// GroupQuerier is a Querier that also reads several rows, satisfied by both
+// *sql.DB and *sql.Tx
+type GroupQuerier interface {
+	Querier
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// LoadGroupPermissions returns the overrides a group made to the default
+// capabilities. Stored rows for unknown roles or capabilities are skipped.
+func LoadGroupPermissions(q GroupQuerier, groupID int) (GroupPermissions, error) {
+	rows, err := q.Query(`
+		SELECT role, capability, allowed FROM group_role_permissions WHERE group_id = ?`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load group permissions: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(GroupPermissions)
+	for rows.Next() {
+		var role, capability string
+		var allowed bool
+		if err := rows.Scan(&role, &capability, &allowed); err != nil {
+			return nil, fmt.Errorf("failed to scan group permission: %w", err)
+		}
+		parsedRole, roleErr := ParseGroupRole(role)
+		parsedCapability, capabilityErr := ParseCapability(capability)
+		if roleErr != nil || capabilityErr != nil {
+			continue
+		}
+		if overrides[parsedRole] == nil {
+			overrides[parsedRole] = make(map[Capability]bool)
+		}
+		overrides[parsedRole][parsedCapability] = allowed
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load group permissions: %w", err)
+	}
+	return overrides, nil
+}
+
+// UserHasCapability loads the role of userID in groupID and the group's
+// overrides and decides whether they can do capability. It also returns
+// the role, empty for non-members.
+func UserHasCapability(q GroupQuerier, groupID, userID int, capability Capability) (bool, GroupRole, error) {
+	role, err := LoadGroupRole(q, groupID, userID)
+	if err != nil || !role.IsMember() {
+		return false, role, err
+	}
+	if role == RoleCreator {
+		return true, role, nil
+	}
+	overrides, err := LoadGroupPermissions(q, groupID)
+	if err != nil {
+		return false, role, err
+	}
+	return HasCapability(role, capability, overrides), role, nil
+}
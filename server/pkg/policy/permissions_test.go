@@ -0,0 +1,183 @@
+package policy
+
+import "testing"
+
+// defaultMatrix is what every role can do in a group that didn't change its
+// permissions, written out so a change to DefaultCapabilities shows up here
+var defaultMatrix = map[GroupRole]map[Capability]bool{
+	"": {},
+	RoleMember: {
+		CapPost: true, CapComment: true, CapCreateEvent: true, CapInvite: true,
+	},
+	RoleModerator: {
+		CapPost: true, CapComment: true, CapCreateEvent: true, CapInvite: true,
+		CapRemoveMember: true, CapPinPost: true, CapDeleteContent: true,
+	},
+	RoleAdmin: {
+		CapPost: true, CapComment: true, CapCreateEvent: true, CapInvite: true,
+		CapRemoveMember: true, CapPinPost: true, CapDeleteContent: true,
+		CapApproveRequests: true, CapEditGroup: true, CapManageRoles: true,
+	},
+	RoleCreator: {
+		CapPost: true, CapComment: true, CapCreateEvent: true, CapInvite: true,
+		CapRemoveMember: true, CapPinPost: true, CapDeleteContent: true,
+		CapApproveRequests: true, CapEditGroup: true, CapManageRoles: true,
+	},
+}
+
+func TestHasCapabilityDefaults(t *testing.T) {
+	for role, want := range defaultMatrix {
+		for _, capability := range Capabilities {
+			if got := HasCapability(role, capability, nil); got != want[capability] {
+				t.Errorf("HasCapability(%q, %s) = %v, want %v", role, capability, got, want[capability])
+			}
+		}
+	}
+}
+
+func TestHasCapabilityOverrides(t *testing.T) {
+	everything := func() map[Capability]bool {
+		all := make(map[Capability]bool)
+		for _, capability := range Capabilities {
+			all[capability] = true
+		}
+		return all
+	}
+	nothing := func() map[Capability]bool {
+		none := make(map[Capability]bool)
+		for _, capability := range Capabilities {
+			none[capability] = false
+		}
+		return none
+	}
+
+	tests := []struct {
+		name       string
+		overrides  GroupPermissions
+		role       GroupRole
+		capability Capability
+		want       bool
+	}{
+		{"grant to member", GroupPermissions{RoleMember: {CapPinPost: true}}, RoleMember, CapPinPost, true},
+		{"grant leaves other roles", GroupPermissions{RoleMember: {CapPinPost: true}}, RoleModerator, CapEditGroup, false},
+		{"revoke from member", GroupPermissions{RoleMember: {CapPost: false}}, RoleMember, CapPost, false},
+		{"revoke leaves other capabilities", GroupPermissions{RoleMember: {CapPost: false}}, RoleMember, CapComment, true},
+		{"revoke leaves other roles", GroupPermissions{RoleMember: {CapPost: false}}, RoleModerator, CapPost, true},
+		{"revoke from admin", GroupPermissions{RoleAdmin: {CapEditGroup: false}}, RoleAdmin, CapEditGroup, false},
+		{"grant to moderator", GroupPermissions{RoleModerator: {CapApproveRequests: true}}, RoleModerator, CapApproveRequests, true},
+		{"creator ignores revoke", GroupPermissions{RoleCreator: nothing()}, RoleCreator, CapManageRoles, true},
+		{"non-member ignores grant", GroupPermissions{"": everything()}, "", CapPost, false},
+		{"unknown role ignores grant", GroupPermissions{"owner": everything()}, "owner", CapPost, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasCapability(tt.role, tt.capability, tt.overrides); got != tt.want {
+				t.Errorf("HasCapability(%q, %s) = %v, want %v", tt.role, tt.capability, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasCapabilityCreatorAlwaysAllowed(t *testing.T) {
+	revoked := GroupPermissions{}
+	for _, role := range GroupRoles {
+		revoked[role] = make(map[Capability]bool)
+		for _, capability := range Capabilities {
+			revoked[role][capability] = false
+		}
+	}
+	for _, capability := range Capabilities {
+		if !HasCapability(RoleCreator, capability, revoked) {
+			t.Errorf("creator lost %s", capability)
+		}
+		for _, role := range []GroupRole{RoleMember, RoleModerator, RoleAdmin} {
+			if HasCapability(role, capability, revoked) {
+				t.Errorf("%s kept %s after every capability was revoked", role, capability)
+			}
+		}
+	}
+}
+
+func TestEffective(t *testing.T) {
+	effective := GroupPermissions{RoleMember: {CapPinPost: true, CapInvite: false}}.Effective()
+	want := map[GroupRole][]Capability{
+		RoleMember:    {CapPost, CapComment, CapCreateEvent, CapPinPost},
+		RoleModerator: DefaultCapabilities[RoleModerator],
+		RoleCreator:   Capabilities,
+	}
+	for role, capabilities := range want {
+		got := map[Capability]bool{}
+		for _, capability := range effective[role] {
+			got[capability] = true
+		}
+		if len(got) != len(capabilities) {
+			t.Errorf("Effective()[%s] = %v, want %v", role, effective[role], capabilities)
+			continue
+		}
+		for _, capability := range capabilities {
+			if !got[capability] {
+				t.Errorf("Effective()[%s] misses %s", role, capability)
+			}
+		}
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	roles := []GroupRole{"", RoleMember, RoleModerator, RoleAdmin, RoleCreator}
+	for i, role := range roles {
+		for j, other := range roles {
+			if got, want := role.Outranks(other), i > j; got != want {
+				t.Errorf("%q.Outranks(%q) = %v, want %v", role, other, got, want)
+			}
+		}
+	}
+	if GroupRole("owner").Outranks(RoleMember) {
+		t.Error("an unknown role outranks a member")
+	}
+}
+
+func TestCanOverride(t *testing.T) {
+	tests := []struct {
+		editor, role GroupRole
+		want         bool
+	}{
+		{RoleCreator, RoleAdmin, true},
+		{RoleCreator, RoleModerator, true},
+		{RoleCreator, RoleMember, true},
+		{RoleCreator, RoleCreator, false},
+		{RoleAdmin, RoleModerator, true},
+		{RoleAdmin, RoleMember, true},
+		{RoleAdmin, RoleAdmin, false},
+		{RoleAdmin, RoleCreator, false},
+		{RoleModerator, RoleMember, true},
+		{RoleModerator, RoleModerator, false},
+		{RoleModerator, RoleAdmin, false},
+		{RoleMember, RoleMember, false},
+		{RoleCreator, "", false},
+		{"", RoleMember, false},
+	}
+	for _, tt := range tests {
+		if got := CanOverride(tt.editor, tt.role); got != tt.want {
+			t.Errorf("CanOverride(%q, %q) = %v, want %v", tt.editor, tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestParseGroupRoleAndCapability(t *testing.T) {
+	for _, role := range GroupRoles {
+		if got, err := ParseGroupRole(string(role)); err != nil || got != role {
+			t.Errorf("ParseGroupRole(%q) = %q, %v", role, got, err)
+		}
+	}
+	if _, err := ParseGroupRole("owner"); err == nil {
+		t.Error("ParseGroupRole(owner) succeeded")
+	}
+	for _, capability := range Capabilities {
+		if got, err := ParseCapability(string(capability)); err != nil || got != capability {
+			t.Errorf("ParseCapability(%q) = %q, %v", capability, got, err)
+		}
+	}
+	if _, err := ParseCapability("fly"); err == nil {
+		t.Error("ParseCapability(fly) succeeded")
+	}
+}
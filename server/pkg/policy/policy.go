@@ -0,0 +1,118 @@
+// Package policy decides who can see and do what. Handlers load the facts
+// about a viewer and a piece of content with the loaders in this package and
+// ask the Can* functions, feed queries use the SQL conditions built from the
+// same rules.
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Privacy is the audience of a post
+type Privacy int
+
+const (
+	// Public posts are visible to every user
+	Public Privacy = 0
+	// AlmostPrivate posts are visible to the author's accepted followers
+	AlmostPrivate Privacy = 1
+	// Private posts are visible to the users the author selected, one by one
+	// or through an audience list
+	Private Privacy = 2
+)
+
+// ErrInvalidPrivacy is returned for privacy values outside the known levels
+var ErrInvalidPrivacy = errors.New("invalid privacy value")
+
+// ParsePrivacy checks a stored or submitted privacy value
+func ParsePrivacy(value int) (Privacy, error) {
+	privacy := Privacy(value)
+	if !privacy.Valid() {
+		return Public, fmt.Errorf("%w: %d", ErrInvalidPrivacy, value)
+	}
+	return privacy, nil
+}
+
+// Valid reports whether p is one of the known levels
+func (p Privacy) Valid() bool {
+	return p == Public || p == AlmostPrivate || p == Private
+}
+
+func (p Privacy) String() string {
+	switch p {
+	case Public:
+		return "public"
+	case AlmostPrivate:
+		return "almost private"
+	case Private:
+		return "private"
+	}
+	return fmt.Sprintf("Privacy(%d)", int(p))
+}
+
+// Relation describes how a viewer relates to the owner of some content. Only
+// accepted follows count, pending requests grant nothing.
+type Relation struct {
+	// Self is set when the viewer is the owner
+	Self bool
+	// Follows is set when the viewer follows the owner
+	Follows bool
+	// FollowedBy is set when the owner follows the viewer
+	FollowedBy bool
+	// Blocked is set when either of them blocked the other. It overrides
+	// everything else.
+	Blocked bool
+}
+
+// CanViewPost reports whether a viewer can see a post. selected tells
+// whether the author picked the viewer for a private post, directly or as a
+// current member of the post's audience list.
+func CanViewPost(privacy Privacy, rel Relation, selected bool) bool {
+	if rel.Self {
+		return true
+	}
+	if rel.Blocked {
+		return false
+	}
+	switch privacy {
+	case Public:
+		return true
+	case AlmostPrivate:
+		return rel.Follows
+	case Private:
+		return selected
+	}
+	return false
+}
+
+// CanViewProfile reports whether a viewer sees the full profile of a user,
+// including their posts list. Everyone else only sees the public card.
+func CanViewProfile(ownerPrivate bool, rel Relation) bool {
+	if rel.Blocked {
+		return false
+	}
+	return rel.Self || !ownerPrivate || rel.Follows
+}
+
+// CanSeeFollowers reports whether a viewer can list the followers and
+// followed users of a user. It follows the profile visibility.
+func CanSeeFollowers(ownerPrivate bool, rel Relation) bool {
+	return CanViewProfile(ownerPrivate, rel)
+}
+
+// CanMessage reports whether two users can chat directly. At least one of
+// them has to follow the other.
+func CanMessage(rel Relation) bool {
+	return !rel.Self && !rel.Blocked && (rel.Follows || rel.FollowedBy)
+}
+
+// CanFollow reports whether a viewer can follow or request to follow a user
+func CanFollow(rel Relation) bool {
+	return !rel.Self && !rel.Blocked
+}
+
+// CanInvite reports whether a user can invite another one to a group
+func CanInvite(rel Relation) bool {
+	return !rel.Self && !rel.Blocked
+}
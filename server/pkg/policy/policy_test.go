@@ -0,0 +1,113 @@
+package policy
+
+import "testing"
+
+// relations are the ways a viewer can relate to an owner, by name
+var relations = []struct {
+	name string
+	rel  Relation
+}{
+	{"stranger", Relation{}},
+	{"self", Relation{Self: true}},
+	{"follower", Relation{Follows: true}},
+	{"followed", Relation{FollowedBy: true}},
+	{"mutual", Relation{Follows: true, FollowedBy: true}},
+	{"blocked", Relation{Blocked: true}},
+	{"blocked follower", Relation{Follows: true, Blocked: true}},
+	{"blocked mutual", Relation{Follows: true, FollowedBy: true, Blocked: true}},
+}
+
+// allows lists the relations a rule lets through, the others are denied
+type allows map[string]bool
+
+func TestCanViewPost(t *testing.T) {
+	tests := []struct {
+		privacy  Privacy
+		selected bool
+		want     allows
+	}{
+		{Public, false, allows{"stranger": true, "self": true, "follower": true, "followed": true, "mutual": true}},
+		{Public, true, allows{"stranger": true, "self": true, "follower": true, "followed": true, "mutual": true}},
+		{AlmostPrivate, false, allows{"self": true, "follower": true, "mutual": true}},
+		{AlmostPrivate, true, allows{"self": true, "follower": true, "mutual": true}},
+		{Private, false, allows{"self": true}},
+		{Private, true, allows{"stranger": true, "self": true, "follower": true, "followed": true, "mutual": true}},
+	}
+	for _, tt := range tests {
+		for _, r := range relations {
+			got := CanViewPost(tt.privacy, r.rel, tt.selected)
+			if got != tt.want[r.name] {
+				t.Errorf("CanViewPost(%s, %s, selected=%v) = %v, want %v", tt.privacy, r.name, tt.selected, got, tt.want[r.name])
+			}
+		}
+	}
+}
+
+func TestCanViewPostUnknownPrivacy(t *testing.T) {
+	for _, r := range relations {
+		got := CanViewPost(Privacy(7), r.rel, true)
+		if want := r.rel.Self; got != want {
+			t.Errorf("CanViewPost(Privacy(7), %s) = %v, want %v", r.name, got, want)
+		}
+	}
+}
+
+func TestProfileRules(t *testing.T) {
+	rules := []struct {
+		name string
+		can  func(ownerPrivate bool, rel Relation) bool
+	}{
+		{"CanViewProfile", CanViewProfile},
+		{"CanSeeFollowers", CanSeeFollowers},
+	}
+	tests := []struct {
+		ownerPrivate bool
+		want         allows
+	}{
+		{false, allows{"stranger": true, "self": true, "follower": true, "followed": true, "mutual": true}},
+		{true, allows{"self": true, "follower": true, "mutual": true}},
+	}
+	for _, rule := range rules {
+		for _, tt := range tests {
+			for _, r := range relations {
+				got := rule.can(tt.ownerPrivate, r.rel)
+				if got != tt.want[r.name] {
+					t.Errorf("%s(private=%v, %s) = %v, want %v", rule.name, tt.ownerPrivate, r.name, got, tt.want[r.name])
+				}
+			}
+		}
+	}
+}
+
+func TestRelationRules(t *testing.T) {
+	tests := []struct {
+		name string
+		can  func(rel Relation) bool
+		want allows
+	}{
+		{"CanMessage", CanMessage, allows{"follower": true, "followed": true, "mutual": true}},
+		{"CanFollow", CanFollow, allows{"stranger": true, "follower": true, "followed": true, "mutual": true}},
+		{"CanInvite", CanInvite, allows{"stranger": true, "follower": true, "followed": true, "mutual": true}},
+	}
+	for _, tt := range tests {
+		for _, r := range relations {
+			got := tt.can(r.rel)
+			if got != tt.want[r.name] {
+				t.Errorf("%s(%s) = %v, want %v", tt.name, r.name, got, tt.want[r.name])
+			}
+		}
+	}
+}
+
+func TestParsePrivacy(t *testing.T) {
+	for _, value := range []int{0, 1, 2} {
+		if privacy, err := ParsePrivacy(value); err != nil || int(privacy) != value {
+			t.Errorf("ParsePrivacy(%d) = %v, %v", value, privacy, err)
+		}
+	}
+	for _, value := range []int{-1, 3} {
+		if _, err := ParsePrivacy(value); err == nil {
+			t.Errorf("ParsePrivacy(%d) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PostApproval is whose posts wait for a moderator in a group
+type PostApproval string
+
+const (
+	// ApprovalOff publishes every post right away
+	ApprovalOff PostApproval = "off"
+	// ApprovalNewMembers holds the posts of members who joined recently
+	ApprovalNewMembers PostApproval = "new_members"
+	// ApprovalEveryone holds every post
+	ApprovalEveryone PostApproval = "everyone"
+)
+
+// PostStatus is where a group post is in the approval queue
+type PostStatus string
+
+const (
+	PostPublished PostStatus = "published"
+	PostPending   PostStatus = "pending"
+	PostRejected  PostStatus = "rejected"
+)
+
+// ErrInvalidPostApproval is returned for unknown post approval settings
+var ErrInvalidPostApproval = errors.New("invalid post approval")
+
+// ParsePostApproval checks a stored or submitted post approval setting
+func ParsePostApproval(value string) (PostApproval, error) {
+	switch approval := PostApproval(value); approval {
+	case ApprovalOff, ApprovalNewMembers, ApprovalEveryone:
+		return approval, nil
+	}
+	return ApprovalOff, fmt.Errorf("%w: %q", ErrInvalidPostApproval, value)
+}
+
+// NeedsApproval decides whether a post from a member who joined at joinedAt
+// waits for a moderator, in a group whose new members are the ones who
+// joined less than days ago. Moderators skip the queue, callers check that.
+func NeedsApproval(approval PostApproval, days int, joinedAt, now time.Time) bool {
+	switch approval {
+	case ApprovalEveryone:
+		return true
+	case ApprovalNewMembers:
+		return now.Sub(joinedAt) < time.Duration(days)*24*time.Hour
+	}
+	return false
+}
+
+// PublishedGroupPostCondition returns the SQL condition, and its arguments,
+// that keeps the group posts aliased as posts that are published or that
+// userID wrote
+func PublishedGroupPostCondition(posts string, userID int) (string, []interface{}) {
+	condition := fmt.Sprintf("(%[1]s.status = '%[2]s' OR %[1]s.author_id = ?)", posts, PostPublished)
+	return condition, []interface{}{userID}
+}
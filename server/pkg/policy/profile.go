@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Audience is who can see an optional field of a profile
+type Audience string
+
+const (
+	// AudiencePublic fields are visible to everyone who sees the profile
+	AudiencePublic Audience = "public"
+	// AudienceFollowers fields are visible to accepted followers
+	AudienceFollowers Audience = "followers"
+	// AudienceOnlyMe fields are only visible to the user
+	AudienceOnlyMe Audience = "only_me"
+)
+
+// Profile fields whose audience users can choose
+const (
+	FieldEmail       = "email"
+	FieldDateOfBirth = "date_of_birth"
+	FieldAboutMe     = "about_me"
+)
+
+// ProfileFields lists the fields whose audience users can choose
+var ProfileFields = []string{FieldEmail, FieldDateOfBirth, FieldAboutMe}
+
+var (
+	// ErrInvalidAudience is returned for unknown audiences
+	ErrInvalidAudience = errors.New("invalid audience")
+	// ErrUnknownField is returned for fields that don't have an audience
+	ErrUnknownField = errors.New("unknown profile field")
+)
+
+// ParseAudience checks a stored or submitted audience
+func ParseAudience(value string) (Audience, error) {
+	switch audience := Audience(value); audience {
+	case AudiencePublic, AudienceFollowers, AudienceOnlyMe:
+		return audience, nil
+	}
+	return AudiencePublic, fmt.Errorf("%w: %q", ErrInvalidAudience, value)
+}
+
+// CheckProfileField checks that a field has a configurable audience
+func CheckProfileField(field string) error {
+	for _, known := range ProfileFields {
+		if field == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownField, field)
+}
+
+// CanViewField reports whether a viewer sees a profile field with the given
+// audience. It applies on top of CanViewProfile, which hides every field.
+func CanViewField(audience Audience, rel Relation) bool {
+	if rel.Self {
+		return true
+	}
+	if rel.Blocked {
+		return false
+	}
+	switch audience {
+	case AudiencePublic:
+		return true
+	case AudienceFollowers:
+		return rel.Follows
+	}
+	return false
+}
+
+// LoadFieldAudiences returns the audience of every profile field of a user.
+// Fields they never set are public.
+func LoadFieldAudiences(q Querier, userID int) (map[string]Audience, error) {
+	// Querier only runs single row queries, so the handful of fields is read
+	// as one row
+	var email, dateOfBirth, aboutMe string
+	err := q.QueryRow(`
+		SELECT
+			COALESCE((SELECT audience FROM user_field_visibility WHERE user_id = ? AND field = 'email'), 'public'),
+			COALESCE((SELECT audience FROM user_field_visibility WHERE user_id = ? AND field = 'date_of_birth'), 'public'),
+			COALESCE((SELECT audience FROM user_field_visibility WHERE user_id = ? AND field = 'about_me'), 'public')`,
+		userID, userID, userID).Scan(&email, &dateOfBirth, &aboutMe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load field audiences: %w", err)
+	}
+
+	audiences := make(map[string]Audience, len(ProfileFields))
+	for field, value := range map[string]string{FieldEmail: email, FieldDateOfBirth: dateOfBirth, FieldAboutMe: aboutMe} {
+		audience, err := ParseAudience(value)
+		if err != nil {
+			return nil, err
+		}
+		audiences[field] = audience
+	}
+	return audiences, nil
+}
+
+// VisibleFieldCondition returns the SQL condition, and its arguments, that
+// holds for the rows of the users table aliased as users whose field viewerID
+// can see. It is CanViewProfile and CanViewField written as SQL.
+func VisibleFieldCondition(users, field string, viewerID int) (string, []interface{}) {
+	notBlocked, blockArgs := NotBlockedCondition(users+".id", viewerID)
+	condition := fmt.Sprintf(`(
+		%[1]s.id = ?
+		OR (%[2]s AND (
+			(%[1]s.is_private = 0 AND COALESCE((
+				SELECT audience FROM user_field_visibility fv
+				WHERE fv.user_id = %[1]s.id AND fv.field = ?
+			), '%[3]s') = '%[3]s')
+			OR (COALESCE((
+				SELECT audience FROM user_field_visibility fv
+				WHERE fv.user_id = %[1]s.id AND fv.field = ?
+			), '%[3]s') IN ('%[3]s', '%[4]s') AND EXISTS(
+				SELECT 1 FROM followers ff
+				WHERE ff.follower_id = ? AND ff.followed_id = %[1]s.id AND ff.status = 'accepted'
+			))
+		))
+	)`, users, notBlocked, AudiencePublic, AudienceFollowers)
+	args := []interface{}{viewerID}
+	args = append(args, blockArgs...)
+	return condition, append(args, field, field, viewerID)
+}
@@ -0,0 +1,164 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Querier is satisfied by both *sql.DB and *sql.Tx
+type Querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// LoadRelation looks up how viewerID relates to ownerID
+func LoadRelation(q Querier, viewerID, ownerID int) (Relation, error) {
+	rel := Relation{Self: viewerID == ownerID}
+	if rel.Self {
+		return rel, nil
+	}
+
+	err := q.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM followers WHERE follower_id = ? AND followed_id = ? AND status = 'accepted'),
+			EXISTS(SELECT 1 FROM followers WHERE follower_id = ? AND followed_id = ? AND status = 'accepted'),
+			EXISTS(SELECT 1 FROM user_blocks
+				WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?))`,
+		viewerID, ownerID, ownerID, viewerID, viewerID, ownerID, ownerID, viewerID).
+		Scan(&rel.Follows, &rel.FollowedBy, &rel.Blocked)
+	if err != nil {
+		return rel, fmt.Errorf("failed to load relation: %w", err)
+	}
+	return rel, nil
+}
+
+// CanUserViewPost loads a post and decides whether viewerID can see it. It
+// returns sql.ErrNoRows when the post doesn't exist.
+func CanUserViewPost(q Querier, postID, viewerID int) (bool, error) {
+	var value, authorID int
+	var selected bool
+	err := q.QueryRow(`
+		SELECT p.privacy, p.author,
+			EXISTS(SELECT 1 FROM post_PrivateViews pv WHERE pv.post_id = p.id AND pv.user_id = ?)
+			OR EXISTS(SELECT 1 FROM audience_list_members am WHERE am.list_id = p.audience_list_id AND am.user_id = ?)
+		FROM posts p
+		WHERE p.id = ?`, viewerID, viewerID, postID).Scan(&value, &authorID, &selected)
+	if err != nil {
+		return false, err
+	}
+
+	privacy, err := ParsePrivacy(value)
+	if err != nil {
+		return false, err
+	}
+	rel, err := LoadRelation(q, viewerID, authorID)
+	if err != nil {
+		return false, err
+	}
+	return CanViewPost(privacy, rel, selected), nil
+}
+
+// CanUserViewProfile loads a user's privacy setting and decides whether
+// viewerID sees their full profile. It returns sql.ErrNoRows for unknown
+// users.
+func CanUserViewProfile(q Querier, ownerID, viewerID int) (bool, error) {
+	var isPrivate bool
+	if err := q.QueryRow("SELECT is_private FROM users WHERE id = ?", ownerID).Scan(&isPrivate); err != nil {
+		return false, err
+	}
+	rel, err := LoadRelation(q, viewerID, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return CanViewProfile(isPrivate, rel), nil
+}
+
+// CanUserSeeFollowers is CanSeeFollowers for users loaded from the database
+func CanUserSeeFollowers(q Querier, ownerID, viewerID int) (bool, error) {
+	var isPrivate bool
+	if err := q.QueryRow("SELECT is_private FROM users WHERE id = ?", ownerID).Scan(&isPrivate); err != nil {
+		return false, err
+	}
+	rel, err := LoadRelation(q, viewerID, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return CanSeeFollowers(isPrivate, rel), nil
+}
+
+// CanUsersMessage decides whether two users can chat directly
+func CanUsersMessage(q Querier, userID, otherID int) (bool, error) {
+	rel, err := LoadRelation(q, userID, otherID)
+	if err != nil {
+		return false, err
+	}
+	return CanMessage(rel), nil
+}
+
+// IsBlocked reports whether either user blocked the other
+func IsBlocked(q Querier, userID, otherID int) (bool, error) {
+	rel, err := LoadRelation(q, userID, otherID)
+	if err != nil {
+		return false, err
+	}
+	return rel.Blocked, nil
+}
+
+// VisiblePostsCondition returns the SQL condition, and its arguments, that
+// keeps the rows of the posts table aliased as posts that viewerID can see.
+// It is CanViewPost written as SQL.
+func VisiblePostsCondition(posts string, viewerID int) (string, []interface{}) {
+	notBlocked, blockArgs := NotBlockedCondition(posts+".author", viewerID)
+	condition := fmt.Sprintf(`(
+		%[1]s.author = ?
+		OR (%[5]s AND (
+			%[1]s.privacy = %[2]d
+			OR (%[1]s.privacy = %[3]d AND EXISTS(
+				SELECT 1 FROM followers vf
+				WHERE vf.follower_id = ? AND vf.followed_id = %[1]s.author AND vf.status = 'accepted'
+			))
+			OR (%[1]s.privacy = %[4]d AND (
+				EXISTS(
+					SELECT 1 FROM post_PrivateViews vpv
+					WHERE vpv.post_id = %[1]s.id AND vpv.user_id = ?
+				)
+				OR EXISTS(
+					SELECT 1 FROM audience_list_members vam
+					WHERE vam.list_id = %[1]s.audience_list_id AND vam.user_id = ?
+				)
+			))
+		))
+	)`, posts, Public, AlmostPrivate, Private, notBlocked)
+	args := []interface{}{viewerID}
+	args = append(args, blockArgs...)
+	return condition, append(args, viewerID, viewerID, viewerID)
+}
+
+// MessageableCondition returns the SQL condition, and its arguments, that
+// keeps the users whose ID is in column that userID can chat with. It is
+// CanMessage written as SQL.
+func MessageableCondition(column string, userID int) (string, []interface{}) {
+	notBlocked, blockArgs := NotBlockedCondition(column, userID)
+	condition := fmt.Sprintf(`(
+		%[1]s != ?
+		AND EXISTS(
+			SELECT 1 FROM followers mf
+			WHERE mf.status = 'accepted'
+			AND ((mf.follower_id = ? AND mf.followed_id = %[1]s)
+				OR (mf.follower_id = %[1]s AND mf.followed_id = ?))
+		)
+		AND %[2]s
+	)`, column, notBlocked)
+	return condition, append([]interface{}{userID, userID, userID}, blockArgs...)
+}
+
+// NotBlockedCondition returns the SQL condition, and its arguments, that
+// drops the users whose ID is in column when they blocked userID or userID
+// blocked them. NULL IDs are kept.
+func NotBlockedCondition(column string, userID int) (string, []interface{}) {
+	condition := fmt.Sprintf(`NOT EXISTS(
+		SELECT 1 FROM user_blocks nb
+		WHERE (nb.blocker_id = ? AND nb.blocked_id = %[1]s)
+			OR (nb.blocker_id = %[1]s AND nb.blocked_id = ?)
+	)`, column)
+	return condition, []interface{}{userID, userID}
+}
@@ -0,0 +1,274 @@
+package policy
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// schema holds the columns the conditions read, nothing more
+const schema = `
+	CREATE TABLE users (id INTEGER PRIMARY KEY);
+	CREATE TABLE followers (follower_id INTEGER, followed_id INTEGER, status TEXT);
+	CREATE TABLE user_blocks (blocker_id INTEGER, blocked_id INTEGER);
+	CREATE TABLE posts (id INTEGER PRIMARY KEY, author INTEGER, privacy INTEGER, audience_list_id INTEGER);
+	CREATE TABLE post_PrivateViews (post_id INTEGER, user_id INTEGER);
+	CREATE TABLE audience_list_members (list_id INTEGER, user_id INTEGER);
+	CREATE TABLE groups (id INTEGER PRIMARY KEY, visibility TEXT);
+	CREATE TABLE group_members (group_id INTEGER, user_id INTEGER);
+	CREATE TABLE group_invitations (group_id INTEGER, invitee_id INTEGER, type TEXT, status TEXT);
+`
+
+const (
+	viewerID = 1
+	// otherID is a user unrelated to everyone, rows for them must not let
+	// the viewer in
+	otherID = 2
+)
+
+// fixture is a database with an owner for each of the relations, as seen
+// by viewerID
+type fixture struct {
+	db *sql.DB
+	// owners maps user IDs to the relation the viewer has with them
+	owners map[int]Relation
+	names  map[int]string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	// Every connection to :memory: is its own database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	f := &fixture{db: db, owners: map[int]Relation{}, names: map[int]string{}}
+	f.exec(t, schema)
+	f.exec(t, "INSERT INTO users (id) VALUES (?), (?)", viewerID, otherID)
+
+	for i, r := range relations {
+		ownerID := viewerID
+		if !r.rel.Self {
+			ownerID = 10 + i
+			f.exec(t, "INSERT INTO users (id) VALUES (?)", ownerID)
+		}
+		f.owners[ownerID] = r.rel
+		f.names[ownerID] = r.name
+
+		if r.rel.Follows {
+			f.exec(t, "INSERT INTO followers VALUES (?, ?, 'accepted')", viewerID, ownerID)
+		}
+		if r.rel.FollowedBy {
+			f.exec(t, "INSERT INTO followers VALUES (?, ?, 'accepted')", ownerID, viewerID)
+		}
+		if r.rel.Blocked {
+			// Alternate who blocked whom, both directions count
+			if i%2 == 0 {
+				f.exec(t, "INSERT INTO user_blocks VALUES (?, ?)", viewerID, ownerID)
+			} else {
+				f.exec(t, "INSERT INTO user_blocks VALUES (?, ?)", ownerID, viewerID)
+			}
+		}
+		if !r.rel.Follows && !r.rel.FollowedBy && !r.rel.Self {
+			// Requests that weren't accepted don't make a relation
+			f.exec(t, "INSERT INTO followers VALUES (?, ?, 'pending')", viewerID, ownerID)
+			f.exec(t, "INSERT INTO followers VALUES (?, ?, 'pending')", ownerID, viewerID)
+		}
+		// Neither do the relations the owner has with someone else
+		f.exec(t, "INSERT INTO followers VALUES (?, ?, 'accepted')", otherID, ownerID)
+		f.exec(t, "INSERT INTO user_blocks VALUES (?, ?)", ownerID, otherID)
+	}
+	return f
+}
+
+func (f *fixture) exec(t *testing.T, query string, args ...interface{}) sql.Result {
+	t.Helper()
+	result, err := f.db.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return result
+}
+
+// ids runs a query returning IDs and collects them
+func (f *fixture) ids(t *testing.T, query string, args ...interface{}) map[int]bool {
+	t.Helper()
+	rows, err := f.db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scanning ID: %v", err)
+		}
+		ids[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return ids
+}
+
+func TestVisiblePostsCondition(t *testing.T) {
+	f := newFixture(t)
+
+	// How the viewer can be picked for a post
+	selections := []struct {
+		name     string
+		selected bool
+		add      func(postID int, listID int)
+	}{
+		{"not selected", false, func(postID, listID int) {
+			f.exec(t, "INSERT INTO post_PrivateViews VALUES (?, ?)", postID, otherID)
+			f.exec(t, "INSERT INTO audience_list_members VALUES (?, ?)", listID, otherID)
+		}},
+		{"picked", true, func(postID, listID int) {
+			f.exec(t, "INSERT INTO post_PrivateViews VALUES (?, ?)", postID, viewerID)
+		}},
+		{"in audience list", true, func(postID, listID int) {
+			f.exec(t, "INSERT INTO audience_list_members VALUES (?, ?)", listID, viewerID)
+		}},
+	}
+
+	type post struct {
+		ownerID   int
+		privacy   Privacy
+		selection string
+		want      bool
+	}
+	posts := map[int]post{}
+	for ownerID, rel := range f.owners {
+		for _, privacy := range []Privacy{Public, AlmostPrivate, Private} {
+			for _, s := range selections {
+				listID := len(posts) + 100
+				result := f.exec(t, "INSERT INTO posts (author, privacy, audience_list_id) VALUES (?, ?, ?)",
+					ownerID, privacy, listID)
+				id, _ := result.LastInsertId()
+				s.add(int(id), listID)
+				posts[int(id)] = post{ownerID, privacy, s.name, CanViewPost(privacy, rel, s.selected)}
+			}
+		}
+	}
+
+	condition, args := VisiblePostsCondition("p", viewerID)
+	visible := f.ids(t, "SELECT p.id FROM posts p WHERE "+condition, args...)
+	for id, p := range posts {
+		if visible[id] != p.want {
+			t.Errorf("%s post by %s, %s: visible = %v, CanViewPost = %v",
+				p.privacy, f.names[p.ownerID], p.selection, visible[id], p.want)
+		}
+	}
+}
+
+func TestNotBlockedCondition(t *testing.T) {
+	f := newFixture(t)
+
+	condition, args := NotBlockedCondition("u.id", viewerID)
+	kept := f.ids(t, "SELECT u.id FROM users u WHERE "+condition, args...)
+	for ownerID, rel := range f.owners {
+		if kept[ownerID] == rel.Blocked {
+			t.Errorf("%s: kept = %v, blocked = %v", f.names[ownerID], kept[ownerID], rel.Blocked)
+		}
+	}
+
+	// Rows without a user, like those of a LEFT JOIN, are kept
+	condition, args = NotBlockedCondition("x.id", viewerID)
+	kept = f.ids(t, "SELECT 1 FROM (SELECT NULL AS id) x WHERE "+condition, args...)
+	if !kept[1] {
+		t.Error("a NULL user ID was dropped")
+	}
+}
+
+func TestMessageableCondition(t *testing.T) {
+	f := newFixture(t)
+
+	condition, args := MessageableCondition("u.id", viewerID)
+	messageable := f.ids(t, "SELECT u.id FROM users u WHERE "+condition, args...)
+	for ownerID, rel := range f.owners {
+		if want := CanMessage(rel); messageable[ownerID] != want {
+			t.Errorf("%s: messageable = %v, CanMessage = %v", f.names[ownerID], messageable[ownerID], want)
+		}
+	}
+	if messageable[otherID] {
+		t.Error("an unrelated user is messageable")
+	}
+}
+
+func TestGroupConditions(t *testing.T) {
+	f := newFixture(t)
+
+	// How the viewer can relate to a group, with rows for other users and
+	// invitations that aren't pending which must not count
+	accesses := []struct {
+		name    string
+		member  bool
+		invited bool
+		add     func(groupID int)
+	}{
+		{"stranger", false, false, func(groupID int) {
+			f.exec(t, "INSERT INTO group_members VALUES (?, ?)", groupID, otherID)
+			f.exec(t, "INSERT INTO group_invitations VALUES (?, ?, 'invitation', 'pending')", groupID, otherID)
+		}},
+		{"member", true, false, func(groupID int) {
+			f.exec(t, "INSERT INTO group_members VALUES (?, ?)", groupID, viewerID)
+		}},
+		{"invited", false, true, func(groupID int) {
+			f.exec(t, "INSERT INTO group_invitations VALUES (?, ?, 'invitation', 'pending')", groupID, viewerID)
+		}},
+		{"declined invitation", false, false, func(groupID int) {
+			f.exec(t, "INSERT INTO group_invitations VALUES (?, ?, 'invitation', 'declined')", groupID, viewerID)
+		}},
+		{"join request", false, false, func(groupID int) {
+			f.exec(t, "INSERT INTO group_invitations VALUES (?, ?, 'request', 'pending')", groupID, viewerID)
+		}},
+	}
+
+	groups := map[int]GroupAccess{}
+	names := map[int]string{}
+	for _, visibility := range []GroupVisibility{GroupPublic, GroupPrivate, GroupSecret} {
+		for _, a := range accesses {
+			result := f.exec(t, "INSERT INTO groups (visibility) VALUES (?)", visibility)
+			id, _ := result.LastInsertId()
+			a.add(int(id))
+			groups[int(id)] = GroupAccess{Visibility: visibility, Member: a.member, Invited: a.invited}
+			names[int(id)] = fmt.Sprintf("%s group, %s", visibility, a.name)
+		}
+	}
+
+	condition, args := FindableGroupsCondition("g", viewerID)
+	findable := f.ids(t, "SELECT g.id FROM groups g WHERE "+condition, args...)
+	condition, args = ReadableGroupCondition("g.id", viewerID)
+	readable := f.ids(t, "SELECT g.id FROM groups g WHERE "+condition, args...)
+
+	for id, access := range groups {
+		if want := CanFindGroup(access); findable[id] != want {
+			t.Errorf("%s: findable = %v, CanFindGroup = %v", names[id], findable[id], want)
+		}
+		if want := CanReadGroup(access); readable[id] != want {
+			t.Errorf("%s: readable = %v, CanReadGroup = %v", names[id], readable[id], want)
+		}
+	}
+}
+
+func TestLoadRelation(t *testing.T) {
+	f := newFixture(t)
+
+	for ownerID, want := range f.owners {
+		got, err := LoadRelation(f.db, viewerID, ownerID)
+		if err != nil {
+			t.Fatalf("LoadRelation: %v", err)
+		}
+		if got != want {
+			t.Errorf("LoadRelation(%s) = %+v, want %+v", f.names[ownerID], got, want)
+		}
+	}
+}
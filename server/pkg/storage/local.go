@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local stores objects as files below a root directory
+type Local struct {
+	Root string
+	// URLPrefix is where the application serves the files, signed URLs are
+	// built on it
+	URLPrefix string
+	Signer    *Signer
+}
+
+// NewLocal returns a store writing below root
+func NewLocal(root, urlPrefix string, signer *Signer) *Local {
+	return &Local{Root: root, URLPrefix: urlPrefix, Signer: signer}
+}
+
+// path maps a key to a file below the root. Cleaning the key as an absolute
+// path first keeps ".." from escaping the root.
+func (l *Local) path(key string) string {
+	return filepath.Join(l.Root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// Put writes the object to a temporary file first so readers never see a
+// partial file
+func (l *Local) Put(key string, r io.Reader, size int64, contentType string) error {
+	dst := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+func (l *Local) Get(key string) (io.ReadCloser, *ObjectInfo, error) {
+	file, err := os.Open(l.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, nil, ErrNotFound
+	}
+	return file, l.info(key, stat), nil
+}
+
+func (l *Local) Stat(key string) (*ObjectInfo, error) {
+	stat, err := os.Stat(l.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, ErrNotFound
+	}
+	return l.info(key, stat), nil
+}
+
+func (l *Local) Delete(key string) error {
+	err := os.Remove(l.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) SignedURL(key string, ttl time.Duration) (string, error) {
+	return l.Signer.URL(l.URLPrefix+"/"+key, ttl), nil
+}
+
+func (l *Local) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(l.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == l.Root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		// Skip directories and unfinished temporary files
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// info builds the metadata for a file. Stored files are never rewritten in
+// place, so modification time and size identify the content.
+func (l *Local) info(key string, stat os.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:         key,
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     stat.ModTime(),
+		ETag:        fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+	}
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readObject(t *testing.T, store BlobStore, key string) string {
+	t.Helper()
+	body, _, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", key, err)
+	}
+	return string(data)
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocal(root, "/uploads", NewSigner([]byte("secret")))
+
+	const key = "posts/a photo.png"
+	if err := store.Put(key, strings.NewReader("image data"), 10, "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "posts", "a photo.png")); err != nil {
+		t.Errorf("Put didn't write below the root: %v", err)
+	}
+
+	info, err := store.Stat(key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != key || info.Size != 10 || info.ContentType != "image/png" || info.ETag == "" || info.ModTime.IsZero() {
+		t.Errorf("Stat = %+v, want size 10, image/png, an ETag and a modification time", info)
+	}
+	if got := readObject(t, store, key); got != "image data" {
+		t.Errorf("Get = %q, want %q", got, "image data")
+	}
+
+	// Put replaces the object, and its ETag with it
+	time.Sleep(10 * time.Millisecond)
+	if err := store.Put(key, strings.NewReader("new image data"), 14, "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := readObject(t, store, key); got != "new image data" {
+		t.Errorf("Get after replacing = %q, want %q", got, "new image data")
+	}
+	replaced, err := store.Stat(key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if replaced.ETag == info.ETag {
+		t.Error("ETag didn't change when the object was replaced")
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Stat(key); err != ErrNotFound {
+		t.Errorf("Stat after Delete = %v, want ErrNotFound", err)
+	}
+	if _, _, err := store.Get(key); err != ErrNotFound {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Delete of a missing object = %v, want nil", err)
+	}
+
+	// Directories aren't objects
+	if err := store.Put("posts/nested/x.png", strings.NewReader("x"), 1, "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := store.Stat("posts/nested"); err != ErrNotFound {
+		t.Errorf("Stat of a directory = %v, want ErrNotFound", err)
+	}
+	if _, _, err := store.Get("posts/nested"); err != ErrNotFound {
+		t.Errorf("Get of a directory = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalKeysStayBelowRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "uploads")
+	store := NewLocal(root, "/uploads", nil)
+
+	if err := store.Put("../escaped.png", strings.NewReader("x"), 1, "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.png")); !os.IsNotExist(err) {
+		t.Error("a key with .. was written outside the root")
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.png")); err != nil {
+		t.Errorf("a key with .. wasn't kept below the root: %v", err)
+	}
+}
+
+func TestLocalList(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocal(root, "/uploads", nil)
+
+	// A store that never received a file lists nothing
+	empty := NewLocal(filepath.Join(root, "missing"), "/uploads", nil)
+	if keys, err := empty.List(""); err != nil || len(keys) != 0 {
+		t.Errorf("List on a missing root = %v, %v, want nothing", keys, err)
+	}
+
+	for _, key := range []string{"posts/1.png", "posts/2.png", "avatars/1.png", "group_posts/1.png"} {
+		if err := store.Put(key, strings.NewReader(key), int64(len(key)), "image/png"); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	// An interrupted upload leaves a temporary file behind
+	if err := os.WriteFile(filepath.Join(root, "posts", ".upload-123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"posts/", []string{"posts/1.png", "posts/2.png"}},
+		{"avatars/", []string{"avatars/1.png"}},
+		{"groups/", nil},
+		{"", []string{"avatars/1.png", "group_posts/1.png", "posts/1.png", "posts/2.png"}},
+	}
+	for _, tt := range tests {
+		keys, err := store.List(tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tt.prefix, err)
+		}
+		sort.Strings(keys)
+		if strings.Join(keys, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+}
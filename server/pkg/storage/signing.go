@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Signer creates and checks HMAC signed URLs for files the application
+// serves itself
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a signer using the given secret key
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign returns the signature for a path that is valid until expires (unix seconds)
+func (s *Signer) Sign(path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// URL returns path with an expiry and signature attached. The expiry is
+// rounded up to the next ttl window so the URL stays the same, and
+// cacheable, for a while.
+func (s *Signer) URL(path string, ttl time.Duration) string {
+	expires := windowStart(ttl).Add(2 * ttl).Unix()
+
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	query.Set("sig", s.Sign(path, expires))
+	return path + "?" + query.Encode()
+}
+
+// Verify checks the exp and sig query values for a path. It returns the
+// expiry time when the signature is valid and not expired.
+func (s *Signer) Verify(path, exp, sig string) (time.Time, bool) {
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	expected := s.Sign(path, expires)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return time.Time{}, false
+	}
+
+	expiry := time.Unix(expires, 0)
+	if time.Now().After(expiry) {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+// windowStart returns the start of the current ttl window
+func windowStart(ttl time.Duration) time.Time {
+	window := int64(ttl / time.Second)
+	if window <= 0 {
+		window = 1
+	}
+	return time.Unix(time.Now().Unix()/window*window, 0).UTC()
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a key doesn't exist in a store
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	// ETag is quoted, ready to be used as a response header
+	ETag string
+}
+
+// BlobStore stores uploaded files by key. Keys are slash separated paths
+// such as "posts/<name>".
+type BlobStore interface {
+	// Put writes an object, replacing any object with the same key
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get opens an object for reading, the caller closes it
+	Get(key string) (io.ReadCloser, *ObjectInfo, error)
+	// Stat returns the object metadata without its content
+	Stat(key string) (*ObjectInfo, error)
+	// Delete removes an object. Missing objects are not an error.
+	Delete(key string) error
+	// SignedURL returns a URL the object can be fetched from without a
+	// session for at least ttl
+	SignedURL(key string, ttl time.Duration) (string, error)
+	// List returns the keys starting with prefix
+	List(prefix string) ([]string, error)
+}
+
+// Open creates the store of the given kind ("local" or "s3") from its
+// environment settings:
+//
+//	local: MEDIA_UPLOAD_DIR (default ./uploads)
+//	s3:    S3_ENDPOINT, S3_REGION, S3_BUCKET, S3_ACCESS_KEY_ID,
+//	       S3_SECRET_ACCESS_KEY, S3_PATH_STYLE
+//
+// The signer is used by the local store, which serves files itself under
+// urlPrefix.
+func Open(kind string, signer *Signer, urlPrefix string) (BlobStore, error) {
+	switch strings.ToLower(kind) {
+	case "", "local":
+		root := os.Getenv("MEDIA_UPLOAD_DIR")
+		if root == "" {
+			root = "./uploads"
+		}
+		return NewLocal(root, urlPrefix, signer), nil
+
+	case "s3":
+		store := &S3{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		}
+		if store.Region == "" {
+			store.Region = "us-east-1"
+		}
+		if store.Endpoint == "" {
+			store.Endpoint = "https://s3." + store.Region + ".amazonaws.com"
+		}
+		if store.Bucket == "" || store.AccessKey == "" || store.SecretKey == "" {
+			return nil, fmt.Errorf("s3 storage needs S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+		}
+		return store, nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend %q", kind)
+}
+
+// Copy copies every object under prefix from src to dst. Objects that
+// already exist in dst are skipped, so an interrupted copy can be resumed.
+func Copy(src, dst BlobStore, prefix string) (copied, skipped int, err error) {
+	keys, err := src.List(prefix)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list source objects: %w", err)
+	}
+
+	for _, key := range keys {
+		if _, err := dst.Stat(key); err == nil {
+			skipped++
+			continue
+		} else if !errors.Is(err, ErrNotFound) {
+			return copied, skipped, fmt.Errorf("failed to check %s: %w", key, err)
+		}
+
+		body, info, err := src.Get(key)
+		if err != nil {
+			return copied, skipped, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		err = dst.Put(key, body, info.Size, info.ContentType)
+		body.Close()
+		if err != nil {
+			return copied, skipped, fmt.Errorf("failed to write %s: %w", key, err)
+		}
+
+		log.Printf("Copied %s (%d bytes)", key, info.Size)
+		copied++
+	}
+	return copied, skipped, nil
+}
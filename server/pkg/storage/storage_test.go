@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// failingStore wraps a store and fails Put for one key
+type failingStore struct {
+	BlobStore
+	failKey string
+}
+
+var errPutFailed = errors.New("disk full")
+
+func (f *failingStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	if key == f.failKey {
+		return errPutFailed
+	}
+	return f.BlobStore.Put(key, r, size, contentType)
+}
+
+func TestCopy(t *testing.T) {
+	src := NewLocal(t.TempDir(), "/uploads", nil)
+	_, dst := newFakeS3(t)
+
+	objects := map[string]string{
+		"posts/1.png":   "one",
+		"posts/2.jpg":   "two",
+		"avatars/1.gif": "avatar",
+	}
+	for key, data := range objects {
+		if err := src.Put(key, strings.NewReader(data), int64(len(data)), ""); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	// One object was copied by an earlier run, it must not be overwritten
+	if err := dst.Put("posts/1.png", strings.NewReader("kept"), 4, "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	copied, skipped, err := Copy(src, dst, "posts/")
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if copied != 1 || skipped != 1 {
+		t.Errorf("Copy = %d copied, %d skipped, want 1 and 1", copied, skipped)
+	}
+	if got := readObject(t, dst, "posts/1.png"); got != "kept" {
+		t.Errorf("existing object was overwritten with %q", got)
+	}
+	if got := readObject(t, dst, "posts/2.jpg"); got != "two" {
+		t.Errorf("copied object = %q, want %q", got, "two")
+	}
+	// The content type comes from the source
+	if info, err := dst.Stat("posts/2.jpg"); err != nil || info.ContentType != "image/jpeg" {
+		t.Errorf("copied object info = %+v, %v, want image/jpeg", info, err)
+	}
+	if _, err := dst.Stat("avatars/1.gif"); err != ErrNotFound {
+		t.Errorf("object outside the prefix was copied: %v", err)
+	}
+
+	// Running it again over everything only copies what's missing
+	copied, skipped, err = Copy(src, dst, "")
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if copied != 1 || skipped != 2 {
+		t.Errorf("second Copy = %d copied, %d skipped, want 1 and 2", copied, skipped)
+	}
+	keys, err := dst.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	sort.Strings(keys)
+	if want := "avatars/1.gif,posts/1.png,posts/2.jpg"; strings.Join(keys, ",") != want {
+		t.Errorf("destination holds %v, want %s", keys, want)
+	}
+}
+
+func TestCopyStopsOnError(t *testing.T) {
+	src := NewLocal(t.TempDir(), "/uploads", nil)
+	dst := &failingStore{BlobStore: NewLocal(t.TempDir(), "/uploads", nil), failKey: "posts/2.png"}
+
+	for _, key := range []string{"posts/1.png", "posts/2.png", "posts/3.png"} {
+		if err := src.Put(key, strings.NewReader(key), int64(len(key)), ""); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+
+	copied, _, err := Copy(src, dst, "posts/")
+	if !errors.Is(err, errPutFailed) {
+		t.Fatalf("Copy error = %v, want the Put error", err)
+	}
+	if !strings.Contains(err.Error(), "posts/2.png") {
+		t.Errorf("Copy error %q doesn't name the key", err)
+	}
+	if copied != 1 {
+		t.Errorf("Copy copied %d objects before failing, want 1", copied)
+	}
+
+	// Resuming after the failure finishes the job
+	dst.failKey = ""
+	copied, skipped, err := Copy(src, dst, "posts/")
+	if err != nil {
+		t.Fatalf("resumed Copy: %v", err)
+	}
+	if copied != 2 || skipped != 1 {
+		t.Errorf("resumed Copy = %d copied, %d skipped, want 2 and 1", copied, skipped)
+	}
+}
@@ -71,3 +71,11 @@ func DestroySession(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// RenameSessions moves the sessions of a user to their new username
+func RenameSessions(oldUsername, newUsername string) {
+	for sessionID, username := range UserSession {
+		if username == oldUsername {
+			UserSession[sessionID] = newUsername
+		}
+	}
+}